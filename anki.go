@@ -6,29 +6,85 @@ import (
 	"crypto/sha1"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/ezynda3/go-anki-deck/internal/text"
 )
 
 const separator = "\u001F"
 
+const (
+	workingCollection = "collection.anki2"
+	workingMediaDir   = "media"
+)
+
 // Deck represents an Anki deck that can be exported as .apkg
 type Deck struct {
-	name       string
-	db         *sql.DB
-	media      []Media
-	topDeckID  int64
-	topModelID int64
+	name        string
+	db          *sql.DB
+	media       []Media
+	topDeckID   int64
+	topModelID  int64
+	mediaDir    string        // media directory of a working deck, empty for in-memory decks
+	sortFields  map[int64]int // cached sort field index per model ID
+	fieldCounts map[int64]int // cached number of fields per model ID
+
+	duplicatePolicy   DuplicatePolicy
+	allowMissingMedia bool         // Save even if notes reference media not in the deck
+	logger            *slog.Logger // nil for silence
+	onProgress        ProgressFunc
+}
+
+// DuplicatePolicy controls what happens when a card is added whose first
+// field matches an existing note, the same check Anki uses to flag duplicates
+type DuplicatePolicy int
+
+const (
+	// DuplicateIdentical adds the card as a separate note unless a note has
+	// exactly the same fields, which is left as is (default)
+	DuplicateIdentical DuplicatePolicy = iota
+	// DuplicateUpdate replaces the fields and tags of the existing note
+	DuplicateUpdate
+	// DuplicateAllow adds the card as a separate note
+	DuplicateAllow
+	// DuplicateSkip keeps the existing note and ignores the new card
+	DuplicateSkip
+	// DuplicateError rejects the card with a *DuplicateNoteError
+	DuplicateError
+)
+
+// ErrDuplicateNote is matched by errors.Is for errors caused by duplicate notes
+var ErrDuplicateNote = errors.New("duplicate note")
+
+// DuplicateNoteError is returned when a card is rejected by DuplicateError.
+// It matches ErrDuplicateNote and carries the ID of the existing note.
+type DuplicateNoteError struct {
+	NoteID int64
+}
+
+func (e *DuplicateNoteError) Error() string {
+	return fmt.Sprintf("duplicate note: first field matches note %d", e.NoteID)
+}
+
+// Is reports whether target is ErrDuplicateNote
+func (e *DuplicateNoteError) Is(target error) bool {
+	return target == ErrDuplicateNote
 }
 
 // Media represents a media file to be included in the deck
 type Media struct {
 	Filename string
 	Data     []byte
+	Path     string // Location of the file on disk when Data is not held in memory
 }
 
 // CardOptions represents optional parameters for adding cards
@@ -75,6 +131,62 @@ func NewDeckWithTemplate(name string, templateOpts *TemplateOptions) (*Deck, err
 	return deck, nil
 }
 
+// OpenWorkingDeck opens the working deck stored in dir, creating it if needed.
+// Unlike NewDeck, the collection is kept in a SQLite file and media files in a
+// directory next to it, so cards added in earlier runs survive a restart.
+// The name is only used when the working deck is created.
+func OpenWorkingDeck(dir, name string) (*Deck, error) {
+	return OpenWorkingDeckWithTemplate(dir, name, nil)
+}
+
+// OpenWorkingDeckWithTemplate opens or creates a working deck with custom template options.
+// The template options are only used when the working deck is created.
+func OpenWorkingDeckWithTemplate(dir, name string, templateOpts *TemplateOptions) (*Deck, error) {
+	mediaDir := filepath.Join(dir, workingMediaDir)
+	if err := os.MkdirAll(mediaDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create media directory: %w", err)
+	}
+
+	dbPath := filepath.Join(dir, workingCollection)
+	_, statErr := os.Stat(dbPath)
+	exists := statErr == nil
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	deck := &Deck{
+		name:     name,
+		db:       db,
+		media:    []Media{},
+		mediaDir: mediaDir,
+	}
+
+	if exists {
+		if err := deck.loadDatabase(); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to load database: %w", err)
+		}
+	} else if err := deck.initializeDatabase(templateOpts); err != nil {
+		_ = db.Close()
+		_ = os.Remove(dbPath)
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	if err := deck.loadMedia(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to load media: %w", err)
+	}
+
+	return deck, nil
+}
+
+// SetDuplicatePolicy sets how cards duplicating an existing note are handled
+func (d *Deck) SetDuplicatePolicy(policy DuplicatePolicy) {
+	d.duplicatePolicy = policy
+}
+
 // AddCard adds a new card to the deck
 func (d *Deck) AddCard(front, back string) error {
 	return d.AddCardWithOptions(front, back, nil)
@@ -82,8 +194,6 @@ func (d *Deck) AddCard(front, back string) error {
 
 // AddCardWithOptions adds a new card with optional parameters
 func (d *Deck) AddCardWithOptions(front, back string, opts *CardOptions) error {
-	now := time.Now().UnixMilli()
-
 	// Handle media attachments if provided
 	if opts != nil {
 		// Audio attachments
@@ -109,74 +219,175 @@ func (d *Deck) AddCardWithOptions(front, back string, opts *CardOptions) error {
 		}
 	}
 
-	noteGUID := d.getNoteGUID(d.topDeckID, front, back)
-	noteID := d.getNoteID(noteGUID, now)
+	var tags []string
+	if opts != nil {
+		tags = opts.Tags
+	}
+
+	// A note type pulled from Anki may have more fields, which are left empty
+	fields, err := d.cardFields(front, back)
+	if err != nil {
+		return err
+	}
 
-	var tagsStr string
-	if opts != nil && len(opts.Tags) > 0 {
-		tags := make([]string, len(opts.Tags))
-		for i, tag := range opts.Tags {
-			tags[i] = strings.ReplaceAll(tag, " ", "_")
+	switch d.duplicatePolicy {
+	case DuplicateAllow:
+	case DuplicateIdentical:
+		existingID, err := d.findIdentical(d.topModelID, fields)
+		if err != nil {
+			return fmt.Errorf("failed to check for duplicates: %w", err)
+		}
+		if existingID != 0 {
+			d.log(nil).Debug("skipped identical card", "existing_note_id", existingID)
+			return nil
+		}
+	default:
+		_, csum := d.sortFieldAndChecksum(d.topModelID, fields)
+		existingID, err := d.findDuplicate(d.topModelID, csum, front)
+		if err != nil {
+			return fmt.Errorf("failed to check for duplicates: %w", err)
+		}
+		if existingID != 0 {
+			switch d.duplicatePolicy {
+			case DuplicateSkip:
+				d.log(nil).Debug("skipped duplicate card", "existing_note_id", existingID)
+				return nil
+			case DuplicateError:
+				return &DuplicateNoteError{NoteID: existingID}
+			default:
+				existing, err := d.GetNote(existingID)
+				if err != nil {
+					return err
+				}
+				existing.Fields[0], existing.Fields[1] = front, back
+				existing.Tags = tags
+				return d.UpdateNote(existing)
+			}
 		}
-		tagsStr = " " + strings.Join(tags, " ") + " "
 	}
 
-	// Insert or update note
-	_, err := d.db.Exec(`
-		INSERT OR REPLACE INTO notes 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		noteID,                           // id
-		noteGUID,                         // guid
-		d.topModelID,                     // mid
-		d.getID("notes", "mod", now),     // mod
-		-1,                               // usn
-		tagsStr,                          // tags
-		front+separator+back,             // flds
-		front,                            // sfld
-		d.checksum(front+separator+back), // csum
-		0,                                // flags
-		"",                               // data
-	)
+	_, err = d.insertNote(fields, tags)
+	return err
+}
+
+// cardFields returns the fields of a note of the deck's note type with the
+// front and back of a card
+func (d *Deck) cardFields(front, back string) ([]string, error) {
+	n := d.fieldCount(d.topModelID)
+	if n < 2 {
+		return nil, fmt.Errorf("note type has %d fields, cards need 2", n)
+	}
+	fields := make([]string, n)
+	fields[0], fields[1] = front, back
+	return fields, nil
+}
+
+// insertNote adds a note with a single card to the deck and returns the note ID
+func (d *Deck) insertNote(fields, tags []string) (int64, error) {
+	return d.insertModelNote(d.topModelID, []int{0}, fields, tags)
+}
+
+// insertModelNote adds a note of the given note type to the deck with a card
+// for each template ord
+func (d *Deck) insertModelNote(mid int64, ords []int, fields, tags []string) (int64, error) {
+	now := time.Now().UnixMilli()
+	sfld, csum := d.sortFieldAndChecksum(mid, fields)
+
+	noteGUID, err := d.uniqueNoteGUID(fields)
 	if err != nil {
-		return fmt.Errorf("failed to insert note: %w", err)
+		return 0, fmt.Errorf("failed to generate note GUID: %w", err)
 	}
+	noteID := d.getID("notes", "id", now)
 
-	// Insert or update card
+	// Insert note
 	_, err = d.db.Exec(`
-		INSERT OR REPLACE INTO cards 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		d.getCardID(noteID, now),     // id
-		noteID,                       // nid
-		d.topDeckID,                  // did
-		0,                            // ord
-		d.getID("cards", "mod", now), // mod
-		-1,                           // usn
-		0,                            // type
-		0,                            // queue
-		179,                          // due
-		0,                            // ivl
-		0,                            // factor
-		0,                            // reps
-		0,                            // lapses
-		0,                            // left
-		0,                            // odue
-		0,                            // odid
-		0,                            // flags
-		"",                           // data
+		INSERT INTO notes 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		noteID,                          // id
+		noteGUID,                        // guid
+		mid,                             // mid
+		d.getID("notes", "mod", now),    // mod
+		-1,                              // usn
+		formatTags(tags),                // tags
+		strings.Join(fields, separator), // flds
+		sfld,                            // sfld
+		csum,                            // csum
+		0,                               // flags
+		"",                              // data
 	)
 	if err != nil {
-		return fmt.Errorf("failed to insert card: %w", err)
+		return 0, fmt.Errorf("failed to insert note: %w", err)
+	}
+
+	// Insert cards
+	for _, ord := range ords {
+		_, err = d.db.Exec(`
+			INSERT INTO cards 
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			d.getID("cards", "id", now),  // id
+			noteID,                       // nid
+			d.topDeckID,                  // did
+			ord,                          // ord
+			d.getID("cards", "mod", now), // mod
+			-1,                           // usn
+			0,                            // type
+			0,                            // queue
+			179,                          // due
+			0,                            // ivl
+			0,                            // factor
+			0,                            // reps
+			0,                            // lapses
+			0,                            // left
+			0,                            // odue
+			0,                            // odid
+			0,                            // flags
+			"",                           // data
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to insert card: %w", err)
+		}
 	}
 
-	return nil
+	return noteID, nil
 }
 
-// AddMedia adds a media file to the deck
+// AddMedia adds a media file to the deck.
+// For a working deck the file is written to the media directory. A file that
+// can't be written is not added and the error is logged; use AddMediaFile to
+// handle it instead.
 func (d *Deck) AddMedia(filename string, data []byte) {
-	d.media = append(d.media, Media{
-		Filename: filename,
-		Data:     data,
-	})
+	if err := d.AddMediaFile(filename, data); err != nil {
+		d.log(nil).Error("failed to add media file", "filename", filename, "error", err)
+	}
+}
+
+// AddMediaFile is like AddMedia but returns an error if a working deck can't
+// write the file. The filename must not contain a directory for a working
+// deck, so the file keeps its name when the deck is reopened.
+func (d *Deck) AddMediaFile(filename string, data []byte) error {
+	if d.mediaDir == "" {
+		d.media = append(d.media, Media{Filename: filename, Data: data})
+		return nil
+	}
+
+	if filename == "" || filepath.Base(filename) != filename || filename == "." || filename == ".." {
+		return fmt.Errorf("invalid media filename %q", filename)
+	}
+	path := filepath.Join(d.mediaDir, filename)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write media file: %w", err)
+	}
+	media := Media{Filename: filename, Path: path}
+
+	// A working deck may already know the file from an earlier run
+	for i := range d.media {
+		if d.media[i].Filename == filename {
+			d.media[i] = media
+			return nil
+		}
+	}
+	d.media = append(d.media, media)
+	return nil
 }
 
 // AddAudio adds an audio file to the deck and returns the Anki sound tag
@@ -199,7 +410,9 @@ func (d *Deck) AddVideo(filename string, data []byte) string {
 
 // AddCardWithAudio adds a card with an audio file attached to the back
 func (d *Deck) AddCardWithAudio(front, back, audioFile string, audioData []byte) error {
-	d.AddMedia(audioFile, audioData)
+	if err := d.AddMediaFile(audioFile, audioData); err != nil {
+		return err
+	}
 	return d.AddCardWithOptions(front, back, &CardOptions{
 		BackAudio: audioFile,
 	})
@@ -207,7 +420,9 @@ func (d *Deck) AddCardWithAudio(front, back, audioFile string, audioData []byte)
 
 // AddCardWithImage adds a card with an image file attached to the back
 func (d *Deck) AddCardWithImage(front, back, imageFile string, imageData []byte) error {
-	d.AddMedia(imageFile, imageData)
+	if err := d.AddMediaFile(imageFile, imageData); err != nil {
+		return err
+	}
 	return d.AddCardWithOptions(front, back, &CardOptions{
 		BackImage: imageFile,
 	})
@@ -215,14 +430,30 @@ func (d *Deck) AddCardWithImage(front, back, imageFile string, imageData []byte)
 
 // AddCardWithVideo adds a card with a video file attached to the back
 func (d *Deck) AddCardWithVideo(front, back, videoFile string, videoData []byte) error {
-	d.AddMedia(videoFile, videoData)
+	if err := d.AddMediaFile(videoFile, videoData); err != nil {
+		return err
+	}
 	return d.AddCardWithOptions(front, back, &CardOptions{
 		BackVideo: videoFile,
 	})
 }
 
-// Save exports the deck as an .apkg file
+// SetAllowMissingMedia makes Save export decks whose notes reference media
+// files that are not in the deck, for media already in the user's collection
+func (d *Deck) SetAllowMissingMedia(allow bool) {
+	d.allowMissingMedia = allow
+}
+
+// Save exports the deck as an .apkg file. It returns a *MissingMediaError if
+// notes reference media files that are not in the deck, unless allowed with
+// SetAllowMissingMedia.
 func (d *Deck) Save() ([]byte, error) {
+	if !d.allowMissingMedia {
+		if err := d.ValidateMedia(); err != nil {
+			return nil, err
+		}
+	}
+
 	// Export database
 	var dbData bytes.Buffer
 	if err := d.exportDatabase(&dbData); err != nil {
@@ -261,14 +492,24 @@ func (d *Deck) Save() ([]byte, error) {
 	}
 
 	// Add media files
+	var written int64
 	for i, m := range d.media {
+		d.progress(Progress{Phase: PhaseMedia, Done: i, Total: len(d.media), Bytes: written})
+		data, err := m.content()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read media file %s: %w", m.Filename, err)
+		}
 		f, err := w.Create(strconv.Itoa(i))
 		if err != nil {
 			return nil, fmt.Errorf("failed to create media file %d: %w", i, err)
 		}
-		if _, err := f.Write(m.Data); err != nil {
+		if _, err := f.Write(data); err != nil {
 			return nil, fmt.Errorf("failed to write media file %d: %w", i, err)
 		}
+		written += int64(len(data))
+	}
+	if len(d.media) > 0 {
+		d.progress(Progress{Phase: PhaseMedia, Done: len(d.media), Total: len(d.media), Bytes: written})
 	}
 
 	if err := w.Close(); err != nil {
@@ -306,9 +547,76 @@ func (d *Deck) initializeDatabase(templateOpts *TemplateOptions) error {
 		return fmt.Errorf("failed to update model: %w", err)
 	}
 
+	return d.createRemoteNotes()
+}
+
+// loadDatabase restores the deck and model IDs from an existing collection
+func (d *Deck) loadDatabase() error {
+	var decksJSON, modelsJSON string
+	err := d.db.QueryRow("SELECT decks, models FROM col WHERE id = 1").Scan(&decksJSON, &modelsJSON)
+	if err != nil {
+		return err
+	}
+
+	var decks map[string]struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(decksJSON), &decks); err != nil {
+		return err
+	}
+	for _, deck := range decks {
+		if deck.ID != 1 {
+			d.topDeckID = deck.ID
+			d.name = deck.Name
+		}
+	}
+
+	var models map[string]struct {
+		ID     int64 `json:"id"`
+		DeckID int64 `json:"did"`
+	}
+	if err := json.Unmarshal([]byte(modelsJSON), &models); err != nil {
+		return err
+	}
+	for _, model := range models {
+		if model.DeckID == d.topDeckID {
+			d.topModelID = model.ID
+		}
+	}
+
+	if d.topDeckID == 0 || d.topModelID == 0 {
+		return fmt.Errorf("collection does not contain a deck and model")
+	}
+	return d.createRemoteNotes()
+}
+
+// loadMedia registers the files already present in a working deck's media directory
+func (d *Deck) loadMedia() error {
+	entries, err := os.ReadDir(d.mediaDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		d.media = append(d.media, Media{
+			Filename: entry.Name(),
+			Path:     filepath.Join(d.mediaDir, entry.Name()),
+		})
+	}
 	return nil
 }
 
+// content returns the media data, reading it from disk if it is not held in memory
+func (m Media) content() ([]byte, error) {
+	if m.Data != nil || m.Path == "" {
+		return m.Data, nil
+	}
+	return os.ReadFile(m.Path)
+}
+
 func (d *Deck) updateDeckName() error {
 	var decksJSON string
 	err := d.db.QueryRow("SELECT decks FROM col WHERE id = 1").Scan(&decksJSON)
@@ -321,10 +629,12 @@ func (d *Deck) updateDeckName() error {
 		return err
 	}
 
-	// Get the last deck and update it
+	// Get the template deck and update it
 	var lastKey string
 	for k := range decks {
-		lastKey = k
+		if k != "1" {
+			lastKey = k
+		}
 	}
 
 	if lastKey != "" && lastKey != "1" {
@@ -377,6 +687,7 @@ func (d *Deck) updateModel() error {
 	}
 
 	_, err = d.db.Exec("UPDATE col SET models = ? WHERE id = 1", string(updatedJSON))
+	d.sortFields, d.fieldCounts = nil, nil
 	return err
 }
 
@@ -390,27 +701,115 @@ func (d *Deck) getID(table, col string, ts int64) int64 {
 	return maxID.Int64 + 1
 }
 
-func (d *Deck) getNoteID(guid string, ts int64) int64 {
-	var id sql.NullInt64
-	err := d.db.QueryRow("SELECT id FROM notes WHERE guid = ? ORDER BY id DESC LIMIT 1", guid).Scan(&id)
-	if err != nil || !id.Valid {
-		return d.getID("notes", "id", ts)
-	}
-	return id.Int64
-}
-
 func (d *Deck) getNoteGUID(deckID int64, front, back string) string {
 	data := fmt.Sprintf("%d%s%s", deckID, front, back)
 	return fmt.Sprintf("%x", sha1.Sum([]byte(data)))
 }
 
-func (d *Deck) getCardID(noteID, ts int64) int64 {
-	var id sql.NullInt64
-	err := d.db.QueryRow("SELECT id FROM cards WHERE nid = ? ORDER BY id DESC LIMIT 1", noteID).Scan(&id)
-	if err != nil || !id.Valid {
-		return d.getID("cards", "id", ts)
+// uniqueNoteGUID returns the content based GUID of a note, adding a counter
+// when a note with the same content already exists
+func (d *Deck) uniqueNoteGUID(fields []string) (string, error) {
+	front, back := fields[0], strings.Join(fields[1:], "")
+	guid := d.getNoteGUID(d.topDeckID, front, back)
+	for i := 1; ; i++ {
+		var count int
+		if err := d.db.QueryRow("SELECT COUNT(*) FROM notes WHERE guid = ?", guid).Scan(&count); err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return guid, nil
+		}
+		guid = d.getNoteGUID(d.topDeckID, front, back+separator+strconv.Itoa(i))
+	}
+}
+
+// findDuplicate returns the ID of a note of the same model whose first field
+// matches, or 0 if there is none. Like Anki, candidates are found by checksum
+// and compared with HTML stripped; empty first fields are never duplicates.
+func (d *Deck) findDuplicate(mid, csum int64, first string) (int64, error) {
+	stripped := strings.TrimSpace(text.StripHTMLMedia(first))
+	if stripped == "" {
+		return 0, nil
+	}
+
+	rows, err := d.db.Query("SELECT id, flds FROM notes WHERE mid = ? AND csum = ? ORDER BY id", mid, csum)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var id int64
+		var flds string
+		if err := rows.Scan(&id, &flds); err != nil {
+			return 0, err
+		}
+		fields := strings.SplitN(flds, separator, 2)
+		if strings.TrimSpace(text.StripHTMLMedia(fields[0])) == stripped {
+			return id, nil
+		}
+	}
+	return 0, rows.Err()
+}
+
+// findIdentical returns the ID of a note of the same model with exactly the
+// given fields, or 0 if there is none
+func (d *Deck) findIdentical(mid int64, fields []string) (int64, error) {
+	var id int64
+	err := d.db.QueryRow("SELECT id FROM notes WHERE mid = ? AND flds = ? ORDER BY id LIMIT 1",
+		mid, strings.Join(fields, separator)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// sortFieldAndChecksum returns the sfld and csum values for a note's fields.
+// As in Anki, the sort field is the model's sortf field and the checksum
+// covers the first field, both with HTML stripped and media filenames kept.
+func (d *Deck) sortFieldAndChecksum(mid int64, fields []string) (string, int64) {
+	first := text.StripHTMLMedia(fields[0])
+	csum := d.checksum(first)
+
+	idx := d.sortFieldIndex(mid)
+	if idx == 0 || idx >= len(fields) {
+		return first, csum
+	}
+	return text.StripHTMLMedia(fields[idx]), csum
+}
+
+// sortFieldIndex returns the index of the model's sort field
+func (d *Deck) sortFieldIndex(mid int64) int {
+	if idx, ok := d.sortFields[mid]; ok {
+		return idx
+	}
+
+	d.cacheModels()
+	return d.sortFields[mid]
+}
+
+// fieldCount returns the number of fields of the model
+func (d *Deck) fieldCount(mid int64) int {
+	if n, ok := d.fieldCounts[mid]; ok {
+		return n
+	}
+
+	d.cacheModels()
+	return d.fieldCounts[mid]
+}
+
+// cacheModels caches the sort field and field count of each model
+func (d *Deck) cacheModels() {
+	models, err := d.loadModels()
+	if err != nil {
+		return
+	}
+	d.sortFields = make(map[int64]int, len(models))
+	d.fieldCounts = make(map[int64]int, len(models))
+	for id, m := range models {
+		d.sortFields[id] = m.Sortf
+		d.fieldCounts[id] = len(m.Fields)
 	}
-	return id.Int64
 }
 
 func (d *Deck) checksum(str string) int64 {
@@ -4,7 +4,10 @@ import (
 	"archive/zip"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -81,6 +84,90 @@ func TestAddCardWithTags(t *testing.T) {
 	}
 }
 
+func TestSortFieldAndChecksum(t *testing.T) {
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+	deck.SetDuplicatePolicy(DuplicateAllow)
+
+	// Expected checksums are the first 32 bits of the SHA1 of the stripped
+	// first field, as computed by Anki's field_checksum
+	tests := []struct {
+		front, back string
+		sfld        string
+		csum        int64
+	}{
+		{"hello", "Answer", "hello", 2868168221},
+		{"<b>hello</b>", "Answer", "hello", 2868168221},
+		{"hello&nbsp;<i>world</i>", "Answer", "hello world", 716074037},
+		{`<img src="cat.jpg">`, "Answer", " cat.jpg ", 54041269},
+		{"Paris [sound:paris.mp3]", "Answer", "Paris [sound:paris.mp3]", 4206244516},
+	}
+
+	for _, tt := range tests {
+		if err := deck.AddCard(tt.front, tt.back); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+
+		var sfld string
+		var csum int64
+		err := deck.db.QueryRow("SELECT sfld, csum FROM notes WHERE flds = ?", tt.front+separator+tt.back).Scan(&sfld, &csum)
+		if err != nil {
+			t.Fatalf("Failed to query note: %v", err)
+		}
+		if sfld != tt.sfld {
+			t.Errorf("Expected sfld %q for %q, got %q", tt.sfld, tt.front, sfld)
+		}
+		if csum != tt.csum {
+			t.Errorf("Expected csum %d for %q, got %d", tt.csum, tt.front, csum)
+		}
+	}
+}
+
+func TestSortFieldFollowsModel(t *testing.T) {
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	// Sort by the second field
+	models, err := deck.loadModels()
+	if err != nil {
+		t.Fatalf("Failed to load models: %v", err)
+	}
+	if len(models) != 1 {
+		t.Fatalf("Expected 1 model, got %d", len(models))
+	}
+	var modelsJSON string
+	if err := deck.db.QueryRow("SELECT models FROM col WHERE id = 1").Scan(&modelsJSON); err != nil {
+		t.Fatalf("Failed to query models: %v", err)
+	}
+	modelsJSON = strings.Replace(modelsJSON, `"sortf":0`, `"sortf":1`, 1)
+	if _, err := deck.db.Exec("UPDATE col SET models = ? WHERE id = 1", modelsJSON); err != nil {
+		t.Fatalf("Failed to update models: %v", err)
+	}
+	deck.sortFields = nil
+
+	if err := deck.AddCard("<b>hello</b>", "<i>Answer</i>"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+
+	var sfld string
+	var csum int64
+	if err := deck.db.QueryRow("SELECT sfld, csum FROM notes").Scan(&sfld, &csum); err != nil {
+		t.Fatalf("Failed to query note: %v", err)
+	}
+	if sfld != "Answer" {
+		t.Errorf("Expected sfld 'Answer', got %q", sfld)
+	}
+	if csum != 2868168221 {
+		t.Errorf("Expected csum of the first field, got %d", csum)
+	}
+}
+
 func TestAddMedia(t *testing.T) {
 	deck, err := NewDeck("Test Deck")
 	if err != nil {
@@ -104,6 +191,105 @@ func TestAddMedia(t *testing.T) {
 	}
 }
 
+func TestOpenWorkingDeck(t *testing.T) {
+	dir := t.TempDir()
+
+	deck, err := OpenWorkingDeck(dir, "Working Deck")
+	if err != nil {
+		t.Fatalf("Failed to open working deck: %v", err)
+	}
+	if err := deck.AddCard("Question 1", "Answer 1"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+	deck.AddMedia("first.mp3", []byte("first audio"))
+	if err := deck.Close(); err != nil {
+		t.Fatalf("Failed to close deck: %v", err)
+	}
+
+	// Reopen the deck as a restarted process would
+	deck, err = OpenWorkingDeck(dir, "Ignored Name")
+	if err != nil {
+		t.Fatalf("Failed to reopen working deck: %v", err)
+	}
+	defer deck.Close()
+
+	if deck.name != "Working Deck" {
+		t.Errorf("Expected deck name 'Working Deck', got '%s'", deck.name)
+	}
+	if err := deck.AddCard("Question 2", "Answer 2"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+	deck.AddMedia("second.mp3", []byte("second audio"))
+
+	var count int
+	if err := deck.db.QueryRow("SELECT COUNT(*) FROM cards WHERE did = ?", deck.topDeckID).Scan(&count); err != nil {
+		t.Fatalf("Failed to query cards: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 cards, got %d", count)
+	}
+
+	if len(deck.media) != 2 {
+		t.Fatalf("Expected 2 media files, got %d", len(deck.media))
+	}
+
+	data, err := deck.Save()
+	if err != nil {
+		t.Fatalf("Failed to save deck: %v", err)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Failed to read ZIP: %v", err)
+	}
+
+	contents := make(map[string]bool)
+	for _, file := range reader.File {
+		if file.Name == "0" || file.Name == "1" {
+			rc, err := file.Open()
+			if err != nil {
+				t.Fatalf("Failed to open media file: %v", err)
+			}
+			var buf bytes.Buffer
+			if _, err := buf.ReadFrom(rc); err != nil {
+				t.Fatalf("Failed to read media file: %v", err)
+			}
+			_ = rc.Close()
+			contents[buf.String()] = true
+		}
+	}
+	if !contents["first audio"] || !contents["second audio"] {
+		t.Errorf("Expected both media files in export, got %v", contents)
+	}
+}
+
+func TestOpenWorkingDeck_MediaErrors(t *testing.T) {
+	dir := t.TempDir()
+	deck, err := OpenWorkingDeck(dir, "Working Deck")
+	if err != nil {
+		t.Fatalf("Failed to open working deck: %v", err)
+	}
+	defer deck.Close()
+
+	if err := deck.AddMediaFile("sounds/a.mp3", []byte("a")); err == nil {
+		t.Error("Expected an error for a filename with a directory")
+	}
+
+	// Without the media directory nothing can be written
+	if err := os.RemoveAll(filepath.Join(dir, workingMediaDir)); err != nil {
+		t.Fatalf("Failed to remove media directory: %v", err)
+	}
+	if err := deck.AddMediaFile("b.mp3", []byte("b")); err == nil {
+		t.Error("Expected an error when the file can't be written")
+	}
+	if err := deck.AddCardWithAudio("Question", "Answer", "c.mp3", []byte("c")); err == nil {
+		t.Error("Expected AddCardWithAudio to return the media error")
+	}
+	deck.AddMedia("d.mp3", []byte("d"))
+	if len(deck.media) != 0 {
+		t.Errorf("Expected no media files to be kept in memory, got %v", deck.media)
+	}
+}
+
 func TestSave(t *testing.T) {
 	deck, err := NewDeck("Test Deck")
 	if err != nil {
@@ -259,6 +445,165 @@ func TestDuplicateCard(t *testing.T) {
 	}
 }
 
+func TestDuplicatePolicy(t *testing.T) {
+	countNotes := func(t *testing.T, deck *Deck) int {
+		t.Helper()
+		var count int
+		if err := deck.db.QueryRow("SELECT COUNT(*) FROM notes").Scan(&count); err != nil {
+			t.Fatalf("Failed to query notes: %v", err)
+		}
+		return count
+	}
+
+	t.Run("default", func(t *testing.T) {
+		deck, err := NewDeck("Test Deck")
+		if err != nil {
+			t.Fatalf("Failed to create deck: %v", err)
+		}
+		defer deck.Close()
+
+		if err := deck.AddCardWithOptions("bank", "river edge", &CardOptions{Tags: []string{"geo"}}); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+		if err := deck.AddCard("bank", "financial institution"); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+		if err := deck.AddCard("bank", "river edge"); err != nil {
+			t.Fatalf("Failed to add identical card: %v", err)
+		}
+
+		notes, err := deck.Notes()
+		if err != nil {
+			t.Fatalf("Notes failed: %v", err)
+		}
+		if len(notes) != 2 || notes[0].Fields[1] != "river edge" || notes[1].Fields[1] != "financial institution" {
+			t.Fatalf("Expected both meanings as separate notes, got %v", notes)
+		}
+		if len(notes[0].Tags) != 1 || notes[0].Tags[0] != "geo" {
+			t.Errorf("Expected the identical card to leave the tags alone, got %v", notes[0].Tags)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		deck, err := NewDeck("Test Deck")
+		if err != nil {
+			t.Fatalf("Failed to create deck: %v", err)
+		}
+		defer deck.Close()
+		deck.SetDuplicatePolicy(DuplicateUpdate)
+
+		if err := deck.AddCard("Front", "Old Back"); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+		if err := deck.AddCardWithOptions("<b>Front</b>", "New Back", &CardOptions{Tags: []string{"updated"}}); err != nil {
+			t.Fatalf("Failed to add duplicate card: %v", err)
+		}
+
+		if count := countNotes(t, deck); count != 1 {
+			t.Fatalf("Expected 1 note, got %d", count)
+		}
+		notes, err := deck.Notes()
+		if err != nil {
+			t.Fatalf("Notes failed: %v", err)
+		}
+		if notes[0].Fields[1] != "New Back" {
+			t.Errorf("Expected back to be updated, got %q", notes[0].Fields[1])
+		}
+		if len(notes[0].Tags) != 1 || notes[0].Tags[0] != "updated" {
+			t.Errorf("Expected tags to be updated, got %v", notes[0].Tags)
+		}
+		if len(notes[0].Cards) != 1 {
+			t.Errorf("Expected 1 card, got %d", len(notes[0].Cards))
+		}
+	})
+
+	t.Run("allow", func(t *testing.T) {
+		deck, err := NewDeck("Test Deck")
+		if err != nil {
+			t.Fatalf("Failed to create deck: %v", err)
+		}
+		defer deck.Close()
+		deck.SetDuplicatePolicy(DuplicateAllow)
+
+		for i := 0; i < 2; i++ {
+			if err := deck.AddCard("Front", "Back"); err != nil {
+				t.Fatalf("Failed to add card: %v", err)
+			}
+		}
+
+		if count := countNotes(t, deck); count != 2 {
+			t.Fatalf("Expected 2 notes, got %d", count)
+		}
+		var guids int
+		if err := deck.db.QueryRow("SELECT COUNT(DISTINCT guid) FROM notes").Scan(&guids); err != nil {
+			t.Fatalf("Failed to query notes: %v", err)
+		}
+		if guids != 2 {
+			t.Errorf("Expected unique GUIDs, got %d distinct", guids)
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		deck, err := NewDeck("Test Deck")
+		if err != nil {
+			t.Fatalf("Failed to create deck: %v", err)
+		}
+		defer deck.Close()
+		deck.SetDuplicatePolicy(DuplicateSkip)
+
+		if err := deck.AddCard("Front", "Old Back"); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+		if err := deck.AddCard("Front", "New Back"); err != nil {
+			t.Fatalf("Failed to add duplicate card: %v", err)
+		}
+
+		notes, err := deck.Notes()
+		if err != nil {
+			t.Fatalf("Notes failed: %v", err)
+		}
+		if len(notes) != 1 || notes[0].Fields[1] != "Old Back" {
+			t.Errorf("Expected the existing note to be kept, got %v", notes)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		deck, err := NewDeck("Test Deck")
+		if err != nil {
+			t.Fatalf("Failed to create deck: %v", err)
+		}
+		defer deck.Close()
+		deck.SetDuplicatePolicy(DuplicateError)
+
+		if err := deck.AddCard("Front", "Back"); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+		if err := deck.AddCard("Other", "Back"); err != nil {
+			t.Fatalf("Failed to add card with a different front: %v", err)
+		}
+
+		err = deck.AddCard("Front", "Another Back")
+		if !errors.Is(err, ErrDuplicateNote) {
+			t.Fatalf("Expected ErrDuplicateNote, got %v", err)
+		}
+		var dupErr *DuplicateNoteError
+		if !errors.As(err, &dupErr) {
+			t.Fatalf("Expected *DuplicateNoteError, got %T", err)
+		}
+
+		notes, err := deck.FindNotes("front:Front")
+		if err != nil {
+			t.Fatalf("FindNotes failed: %v", err)
+		}
+		if len(notes) != 1 || dupErr.NoteID != notes[0] {
+			t.Errorf("Expected existing note ID %v, got %d", notes, dupErr.NoteID)
+		}
+		if count := countNotes(t, deck); count != 2 {
+			t.Errorf("Expected 2 notes, got %d", count)
+		}
+	})
+}
+
 func TestAddAudio(t *testing.T) {
 	deck, err := NewDeck("Audio Test Deck")
 	if err != nil {
@@ -2,25 +2,70 @@ package anki
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
 	defaultAnkiConnectURL = "http://localhost:8765"
 	ankiConnectVersion    = 6
+
+	// minAnkiConnectVersion is the oldest API version answering with
+	// result and error objects
+	minAnkiConnectVersion = 5
 )
 
 // AnkiConnect represents a client for communicating with AnkiConnect addon
 type AnkiConnect struct {
-	URL     string
+	URL string
+
+	// Version is the highest API version requests are sent with. Set it
+	// before use; requests use the server's version if Ping or Capabilities
+	// find it to be older, see APIVersion.
 	Version int
-	client  *http.Client
+
+	// APIKey is sent with every request for AnkiConnect instances that
+	// require one
+	APIKey string
+
+	// UserAgent is sent as the User-Agent header if set
+	UserAgent string
+
+	// BatchSize is the number of actions sent per multi request by bulk
+	// operations such as pushing a deck
+	BatchSize int
+
+	// MediaBatchBytes caps the file content sent per multi request when
+	// uploading media. Larger files are sent on their own. Defaults to 8 MiB.
+	MediaBatchBytes int64
+
+	// Retry controls retries of idempotent actions after connection errors.
+	// nil disables retries.
+	Retry *RetryPolicy
+
+	// Breaker fails requests fast while Anki is unreachable. nil disables it.
+	Breaker *CircuitBreaker
+
+	// Logger receives an event for every request, retry and fallback.
+	// nil disables logging.
+	Logger *slog.Logger
+
+	client *http.Client
+
+	mu            sync.Mutex
+	serverVersion int             // Server version from the last negotiation, 0 if none
+	caps          *Capabilities   // Capabilities from the last negotiation
+	unsupported   map[string]bool // Actions that failed as unsupported
 }
 
 // SyncOptions controls the behavior of deck synchronization
@@ -28,6 +73,133 @@ type SyncOptions struct {
 	UpdateExisting bool // Update existing cards
 	DeleteMissing  bool // Delete cards not in local deck
 	SyncMedia      bool // Sync media files
+	MaxDeletes     int  // Refuse to delete more notes than this with DeleteMissing (0 means DefaultMaxDeletes, negative means no limit)
+
+	// KeyField names the field used to match notes that were not synced
+	// before. It defaults to the first field of the note type.
+	KeyField string
+
+	// ManagedTagPrefixes lists the tag prefixes owned by the local deck.
+	// Tags with these prefixes are removed from notes in Anki when they are
+	// removed locally; other tags are only ever added. Use "" to manage every tag.
+	ManagedTagPrefixes []string
+
+	// MediaOptions controls how media is compared and uploaded with SyncMedia
+	MediaOptions *MediaSyncOptions
+}
+
+// SyncReport describes the changes a sync made in Anki
+type SyncReport struct {
+	Deleted []DeletedNote    // Notes removed from Anki because they are missing locally
+	Media   *MediaSyncReport // Media files uploaded, nil without SyncMedia
+}
+
+// DeletedNote describes a note removed from Anki during a sync
+type DeletedNote struct {
+	NoteID int64
+	Fields map[string]string
+}
+
+// DefaultMaxDeletes is the number of notes DeleteMissing may delete when
+// SyncOptions.MaxDeletes is 0
+const DefaultMaxDeletes = 100
+
+// ErrModelMismatch is matched by errors.Is for errors caused by a note type
+// in Anki lacking fields or templates of the local one
+var ErrModelMismatch = errors.New("note type mismatch")
+
+// ModelMismatchError is returned when a note type exists in Anki without some
+// of the fields or templates of the local note type of the same name, so
+// notes can't be pushed with it. It matches ErrModelMismatch.
+type ModelMismatchError struct {
+	Model            string
+	MissingFields    []string
+	MissingTemplates []string
+}
+
+func (e *ModelMismatchError) Error() string {
+	var missing []string
+	if len(e.MissingFields) > 0 {
+		missing = append(missing, fmt.Sprintf("fields %s", strings.Join(e.MissingFields, ", ")))
+	}
+	if len(e.MissingTemplates) > 0 {
+		missing = append(missing, fmt.Sprintf("templates %s", strings.Join(e.MissingTemplates, ", ")))
+	}
+	return fmt.Sprintf("note type %s in Anki lacks %s", e.Model, strings.Join(missing, " and "))
+}
+
+// Is reports whether target is ErrModelMismatch
+func (e *ModelMismatchError) Is(target error) bool {
+	return target == ErrModelMismatch
+}
+
+// ErrTooManyDeletes is returned when DeleteMissing would remove more notes than MaxDeletes allows
+var ErrTooManyDeletes = errors.New("too many notes to delete")
+
+// Errors matched by errors.Is for the corresponding AnkiConnect API errors
+var (
+	// ErrDuplicate is the same error as ErrDuplicateNote, so duplicates are
+	// matched alike whether Anki or the local deck rejected them
+	ErrDuplicate = ErrDuplicateNote
+
+	ErrDeckExists    = errors.New("deck already exists")
+	ErrModelNotFound = errors.New("model not found")
+
+	// ErrUnsupportedAction is matched by errors for actions the AnkiConnect
+	// version does not know
+	ErrUnsupportedAction = errors.New("unsupported action")
+)
+
+// apiErrorMessages maps the start of the messages AnkiConnect reports, in
+// lower case, to the errors they match. Messages naming a model or note
+// continue after the prefix.
+var apiErrorMessages = []struct {
+	prefix string
+	target error
+}{
+	{"cannot create note because it is a duplicate", ErrDuplicate},
+	{"deck already exists", ErrDeckExists},
+	{"that deck already exists", ErrDeckExists},
+	{"model was not found", ErrModelNotFound},
+	{"unsupported action", ErrUnsupportedAction},
+}
+
+// APIError is an error reported by AnkiConnect for an action. It matches
+// ErrDuplicate, ErrDeckExists, ErrModelNotFound or ErrUnsupportedAction
+// depending on the message.
+type APIError struct {
+	Action  string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("AnkiConnect error: %s", e.Message)
+}
+
+// Is reports whether the message is one AnkiConnect reports for target
+func (e *APIError) Is(target error) bool {
+	msg := strings.ToLower(strings.TrimSpace(e.Message))
+	for _, m := range apiErrorMessages {
+		if m.target == target && strings.HasPrefix(msg, m.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// TransportError is returned when AnkiConnect could not be reached or its
+// response could not be read
+type TransportError struct {
+	Action string
+	Err    error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("failed to connect to AnkiConnect: %v", e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
 }
 
 // ankiRequest represents a request to AnkiConnect API
@@ -35,6 +207,7 @@ type ankiRequest struct {
 	Action  string      `json:"action"`
 	Version int         `json:"version"`
 	Params  interface{} `json:"params,omitempty"`
+	Key     string      `json:"key,omitempty"`
 }
 
 // ankiResponse represents a response from AnkiConnect API
@@ -43,69 +216,225 @@ type ankiResponse struct {
 	Error  string      `json:"error"`
 }
 
-// NewAnkiConnect creates a new AnkiConnect client with default settings
-func NewAnkiConnect() *AnkiConnect {
-	return &AnkiConnect{
-		URL:     defaultAnkiConnectURL,
-		Version: ankiConnectVersion,
+// Option configures an AnkiConnect client
+type Option func(*AnkiConnect)
+
+// WithURL sets the AnkiConnect server URL
+func WithURL(url string) Option {
+	return func(ac *AnkiConnect) {
+		ac.URL = url
+	}
+}
+
+// WithAPIKey sets the key sent with every request
+func WithAPIKey(key string) Option {
+	return func(ac *AnkiConnect) {
+		ac.APIKey = key
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for requests
+func WithHTTPClient(client *http.Client) Option {
+	return func(ac *AnkiConnect) {
+		if client != nil {
+			ac.client = client
+		}
+	}
+}
+
+// WithTransport sets the transport of the HTTP client, e.g. for proxies,
+// custom TLS settings or request logging
+func WithTransport(transport http.RoundTripper) Option {
+	return func(ac *AnkiConnect) {
+		client := *ac.client
+		client.Transport = transport
+		ac.client = &client
+	}
+}
+
+// WithLogger sets the logger for requests, retries and fallbacks
+func WithLogger(logger *slog.Logger) Option {
+	return func(ac *AnkiConnect) {
+		ac.Logger = logger
+	}
+}
+
+// WithUserAgent sets the User-Agent header of requests
+func WithUserAgent(userAgent string) Option {
+	return func(ac *AnkiConnect) {
+		ac.UserAgent = userAgent
+	}
+}
+
+// NewAnkiConnect creates a new AnkiConnect client with default settings,
+// changed by opts
+func NewAnkiConnect(opts ...Option) *AnkiConnect {
+	ac := &AnkiConnect{
+		URL:       defaultAnkiConnectURL,
+		Version:   ankiConnectVersion,
+		BatchSize: defaultBatchSize,
+		Retry:     DefaultRetryPolicy(),
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
 	}
+	for _, opt := range opts {
+		opt(ac)
+	}
+	return ac
 }
 
 // NewAnkiConnectWithURL creates a new AnkiConnect client with custom URL
-func NewAnkiConnectWithURL(url string) *AnkiConnect {
-	ac := NewAnkiConnect()
-	ac.URL = url
-	return ac
+func NewAnkiConnectWithURL(url string, opts ...Option) *AnkiConnect {
+	return NewAnkiConnect(append([]Option{WithURL(url)}, opts...)...)
 }
 
 // invoke makes a request to AnkiConnect API
-func (ac *AnkiConnect) invoke(action string, params interface{}) (interface{}, error) {
+func (ac *AnkiConnect) invoke(ctx context.Context, action string, params interface{}) (interface{}, error) {
+	raw, err := ac.invokeRaw(ctx, action, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var result interface{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &result); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal result: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// invokeRaw makes a request to AnkiConnect API and returns the undecoded result.
+// Idempotent actions are retried after connection errors following ac.Retry.
+func (ac *AnkiConnect) invokeRaw(ctx context.Context, action string, params interface{}) (json.RawMessage, error) {
+	jsonData, err := ac.marshalRequest(action, params)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := 1
+	if ac.Retry != nil && ac.Retry.MaxAttempts > 1 && retryable(action, params) {
+		attempts = ac.Retry.MaxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := ac.checkBreaker(ctx, action); err != nil {
+			return nil, err
+		}
+
+		result, err := ac.post(ctx, action, jsonData)
+		var transportErr *TransportError
+		if err == nil || !errors.As(err, &transportErr) || attempt >= attempts || ctx.Err() != nil {
+			return result, err
+		}
+		backoff := ac.Retry.backoff(attempt)
+		ac.logger().WarnContext(ctx, "retrying AnkiConnect request",
+			"action", action, "attempt", attempt, "backoff", backoff, "error", err)
+		if err := sleep(ctx, backoff); err != nil {
+			return nil, &TransportError{Action: action, Err: err}
+		}
+	}
+}
+
+// marshalRequest encodes a request for AnkiConnect API
+func (ac *AnkiConnect) marshalRequest(action string, params interface{}) ([]byte, error) {
 	req := ankiRequest{
 		Action:  action,
-		Version: ac.Version,
+		Version: ac.APIVersion(),
 		Params:  params,
+		Key:     ac.APIKey,
 	}
 
 	jsonData, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
+	return jsonData, nil
+}
 
-	resp, err := ac.client.Post(ac.URL, "application/json", bytes.NewBuffer(jsonData))
+// post sends a single encoded request to AnkiConnect API and records the
+// outcome in the circuit breaker
+func (ac *AnkiConnect) post(ctx context.Context, action string, jsonData []byte) (json.RawMessage, error) {
+	start := time.Now()
+	result, err := ac.send(ctx, action, jsonData)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to AnkiConnect: %w", err)
+		ac.logger().DebugContext(ctx, "AnkiConnect request failed",
+			"action", action, "duration", time.Since(start), "error", err)
+	} else {
+		ac.logger().DebugContext(ctx, "AnkiConnect request",
+			"action", action, "duration", time.Since(start))
+	}
+	if ac.Breaker != nil && ctx.Err() == nil {
+		var transportErr *TransportError
+		if ac.Breaker.record(errors.As(err, &transportErr)) {
+			ac.logger().WarnContext(ctx, "AnkiConnect unavailable, failing requests fast",
+				"cooldown", ac.Breaker.Cooldown)
+		}
+	}
+	return result, err
+}
+
+// send sends a single encoded request to AnkiConnect API
+func (ac *AnkiConnect) send(ctx context.Context, action string, jsonData []byte) (json.RawMessage, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, ac.URL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if ac.UserAgent != "" {
+		httpReq.Header.Set("User-Agent", ac.UserAgent)
+	}
+
+	resp, err := ac.client.Do(httpReq)
+	if err != nil {
+		return nil, &TransportError{Action: action, Err: err}
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, &TransportError{Action: action, Err: fmt.Errorf("failed to read response: %w", err)}
 	}
 
-	var result ankiResponse
+	var result struct {
+		Result json.RawMessage `json:"result"`
+		Error  string          `json:"error"`
+	}
 	if err := json.Unmarshal(body, &result); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
 	if result.Error != "" {
-		return nil, fmt.Errorf("AnkiConnect error: %s", result.Error)
+		return nil, &APIError{Action: action, Message: result.Error}
 	}
 
 	return result.Result, nil
 }
 
-// Ping checks if AnkiConnect is available
+// Ping checks if AnkiConnect is available. Later requests are sent with the
+// server's API version if it is older than Version.
 func (ac *AnkiConnect) Ping() error {
-	_, err := ac.invoke("version", nil)
-	return err
+	return ac.PingContext(context.Background())
+}
+
+// PingContext is like Ping but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) PingContext(ctx context.Context) error {
+	version, err := ac.ServerVersionContext(ctx)
+	if err != nil {
+		return err
+	}
+	return ac.negotiateVersion(version)
 }
 
 // GetDeckNames returns all deck names in Anki
 func (ac *AnkiConnect) GetDeckNames() ([]string, error) {
-	result, err := ac.invoke("deckNames", nil)
+	return ac.GetDeckNamesContext(context.Background())
+}
+
+// GetDeckNamesContext is like GetDeckNames but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) GetDeckNamesContext(ctx context.Context) ([]string, error) {
+	result, err := ac.invoke(ctx, "deckNames", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -128,45 +457,49 @@ func (ac *AnkiConnect) GetDeckNames() ([]string, error) {
 
 // CreateDeck creates a new deck in Anki
 func (ac *AnkiConnect) CreateDeck(name string) error {
+	return ac.CreateDeckContext(context.Background(), name)
+}
+
+// CreateDeckContext is like CreateDeck but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) CreateDeckContext(ctx context.Context, name string) error {
 	params := map[string]string{"deck": name}
-	_, err := ac.invoke("createDeck", params)
+	_, err := ac.invoke(ctx, "createDeck", params)
 	return err
 }
 
 // DeleteDeck deletes a deck and all its cards
 func (ac *AnkiConnect) DeleteDeck(name string) error {
+	return ac.DeleteDeckContext(context.Background(), name)
+}
+
+// DeleteDeckContext is like DeleteDeck but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) DeleteDeckContext(ctx context.Context, name string) error {
 	params := map[string]interface{}{
 		"decks":    []string{name},
 		"cardsToo": true,
 	}
-	_, err := ac.invoke("deleteDecks", params)
+	_, err := ac.invoke(ctx, "deleteDecks", params)
 	return err
 }
 
-// ankiNote represents a note in AnkiConnect format
-type ankiNote struct {
+// AnkiNote is a note to add to Anki with AddNote, AddNotes or CanAddNotes
+type AnkiNote struct {
 	DeckName  string                 `json:"deckName"`
 	ModelName string                 `json:"modelName"`
 	Fields    map[string]string      `json:"fields"`
 	Tags      []string               `json:"tags,omitempty"`
-	Audio     []ankiMedia            `json:"audio,omitempty"`
-	Picture   []ankiMedia            `json:"picture,omitempty"`
-	Video     []ankiMedia            `json:"video,omitempty"`
 	Options   map[string]interface{} `json:"options,omitempty"`
 }
 
-// ankiMedia represents media attachment in AnkiConnect format
-type ankiMedia struct {
-	Path     string   `json:"path,omitempty"`
-	Filename string   `json:"filename,omitempty"`
-	Fields   []string `json:"fields,omitempty"`
-	Data     string   `json:"data,omitempty"`
+// AddNote adds a single note to Anki
+func (ac *AnkiConnect) AddNote(note AnkiNote) (int64, error) {
+	return ac.AddNoteContext(context.Background(), note)
 }
 
-// AddNote adds a single note to Anki
-func (ac *AnkiConnect) AddNote(note ankiNote) (int64, error) {
+// AddNoteContext is like AddNote but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) AddNoteContext(ctx context.Context, note AnkiNote) (int64, error) {
 	params := map[string]interface{}{"note": note}
-	result, err := ac.invoke("addNote", params)
+	result, err := ac.invoke(ctx, "addNote", params)
 	if err != nil {
 		return 0, err
 	}
@@ -181,8 +514,13 @@ func (ac *AnkiConnect) AddNote(note ankiNote) (int64, error) {
 
 // FindNotes searches for notes matching a query
 func (ac *AnkiConnect) FindNotes(query string) ([]int64, error) {
+	return ac.FindNotesContext(context.Background(), query)
+}
+
+// FindNotesContext is like FindNotes but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) FindNotesContext(ctx context.Context, query string) ([]int64, error) {
 	params := map[string]string{"query": query}
-	result, err := ac.invoke("findNotes", params)
+	result, err := ac.invoke(ctx, "findNotes", params)
 	if err != nil {
 		return nil, err
 	}
@@ -206,38 +544,237 @@ func (ac *AnkiConnect) FindNotes(query string) ([]int64, error) {
 
 // UpdateNoteFields updates fields of an existing note
 func (ac *AnkiConnect) UpdateNoteFields(noteID int64, fields map[string]string) error {
+	return ac.UpdateNoteFieldsContext(context.Background(), noteID, fields)
+}
+
+// UpdateNoteFieldsContext is like UpdateNoteFields but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) UpdateNoteFieldsContext(ctx context.Context, noteID int64, fields map[string]string) error {
 	params := map[string]interface{}{
 		"note": map[string]interface{}{
 			"id":     noteID,
 			"fields": fields,
 		},
 	}
-	_, err := ac.invoke("updateNoteFields", params)
+	_, err := ac.invoke(ctx, "updateNoteFields", params)
+	return err
+}
+
+// DeleteNotes deletes notes and all their cards
+func (ac *AnkiConnect) DeleteNotes(noteIDs []int64) error {
+	return ac.DeleteNotesContext(context.Background(), noteIDs)
+}
+
+// DeleteNotesContext is like DeleteNotes but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) DeleteNotesContext(ctx context.Context, noteIDs []int64) error {
+	params := map[string]interface{}{"notes": noteIDs}
+	_, err := ac.invoke(ctx, "deleteNotes", params)
+	return err
+}
+
+// AddTags adds tags to notes
+func (ac *AnkiConnect) AddTags(noteIDs []int64, tags []string) error {
+	return ac.AddTagsContext(context.Background(), noteIDs, tags)
+}
+
+// AddTagsContext is like AddTags but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) AddTagsContext(ctx context.Context, noteIDs []int64, tags []string) error {
+	params := map[string]interface{}{
+		"notes": noteIDs,
+		"tags":  tagsParam(tags),
+	}
+	_, err := ac.invoke(ctx, "addTags", params)
+	return err
+}
+
+// RemoveTags removes tags from notes
+func (ac *AnkiConnect) RemoveTags(noteIDs []int64, tags []string) error {
+	return ac.RemoveTagsContext(context.Background(), noteIDs, tags)
+}
+
+// RemoveTagsContext is like RemoveTags but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) RemoveTagsContext(ctx context.Context, noteIDs []int64, tags []string) error {
+	params := map[string]interface{}{
+		"notes": noteIDs,
+		"tags":  tagsParam(tags),
+	}
+	_, err := ac.invoke(ctx, "removeTags", params)
+	return err
+}
+
+// NoteModel describes a note type to create in Anki
+type NoteModel struct {
+	Name      string
+	Fields    []string
+	CSS       string
+	IsCloze   bool
+	Templates []CardTemplate
+}
+
+// CardTemplate is a card template of a note type
+type CardTemplate struct {
+	Name  string `json:"Name"`
+	Front string `json:"Front"`
+	Back  string `json:"Back"`
+}
+
+// ModelNames returns the names of all note types in Anki
+func (ac *AnkiConnect) ModelNames() ([]string, error) {
+	return ac.ModelNamesContext(context.Background())
+}
+
+// ModelNamesContext is like ModelNames but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) ModelNamesContext(ctx context.Context) ([]string, error) {
+	result, err := ac.invoke(ctx, "modelNames", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	names, ok := result.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected response type")
+	}
+
+	modelNames := make([]string, len(names))
+	for i, name := range names {
+		modelNames[i], ok = name.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected model name type")
+		}
+	}
+
+	return modelNames, nil
+}
+
+// CreateModel creates a note type in Anki
+func (ac *AnkiConnect) CreateModel(model NoteModel) error {
+	return ac.CreateModelContext(context.Background(), model)
+}
+
+// CreateModelContext is like CreateModel but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) CreateModelContext(ctx context.Context, model NoteModel) error {
+	params := map[string]interface{}{
+		"modelName":     model.Name,
+		"inOrderFields": model.Fields,
+		"css":           model.CSS,
+		"isCloze":       model.IsCloze,
+		"cardTemplates": model.Templates,
+	}
+	_, err := ac.invoke(ctx, "createModel", params)
+	return err
+}
+
+// UpdateModelTemplates replaces the front and back of existing card templates
+func (ac *AnkiConnect) UpdateModelTemplates(modelName string, templates []CardTemplate) error {
+	return ac.UpdateModelTemplatesContext(context.Background(), modelName, templates)
+}
+
+// UpdateModelTemplatesContext is like UpdateModelTemplates but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) UpdateModelTemplatesContext(ctx context.Context, modelName string, templates []CardTemplate) error {
+	tmpls := make(map[string]interface{}, len(templates))
+	for _, t := range templates {
+		tmpls[t.Name] = map[string]string{"Front": t.Front, "Back": t.Back}
+	}
+	params := map[string]interface{}{
+		"model": map[string]interface{}{
+			"name":      modelName,
+			"templates": tmpls,
+		},
+	}
+	_, err := ac.invoke(ctx, "updateModelTemplates", params)
+	return err
+}
+
+// UpdateModelStyling replaces the CSS of a note type
+func (ac *AnkiConnect) UpdateModelStyling(modelName, css string) error {
+	return ac.UpdateModelStylingContext(context.Background(), modelName, css)
+}
+
+// UpdateModelStylingContext is like UpdateModelStyling but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) UpdateModelStylingContext(ctx context.Context, modelName, css string) error {
+	params := map[string]interface{}{
+		"model": map[string]interface{}{
+			"name": modelName,
+			"css":  css,
+		},
+	}
+	_, err := ac.invoke(ctx, "updateModelStyling", params)
 	return err
 }
 
 // StoreMediaFile stores a media file in Anki's media folder
 func (ac *AnkiConnect) StoreMediaFile(filename string, data []byte) error {
+	return ac.StoreMediaFileContext(context.Background(), filename, data)
+}
+
+// StoreMediaFileContext is like StoreMediaFile but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) StoreMediaFileContext(ctx context.Context, filename string, data []byte) error {
 	// AnkiConnect expects base64 encoded data
 	encodedData := base64.StdEncoding.EncodeToString(data)
 	params := map[string]interface{}{
 		"filename": filename,
 		"data":     encodedData,
 	}
-	_, err := ac.invoke("storeMediaFile", params)
+	_, err := ac.invoke(ctx, "storeMediaFile", params)
+	return err
+}
+
+// StoreMediaFileFromPath stores a file in Anki's media folder that Anki reads
+// from path, avoiding sending large files over the connection. Anki must run
+// on the same machine.
+func (ac *AnkiConnect) StoreMediaFileFromPath(filename, path string) error {
+	return ac.StoreMediaFileFromPathContext(context.Background(), filename, path)
+}
+
+// StoreMediaFileFromPathContext is like StoreMediaFileFromPath but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) StoreMediaFileFromPathContext(ctx context.Context, filename, path string) error {
+	params := map[string]interface{}{
+		"filename": filename,
+		"path":     path,
+	}
+	_, err := ac.invoke(ctx, "storeMediaFile", params)
+	return err
+}
+
+// StoreMediaFileFromURL stores a file in Anki's media folder that Anki
+// downloads from url
+func (ac *AnkiConnect) StoreMediaFileFromURL(filename, url string) error {
+	return ac.StoreMediaFileFromURLContext(context.Background(), filename, url)
+}
+
+// StoreMediaFileFromURLContext is like StoreMediaFileFromURL but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) StoreMediaFileFromURLContext(ctx context.Context, filename, url string) error {
+	params := map[string]interface{}{
+		"filename": filename,
+		"url":      url,
+	}
+	_, err := ac.invoke(ctx, "storeMediaFile", params)
 	return err
 }
 
 // Sync triggers Anki to sync with AnkiWeb
 func (ac *AnkiConnect) Sync() error {
-	_, err := ac.invoke("sync", nil)
+	return ac.SyncContext(context.Background())
+}
+
+// SyncContext is like Sync but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) SyncContext(ctx context.Context) error {
+	_, err := ac.invoke(ctx, "sync", nil)
 	return err
 }
 
-// GetNotesInfo retrieves detailed information about notes
+// GetNotesInfo retrieves detailed information about notes.
+//
+// Deprecated: use NotesInfo, which decodes the notes into NoteInfo.
 func (ac *AnkiConnect) GetNotesInfo(noteIDs []int64) ([]map[string]interface{}, error) {
+	return ac.GetNotesInfoContext(context.Background(), noteIDs)
+}
+
+// GetNotesInfoContext is like GetNotesInfo but uses ctx for cancellation and deadlines.
+//
+// Deprecated: use NotesInfoContext.
+func (ac *AnkiConnect) GetNotesInfoContext(ctx context.Context, noteIDs []int64) ([]map[string]interface{}, error) {
 	params := map[string]interface{}{"notes": noteIDs}
-	result, err := ac.invoke("notesInfo", params)
+	result, err := ac.invoke(ctx, "notesInfo", params)
 	if err != nil {
 		return nil, err
 	}
@@ -259,375 +796,725 @@ func (ac *AnkiConnect) GetNotesInfo(noteIDs []int64) ([]map[string]interface{},
 	return notesInfo, nil
 }
 
-// PullFromAnki pulls cards from Anki deck and updates the local deck
+// PullOptions controls how notes pulled from Anki are merged into the deck
+type PullOptions struct {
+	KeyField string // Field matching notes not synced before (default: first field)
+	Media    bool   // Download media files referenced by the notes that are not in the deck
+}
+
+// PullReport describes the changes a pull made to the local deck
+type PullReport struct {
+	Added     int            // Notes added from Anki
+	Updated   int            // Local notes updated with changes from Anki
+	Unchanged int            // Notes identical on both sides
+	Kept      int            // Notes only changed locally since the last sync, left as is
+	Conflicts []PullConflict // Notes changed on both sides since the last sync, left as is
+
+	Media       []string      // Media files downloaded from Anki
+	MediaFailed []*MediaError // Referenced media files that could not be downloaded
+}
+
+// PullConflict describes a note changed both locally and in Anki since the last sync
+type PullConflict struct {
+	NoteID       int64
+	RemoteNoteID int64
+	Local        map[string]string
+	Remote       map[string]string
+}
+
+// PullFromAnki merges the notes of the Anki deck into the local deck
 func (d *Deck) PullFromAnki(client *AnkiConnect) error {
+	return d.PullFromAnkiContext(context.Background(), client)
+}
+
+// PullFromAnkiContext is like PullFromAnki but uses ctx for cancellation and deadlines.
+func (d *Deck) PullFromAnkiContext(ctx context.Context, client *AnkiConnect) error {
+	_, err := d.PullFromAnkiWithOptionsContext(ctx, client, nil)
+	return err
+}
+
+// PullFromAnkiWithOptions merges the notes of the Anki deck into the local deck.
+// The note types of the remote notes are recreated locally with their fields,
+// templates and styling, so notes keep all their fields whatever their type.
+// Remote notes are matched to local ones of the same note type by the Anki note
+// ID recorded at the last push or pull, otherwise by the key field. Matched
+// notes are updated,
+// unmatched ones added, and local notes missing in Anki are kept. A note changed
+// on both sides since the last sync is reported as a conflict and not modified.
+func (d *Deck) PullFromAnkiWithOptions(client *AnkiConnect, opts *PullOptions) (*PullReport, error) {
+	return d.PullFromAnkiWithOptionsContext(context.Background(), client, opts)
+}
+
+// PullFromAnkiWithOptionsContext is like PullFromAnkiWithOptions but uses ctx for cancellation and deadlines.
+func (d *Deck) PullFromAnkiWithOptionsContext(ctx context.Context, client *AnkiConnect, opts *PullOptions) (*PullReport, error) {
+	if opts == nil {
+		opts = &PullOptions{}
+	}
+
 	// Check connection
-	if err := client.Ping(); err != nil {
-		return fmt.Errorf("failed to connect to AnkiConnect: %w", err)
+	if err := client.PingContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to AnkiConnect: %w", err)
 	}
 
 	// Find notes in the deck
-	query := fmt.Sprintf("deck:\"%s\"", d.name)
-	noteIDs, err := client.FindNotes(query)
+	noteIDs, err := client.FindNotesContext(ctx, deckQuery(d.name))
 	if err != nil {
-		return fmt.Errorf("failed to find notes: %w", err)
+		return nil, fmt.Errorf("failed to find notes: %w", err)
 	}
 
+	report := &PullReport{}
 	if len(noteIDs) == 0 {
-		return nil // No notes to pull
+		return report, nil // No notes to pull
 	}
 
 	// Get detailed note information
-	notesInfo, err := client.GetNotesInfo(noteIDs)
+	notesInfo, err := client.NotesInfoContext(ctx, noteIDs)
 	if err != nil {
-		return fmt.Errorf("failed to get notes info: %w", err)
+		return nil, fmt.Errorf("failed to get notes info: %w", err)
 	}
 
-	// Clear existing cards in the deck
-	// Note: In a production implementation, you might want to merge instead
-	if _, err := d.db.Exec("DELETE FROM cards WHERE did = ?", d.topDeckID); err != nil {
-		return fmt.Errorf("failed to clear existing cards: %w", err)
-	}
-	if _, err := d.db.Exec("DELETE FROM notes"); err != nil {
-		return fmt.Errorf("failed to clear existing notes: %w", err)
-	}
-
-	// Add each note from Anki
+	// Recreate the note types of the remote notes
+	pulled := make(map[string]noteModel)
 	for _, noteInfo := range notesInfo {
-		fields, ok := noteInfo["fields"].(map[string]interface{})
-		if !ok {
+		name := noteInfo.ModelName
+		if _, ok := pulled[name]; ok {
 			continue
 		}
-
-		// Extract front and back fields
-		var front, back string
-		if frontField, ok := fields["Front"].(map[string]interface{}); ok {
-			if value, ok := frontField["value"].(string); ok {
-				front = value
-			}
+		remote, err := client.remoteModel(ctx, name)
+		if err != nil {
+			return nil, err
 		}
-		if backField, ok := fields["Back"].(map[string]interface{}); ok {
-			if value, ok := backField["value"].(string); ok {
-				back = value
-			}
+		model, err := d.importModel(remote)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import model %s: %w", name, err)
 		}
+		pulled[name] = model
+	}
 
-		// Extract tags
-		var tags []string
-		if tagsInterface, ok := noteInfo["tags"].([]interface{}); ok {
-			for _, tag := range tagsInterface {
-				if tagStr, ok := tag.(string); ok {
-					tags = append(tags, tagStr)
-				}
-			}
+	models, err := d.loadModels()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := keyFieldIndex(models[d.topModelID].fieldNames(), opts.KeyField); err != nil {
+		known := false
+		for _, model := range pulled {
+			known = known || indexOf(model.fieldNames(), opts.KeyField) >= 0
 		}
-
-		// Add the card to local deck
-		opts := &CardOptions{
-			Tags: tags,
+		if !known {
+			return nil, err
 		}
-		if err := d.AddCardWithOptions(front, back, opts); err != nil {
-			return fmt.Errorf("failed to add card: %w", err)
+	}
+	// Note types without the key field are matched by their first field
+	keyIndex := func(mid int64) int {
+		if i := indexOf(models[mid].fieldNames(), opts.KeyField); i >= 0 {
+			return i
 		}
+		return 0
 	}
 
-	return nil
-}
-
-// syncWithExisting syncs the deck with existing notes in Anki
-func (d *Deck) syncWithExisting(client *AnkiConnect, existingMap map[string]int64, syncMedia bool) error {
-	// Sync media files first if requested
-	if syncMedia && len(d.media) > 0 {
-		for _, media := range d.media {
-			if err := client.StoreMediaFile(media.Filename, media.Data); err != nil {
-				fmt.Printf("Warning: failed to sync media file %s: %v\n", media.Filename, err)
-			}
-		}
+	locals, err := d.Notes()
+	if err != nil {
+		return nil, err
+	}
+	modified, err := d.modifiedSinceSync()
+	if err != nil {
+		return nil, err
+	}
+	lastSync, err := d.lastSync()
+	if err != nil {
+		return nil, err
 	}
 
-	// Query cards from the database
-	rows, err := d.db.Query(`
-		SELECT n.flds, n.tags 
-		FROM notes n 
-		JOIN cards c ON c.nid = n.id 
-		WHERE c.did = ?`, d.topDeckID)
+	remoteIDs, err := d.remoteNoteIDs()
 	if err != nil {
-		return fmt.Errorf("failed to query cards: %w", err)
+		return nil, err
 	}
-	defer func() { _ = rows.Close() }()
 
-	// Process each card
-	for rows.Next() {
-		var flds, tags string
-		if err := rows.Scan(&flds, &tags); err != nil {
-			return fmt.Errorf("failed to scan row: %w", err)
+	byRemoteID := make(map[int64]*Note)
+	byKey := make(map[int64]map[string]*Note)
+	for i := range locals {
+		local := &locals[i]
+		if remoteID, ok := remoteIDs[local.ID]; ok {
+			byRemoteID[remoteID] = local
+		}
+		if byKey[local.ModelID] == nil {
+			byKey[local.ModelID] = make(map[string]*Note)
 		}
+		if keyIdx := keyIndex(local.ModelID); keyIdx < len(local.Fields) {
+			if key := matchKey(local.Fields[keyIdx]); key != "" {
+				byKey[local.ModelID][key] = local
+			}
+		}
+	}
 
-		// Split fields (front and back)
-		fields := strings.Split(flds, separator)
-		if len(fields) < 2 {
-			continue
+	var media []MediaReference
+	for n, noteInfo := range notesInfo {
+		d.progress(Progress{Phase: PhaseNotes, Done: n, Total: len(notesInfo)})
+		remoteID := noteInfo.NoteID
+		remoteFields := noteInfo.fieldValues()
+		remoteTags := noteInfo.Tags
+
+		model := pulled[noteInfo.ModelName]
+		fieldNames := model.fieldNames()
+		values := noteInfo.values(fieldNames)
+		keyIdx := keyIndex(model.ID)
+		if opts.Media {
+			for _, value := range values {
+				media = append(media, mediaReferences(value)...)
+			}
 		}
 
-		key := fields[0] + "|" + fields[1]
+		var local *Note
+		if l := byRemoteID[remoteID]; l != nil && l.ModelID == model.ID {
+			local = l
+		}
+		if local == nil && len(values) > 0 {
+			local = byKey[model.ID][matchKey(values[keyIdx])]
+		}
 
-		// Check if note already exists
-		if noteID, exists := existingMap[key]; exists {
-			// Update existing note
-			updateFields := map[string]string{
-				"Front": fields[0],
-				"Back":  fields[1],
+		if local == nil {
+			id, err := d.insertModelNote(model.ID, model.cardOrds(values), values, remoteTags)
+			if err != nil {
+				return nil, fmt.Errorf("failed to add note: %w", err)
 			}
-
-			if err := client.UpdateNoteFields(noteID, updateFields); err != nil {
-				return fmt.Errorf("failed to update note %d: %w", noteID, err)
+			if err := d.setRemoteNoteIDs([]int64{id}, []int64{remoteID}); err != nil {
+				return nil, err
 			}
-		} else {
-			// Add new note
-			note := ankiNote{
-				DeckName:  d.name,
-				ModelName: "Basic",
-				Fields: map[string]string{
-					"Front": fields[0],
-					"Back":  fields[1],
-				},
-				Options: map[string]interface{}{
-					"allowDuplicate": false,
-				},
+			if err := d.markSynced(id); err != nil {
+				return nil, err
 			}
+			report.Added++
+			continue
+		}
 
-			// Parse tags if present
-			if tags != "" {
-				note.Tags = strings.Fields(tags)
-			}
+		// Each local note is matched at most once
+		delete(byRemoteID, remoteID)
+		delete(byKey[model.ID], matchKey(local.Fields[keyIdx]))
+		if err := d.setRemoteNoteIDs([]int64{local.ID}, []int64{remoteID}); err != nil {
+			return nil, err
+		}
 
-			// Extract media references if syncMedia is enabled
-			if syncMedia {
-				note.Audio = extractMediaReferences(fields[0], fields[1], "sound")
-				note.Picture = extractMediaReferences(fields[0], fields[1], "img")
-				note.Video = extractMediaReferences(fields[0], fields[1], "video")
+		if equalStrings(local.Fields, values) && equalTags(local.Tags, remoteTags) {
+			if err := d.markSynced(local.ID); err != nil {
+				return nil, err
 			}
+			report.Unchanged++
+			continue
+		}
+
+		localChanged := modified[local.ID]
+		remoteChanged := true
+		if noteInfo.Mod > 0 && lastSync > 0 {
+			remoteChanged = noteInfo.Mod*1000 > lastSync
+		}
 
-			if _, err := client.AddNote(note); err != nil {
-				if err.Error() != "AnkiConnect error: cannot create note because it is a duplicate" {
-					return fmt.Errorf("failed to add card: %w", err)
-				}
+		switch {
+		case localChanged && remoteChanged:
+			report.Conflicts = append(report.Conflicts, PullConflict{
+				NoteID:       local.ID,
+				RemoteNoteID: remoteID,
+				Local:        fieldMap(fieldNames, local.Fields),
+				Remote:       remoteFields,
+			})
+		case localChanged:
+			report.Kept++
+		default:
+			local.Fields = values
+			local.Tags = remoteTags
+			if err := d.UpdateNote(local); err != nil {
+				return nil, fmt.Errorf("failed to update note: %w", err)
 			}
+			if err := d.markSynced(local.ID); err != nil {
+				return nil, err
+			}
+			report.Updated++
+		}
+	}
+	if len(notesInfo) > 0 {
+		d.progress(Progress{Phase: PhaseNotes, Done: len(notesInfo), Total: len(notesInfo)})
+	}
+
+	if opts.Media {
+		if err := d.pullMedia(ctx, client, media, report); err != nil {
+			return nil, err
 		}
 	}
 
-	return rows.Err()
+	if err := d.setLastSync(time.Now().UnixMilli()); err != nil {
+		return nil, err
+	}
+
+	return report, nil
 }
 
 // PushToAnki pushes the entire deck to Anki, creating it if necessary
 func (d *Deck) PushToAnki(client *AnkiConnect) error {
-	return d.PushToAnkiWithMedia(client, false)
+	return d.PushToAnkiContext(context.Background(), client)
+}
+
+// PushToAnkiContext is like PushToAnki but uses ctx for cancellation and deadlines.
+func (d *Deck) PushToAnkiContext(ctx context.Context, client *AnkiConnect) error {
+	return d.PushToAnkiWithMediaContext(ctx, client, false)
 }
 
 // PushToAnkiWithMedia pushes the deck to Anki with optional media sync
 func (d *Deck) PushToAnkiWithMedia(client *AnkiConnect, syncMedia bool) error {
+	return d.PushToAnkiWithMediaContext(context.Background(), client, syncMedia)
+}
+
+// PushToAnkiWithMediaContext is like PushToAnkiWithMedia but uses ctx for cancellation and deadlines.
+func (d *Deck) PushToAnkiWithMediaContext(ctx context.Context, client *AnkiConnect, syncMedia bool) error {
+	_, err := d.PushToAnkiWithOptionsContext(ctx, client, &PushOptions{SyncMedia: syncMedia})
+	return err
+}
+
+// PushOptions controls how the deck is pushed to Anki
+type PushOptions struct {
+	SyncMedia    bool              // Upload media files missing in Anki or with different content
+	MediaOptions *MediaSyncOptions // Controls how media is compared and uploaded with SyncMedia
+}
+
+// PushReport describes the changes a push made in Anki
+type PushReport struct {
+	Added int              // Notes added to Anki; duplicates are skipped
+	Media *MediaSyncReport // Media files uploaded, unchanged or failed, nil without SyncMedia
+}
+
+// PushToAnkiWithOptions pushes the deck to Anki like PushToAnkiWithMedia and
+// reports the notes added and the outcome of each media file. Media errors
+// are listed in the report rather than failing the push.
+func (d *Deck) PushToAnkiWithOptions(client *AnkiConnect, opts *PushOptions) (*PushReport, error) {
+	return d.PushToAnkiWithOptionsContext(context.Background(), client, opts)
+}
+
+// PushToAnkiWithOptionsContext is like PushToAnkiWithOptions but uses ctx for cancellation and deadlines.
+func (d *Deck) PushToAnkiWithOptionsContext(ctx context.Context, client *AnkiConnect, opts *PushOptions) (*PushReport, error) {
+	if opts == nil {
+		opts = &PushOptions{}
+	}
+
 	// Check connection
-	if err := client.Ping(); err != nil {
-		return fmt.Errorf("failed to connect to AnkiConnect: %w", err)
+	if err := client.PingContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to AnkiConnect: %w", err)
 	}
 
 	// Create deck if it doesn't exist
-	if err := client.CreateDeck(d.name); err != nil {
+	if err := client.CreateDeckContext(ctx, d.name); err != nil {
 		// Ignore error if deck already exists
-		if err.Error() != "AnkiConnect error: deck already exists" {
-			return fmt.Errorf("failed to create deck: %w", err)
+		if !errors.Is(err, ErrDeckExists) {
+			return nil, fmt.Errorf("failed to create deck: %w", err)
 		}
 	}
 
-	// Sync media files first if requested
-	if syncMedia && len(d.media) > 0 {
-		for _, media := range d.media {
-			if err := client.StoreMediaFile(media.Filename, media.Data); err != nil {
-				// Log but don't fail on media errors
-				fmt.Printf("Warning: failed to sync media file %s: %v\n", media.Filename, err)
-			}
-		}
+	locals, err := d.deckNotes()
+	if err != nil {
+		return nil, err
 	}
-
-	// Query cards from the database
-	rows, err := d.db.Query(`
-		SELECT n.flds, n.tags 
-		FROM notes n 
-		JOIN cards c ON c.nid = n.id 
-		WHERE c.did = ?`, d.topDeckID)
+	models, err := d.loadModels()
 	if err != nil {
-		return fmt.Errorf("failed to query cards: %w", err)
+		return nil, err
 	}
-	defer func() { _ = rows.Close() }()
-
-	// Add each card
-	for rows.Next() {
-		var flds, tags string
-		if err := rows.Scan(&flds, &tags); err != nil {
-			return fmt.Errorf("failed to scan row: %w", err)
+	noteModels := make([]noteModel, len(locals))
+	for i, local := range locals {
+		if noteModels[i], err = pushModel(models, local); err != nil {
+			return nil, err
 		}
+	}
+	if err := d.pushModels(ctx, client, d.usedModels(locals, models)); err != nil {
+		return nil, err
+	}
 
-		// Split fields (front and back)
-		fields := strings.Split(flds, separator)
-		if len(fields) < 2 {
-			continue
+	// Sync media files first if requested. Media errors don't fail a push.
+	report := &PushReport{}
+	if opts.SyncMedia {
+		report.Media, err = d.SyncMediaContext(ctx, client, opts.MediaOptions)
+		if err != nil {
+			return nil, err
+		}
+		for _, failed := range report.Media.Failed {
+			d.log(client).WarnContext(ctx, "failed to sync media file",
+				"file", failed.Filename, "error", failed.Err)
 		}
+	}
 
-		note := ankiNote{
+	var localIDs []int64
+	var notes []AnkiNote
+	for i, local := range locals {
+		// Each note is pushed with its own note type
+		model := noteModels[i]
+		note := AnkiNote{
 			DeckName:  d.name,
-			ModelName: "Basic",
-			Fields: map[string]string{
-				"Front": fields[0],
-				"Back":  fields[1],
-			},
+			ModelName: model.Name,
+			Fields:    fieldMap(model.fieldNames(), local.Fields),
+			Tags:      local.Tags,
 			Options: map[string]interface{}{
 				"allowDuplicate": false,
 			},
 		}
 
-		// Parse tags if present
-		if tags != "" {
-			note.Tags = strings.Fields(tags)
+		localIDs = append(localIDs, local.ID)
+		notes = append(notes, note)
+	}
+
+	// Duplicates are skipped
+	onBatch := func(done int) {
+		d.progress(Progress{Phase: PhaseNotes, Done: done, Total: len(notes)})
+	}
+	remoteIDs, err := d.addNotesBatched(ctx, client, localIDs, notes, onBatch)
+	if err := d.setRemoteNoteIDs(localIDs, remoteIDs); err != nil {
+		return nil, err
+	}
+	// Skipped duplicates were not written, so their local changes stay unsynced
+	added := addedNotes(localIDs, remoteIDs)
+	if err := d.markSynced(added...); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	report.Added = len(added)
+
+	if err := d.setLastSync(time.Now().UnixMilli()); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// deckQuery returns the search for the notes of a deck. Anki's deck: search
+// also matches subdecks, so they are excluded.
+func deckQuery(name string) string {
+	return fmt.Sprintf(`deck:"%s" -deck:"%s::*"`, name, name)
+}
+
+// pushModel returns the note type a note is pushed with, or an error if the
+// deck doesn't have it or the note has more values than it has fields
+func pushModel(models map[int64]noteModel, note Note) (noteModel, error) {
+	model, ok := models[note.ModelID]
+	if !ok {
+		return noteModel{}, fmt.Errorf("note %d: note type %d not found", note.ID, note.ModelID)
+	}
+	if names := model.fieldNames(); len(note.Fields) > len(names) {
+		return noteModel{}, fmt.Errorf("note %d: %d field values but note type %q has %d fields",
+			note.ID, len(note.Fields), model.Name, len(names))
+	}
+	return model, nil
+}
+
+// usedModels returns the deck's own note type and the note types of the
+// notes, ordered by ID
+func (d *Deck) usedModels(notes []Note, models map[int64]noteModel) []noteModel {
+	seen := map[int64]bool{d.topModelID: true}
+	used := []noteModel{models[d.topModelID]}
+	for _, note := range notes {
+		if model, ok := models[note.ModelID]; ok && !seen[note.ModelID] {
+			seen[note.ModelID] = true
+			used = append(used, model)
+		}
+	}
+	sort.Slice(used, func(i, j int) bool { return used[i].ID < used[j].ID })
+	return used
+}
+
+// pushModels creates the note types in Anki, or updates the templates and
+// styling of those that already exist and were changed locally since the last
+// sync, so pushed cards look the same as imported ones. Changes made in Anki
+// to note types that are unchanged locally are kept.
+func (d *Deck) pushModels(ctx context.Context, client *AnkiConnect, models []noteModel) error {
+	ls, err := d.lastSync()
+	if err != nil {
+		return err
+	}
+	names, err := client.ModelNamesContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get model names: %w", err)
+	}
+	for _, model := range models {
+		exists := indexOf(names, model.Name) >= 0
+		if err := client.storeModel(ctx, model, exists, model.changedSince(ls)); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		// Extract media references from card content if syncMedia is enabled
-		if syncMedia {
-			note.Audio = extractMediaReferences(fields[0], fields[1], "sound")
-			note.Picture = extractMediaReferences(fields[0], fields[1], "img")
-			note.Video = extractMediaReferences(fields[0], fields[1], "video")
+// storeModel creates a note type in Anki, or, if it already exists and
+// changed is set, updates its templates and styling where they differ from
+// the local ones. It returns a *ModelMismatchError if the note type in Anki
+// lacks local fields or templates.
+func (ac *AnkiConnect) storeModel(ctx context.Context, model noteModel, exists, changed bool) error {
+	if !exists {
+		if err := ac.CreateModelContext(ctx, model.toNoteModel()); err != nil {
+			return fmt.Errorf("failed to create model: %w", err)
 		}
+		return nil
+	}
 
-		if _, err := client.AddNote(note); err != nil {
-			// Skip duplicates
-			if err.Error() != "AnkiConnect error: cannot create note because it is a duplicate" {
-				return fmt.Errorf("failed to add card: %w", err)
-			}
+	remote, err := ac.remoteModel(ctx, model.Name)
+	if err != nil {
+		return err
+	}
+	if err := checkModel(model, remote); err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+	if templates := model.cardTemplates(); !equalTemplates(remote.Templates, templates) {
+		if err := ac.UpdateModelTemplatesContext(ctx, model.Name, templates); err != nil {
+			return fmt.Errorf("failed to update model templates: %w", err)
+		}
+	}
+	if remote.CSS != model.CSS {
+		if err := ac.UpdateModelStylingContext(ctx, model.Name, model.CSS); err != nil {
+			return fmt.Errorf("failed to update model styling: %w", err)
 		}
 	}
+	return nil
+}
 
-	return rows.Err()
+// checkModel returns a *ModelMismatchError if the note type in Anki lacks
+// fields or templates of the local one. Fields only in Anki are left empty.
+func checkModel(local noteModel, remote NoteModel) error {
+	mismatch := &ModelMismatchError{Model: local.Name}
+	for _, name := range local.fieldNames() {
+		if indexOf(remote.Fields, name) < 0 {
+			mismatch.MissingFields = append(mismatch.MissingFields, name)
+		}
+	}
+	remoteTemplates := templateNames(remote.Templates)
+	for _, t := range local.cardTemplates() {
+		if indexOf(remoteTemplates, t.Name) < 0 {
+			mismatch.MissingTemplates = append(mismatch.MissingTemplates, t.Name)
+		}
+	}
+	if len(mismatch.MissingFields) > 0 || len(mismatch.MissingTemplates) > 0 {
+		return mismatch
+	}
+	return nil
 }
 
-// extractMediaReferences extracts media filenames from card content
-func extractMediaReferences(front, back string, mediaType string) []ankiMedia {
-	var media []ankiMedia
+// changedModels returns the names of the note types that are missing in Anki,
+// or were changed locally since the last sync at ls and whose templates or
+// styling differ from the local ones. Like storeModel, it returns a
+// *ModelMismatchError for note types notes can't be pushed with.
+func (ac *AnkiConnect) changedModels(ctx context.Context, models []noteModel, ls int64) ([]string, error) {
+	names, err := ac.ModelNamesContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get model names: %w", err)
+	}
 
-	// Simple extraction - in production, use proper HTML parsing
-	switch mediaType {
-	case "sound":
-		// Look for [sound:filename] patterns
-		if idx := strings.Index(front, "[sound:"); idx >= 0 {
-			end := strings.Index(front[idx:], "]")
-			if end > 0 {
-				filename := front[idx+7 : idx+end]
-				media = append(media, ankiMedia{
-					Filename: filename,
-					Fields:   []string{"Front"},
-				})
-			}
+	var changed []string
+	for _, model := range models {
+		if indexOf(names, model.Name) < 0 {
+			changed = append(changed, model.Name)
+			continue
 		}
-		if idx := strings.Index(back, "[sound:"); idx >= 0 {
-			end := strings.Index(back[idx:], "]")
-			if end > 0 {
-				filename := back[idx+7 : idx+end]
-				media = append(media, ankiMedia{
-					Filename: filename,
-					Fields:   []string{"Back"},
-				})
-			}
+		remote, err := ac.remoteModel(ctx, model.Name)
+		if err != nil {
+			return nil, err
 		}
-	case "img":
-		// Look for <img src="filename"> patterns
-		if idx := strings.Index(front, `<img src="`); idx >= 0 {
-			end := strings.Index(front[idx+10:], `"`)
-			if end > 0 {
-				filename := front[idx+10 : idx+10+end]
-				media = append(media, ankiMedia{
-					Filename: filename,
-					Fields:   []string{"Front"},
-				})
-			}
+		if err := checkModel(model, remote); err != nil {
+			return nil, err
 		}
-		if idx := strings.Index(back, `<img src="`); idx >= 0 {
-			end := strings.Index(back[idx+10:], `"`)
-			if end > 0 {
-				filename := back[idx+10 : idx+10+end]
-				media = append(media, ankiMedia{
-					Filename: filename,
-					Fields:   []string{"Back"},
-				})
-			}
+		if !model.changedSince(ls) {
+			continue
+		}
+		if remote.CSS != model.CSS || !equalTemplates(remote.Templates, model.cardTemplates()) {
+			changed = append(changed, model.Name)
 		}
 	}
+	return changed, nil
+}
+
+func equalTemplates(a, b []CardTemplate) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// remoteModel reads the definition of a note type from Anki
+func (ac *AnkiConnect) remoteModel(ctx context.Context, name string) (NoteModel, error) {
+	fields, err := ac.ModelFieldNamesContext(ctx, name)
+	if err != nil {
+		return NoteModel{}, fmt.Errorf("failed to get fields of model %s: %w", name, err)
+	}
+	templates, err := ac.ModelTemplatesContext(ctx, name)
+	if err != nil {
+		return NoteModel{}, fmt.Errorf("failed to get templates of model %s: %w", name, err)
+	}
+	css, err := ac.ModelStylingContext(ctx, name)
+	if err != nil {
+		return NoteModel{}, fmt.Errorf("failed to get styling of model %s: %w", name, err)
+	}
 
-	return media
+	// AnkiConnect doesn't report the kind of note type, but only cloze
+	// note types use cloze deletions in their templates
+	model := NoteModel{Name: name, Fields: fields, CSS: css, Templates: templates}
+	for _, t := range templates {
+		if strings.Contains(t.Front, "{{cloze:") {
+			model.IsCloze = true
+		}
+	}
+	return model, nil
 }
 
 // SyncToAnki performs a more sophisticated sync with options
 func (d *Deck) SyncToAnki(client *AnkiConnect, opts *SyncOptions) error {
-	// Use default options if none provided
-	syncOpts := opts
-	if syncOpts == nil {
-		syncOpts = &SyncOptions{
-			UpdateExisting: true,
-			DeleteMissing:  false,
-			SyncMedia:      false,
+	return d.SyncToAnkiContext(context.Background(), client, opts)
+}
+
+// SyncToAnkiContext is like SyncToAnki but uses ctx for cancellation and deadlines.
+func (d *Deck) SyncToAnkiContext(ctx context.Context, client *AnkiConnect, opts *SyncOptions) error {
+	_, err := d.SyncToAnkiWithReportContext(ctx, client, opts)
+	return err
+}
+
+// SyncToAnkiWithReport syncs like SyncToAnki and reports the changes made.
+// It applies the plan returned by PlanSync, so with DeleteMissing nothing is
+// changed if more than MaxDeletes notes would be deleted.
+func (d *Deck) SyncToAnkiWithReport(client *AnkiConnect, opts *SyncOptions) (*SyncReport, error) {
+	return d.SyncToAnkiWithReportContext(context.Background(), client, opts)
+}
+
+// SyncToAnkiWithReportContext is like SyncToAnkiWithReport but uses ctx for cancellation and deadlines.
+func (d *Deck) SyncToAnkiWithReportContext(ctx context.Context, client *AnkiConnect, opts *SyncOptions) (*SyncReport, error) {
+	plan, err := d.PlanSyncContext(ctx, client, opts)
+	if err != nil {
+		return nil, err
+	}
+	return d.ApplySyncContext(ctx, client, plan)
+}
+
+// matchRemoteNotes pairs local notes with notes in Anki. Notes are matched by
+// the Anki note ID recorded when they were last synced and otherwise by the
+// key field, so editing any other field keeps the pairing. It returns the
+// remote note ID for each matched local note and the remote notes left
+// unmatched.
+func matchRemoteNotes(locals []Note, remoteIDs map[int64]int64, notesInfo []NoteInfo, fieldNames []string, keyIdx int) (map[int64]int64, []NoteInfo) {
+	byID := make(map[int64]int)
+	byKey := make(map[string]int)
+	for i, noteInfo := range notesInfo {
+		byID[noteInfo.NoteID] = i
+		if keyIdx < len(fieldNames) {
+			key := matchKey(noteInfo.fieldValues()[fieldNames[keyIdx]])
+			if _, exists := byKey[key]; key != "" && !exists {
+				byKey[key] = i
+			}
 		}
 	}
 
-	// Check connection
-	if err := client.Ping(); err != nil {
-		return fmt.Errorf("failed to connect to AnkiConnect: %w", err)
+	matches := make(map[int64]int64)
+	used := make(map[int]bool)
+	match := func(local Note, i int) {
+		used[i] = true
+		matches[local.ID] = notesInfo[i].NoteID
+	}
+
+	// Recorded IDs are matched first, so a key field match can't take a
+	// note that is already paired
+	var rest []Note
+	for _, local := range locals {
+		if i, ok := byID[remoteIDs[local.ID]]; ok && remoteIDs[local.ID] != 0 {
+			match(local, i)
+			continue
+		}
+		rest = append(rest, local)
+	}
+	for _, local := range rest {
+		if keyIdx >= len(local.Fields) {
+			continue
+		}
+		// Each remote note is matched at most once
+		if i, ok := byKey[matchKey(local.Fields[keyIdx])]; ok && !used[i] {
+			match(local, i)
+		}
 	}
 
-	// Create deck if needed
-	if err := client.CreateDeck(d.name); err != nil {
-		if err.Error() != "AnkiConnect error: deck already exists" {
-			return fmt.Errorf("failed to create deck: %w", err)
+	var unmatched []NoteInfo
+	for i, noteInfo := range notesInfo {
+		if !used[i] {
+			unmatched = append(unmatched, noteInfo)
 		}
 	}
 
-	// Find existing notes in the deck
-	query := fmt.Sprintf("deck:\"%s\"", d.name)
-	existingNotes, err := client.FindNotes(query)
-	if err != nil {
-		return fmt.Errorf("failed to find existing notes: %w", err)
+	return matches, unmatched
+}
+
+// matchModelNotes pairs local notes with notes in Anki of the same note type,
+// as matchRemoteNotes does, for each of the note types. The key field of note
+// types without it defaults to the first field.
+func matchModelNotes(locals []Note, remoteIDs map[int64]int64, notesInfo []NoteInfo, models []noteModel, keyField string) (map[int64]int64, []NoteInfo, error) {
+	if err := checkKeyField(models, keyField); err != nil {
+		return nil, nil, err
 	}
 
-	// If UpdateExisting is true and there are existing notes, update them
-	if syncOpts.UpdateExisting && len(existingNotes) > 0 {
-		// Get detailed info about existing notes
-		notesInfo, err := client.GetNotesInfo(existingNotes)
-		if err != nil {
-			return fmt.Errorf("failed to get notes info: %w", err)
-		}
-
-		// Create a map of existing notes by content for quick lookup
-		existingMap := make(map[string]int64)
-		for _, noteInfo := range notesInfo {
-			if fields, ok := noteInfo["fields"].(map[string]interface{}); ok {
-				var front, back string
-				if f, ok := fields["Front"].(map[string]interface{}); ok {
-					if v, ok := f["value"].(string); ok {
-						front = v
-					}
-				}
-				if b, ok := fields["Back"].(map[string]interface{}); ok {
-					if v, ok := b["value"].(string); ok {
-						back = v
-					}
-				}
-				if noteID, ok := noteInfo["noteId"].(float64); ok {
-					key := front + "|" + back
-					existingMap[key] = int64(noteID)
-				}
-			}
+	localsByModel := make(map[int64][]Note)
+	for _, local := range locals {
+		localsByModel[local.ModelID] = append(localsByModel[local.ModelID], local)
+	}
+	remoteByModel := make(map[string][]NoteInfo)
+	for _, noteInfo := range notesInfo {
+		remoteByModel[noteInfo.ModelName] = append(remoteByModel[noteInfo.ModelName], noteInfo)
+	}
+
+	matches := make(map[int64]int64)
+	matched := make(map[int64]bool)
+	for _, model := range models {
+		fieldNames := model.fieldNames()
+		keyIdx := max(indexOf(fieldNames, keyField), 0)
+		pairs, _ := matchRemoteNotes(localsByModel[model.ID], remoteIDs, remoteByModel[model.Name], fieldNames, keyIdx)
+		for id, remoteID := range pairs {
+			matches[id] = remoteID
+			matched[remoteID] = true
 		}
+	}
 
-		// Update existing notes and add new ones
-		return d.syncWithExisting(client, existingMap, syncOpts.SyncMedia)
+	var unmatched []NoteInfo
+	for _, noteInfo := range notesInfo {
+		if !matched[noteInfo.NoteID] {
+			unmatched = append(unmatched, noteInfo)
+		}
 	}
+	return matches, unmatched, nil
+}
 
-	// No existing notes, just push all cards
-	return d.PushToAnkiWithMedia(client, syncOpts.SyncMedia)
+// checkKeyField returns an error unless one of the note types has the key field
+func checkKeyField(models []noteModel, keyField string) error {
+	if keyField == "" {
+		return nil
+	}
+	for _, model := range models {
+		if indexOf(model.fieldNames(), keyField) >= 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown key field %q", keyField)
+}
+
+// keyFieldIndex returns the position of the field used to match notes,
+// defaulting to the first field
+func keyFieldIndex(fieldNames []string, keyField string) (int, error) {
+	if keyField == "" {
+		return 0, nil
+	}
+	idx := indexOf(fieldNames, keyField)
+	if idx < 0 {
+		return 0, fmt.Errorf("unknown key field %q", keyField)
+	}
+	return idx, nil
+}
+
+// fieldValues returns the values of the note's fields by name
+func (n NoteInfo) fieldValues() map[string]string {
+	values := make(map[string]string, len(n.Fields))
+	for name, field := range n.Fields {
+		values[name] = field.Value
+	}
+	return values
 }
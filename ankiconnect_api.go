@@ -0,0 +1,700 @@
+package anki
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrMediaNotFound is returned when a media file does not exist in Anki
+var ErrMediaNotFound = errors.New("media file not found")
+
+// invokeInto makes a request to AnkiConnect API and decodes the result into out
+func (ac *AnkiConnect) invokeInto(ctx context.Context, action string, params interface{}, out interface{}) error {
+	raw, err := ac.invokeRaw(ctx, action, params)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return fmt.Errorf("unexpected %s response: %w", action, err)
+	}
+	return nil
+}
+
+// Cards
+
+// CardInfo describes a card as returned by cardsInfo
+type CardInfo struct {
+	CardID     int64                `json:"cardId"`
+	NoteID     int64                `json:"note"`
+	DeckName   string               `json:"deckName"`
+	ModelName  string               `json:"modelName"`
+	Fields     map[string]NoteField `json:"fields"`
+	FieldOrder int                  `json:"fieldOrder"`
+	Question   string               `json:"question"`
+	Answer     string               `json:"answer"`
+	CSS        string               `json:"css"`
+	Ord        int                  `json:"ord"`
+	Type       int                  `json:"type"`
+	Queue      int                  `json:"queue"`
+	Due        int64                `json:"due"`
+	Interval   int                  `json:"interval"`
+	Factor     int                  `json:"factor"`
+	Reps       int                  `json:"reps"`
+	Lapses     int                  `json:"lapses"`
+	Left       int                  `json:"left"`
+	Mod        int64                `json:"mod"`
+}
+
+// CardAnswer is an answer given to a card with AnswerCards
+type CardAnswer struct {
+	CardID int64 `json:"cardId"`
+	Ease   int   `json:"ease"` // 1 = again, 2 = hard, 3 = good, 4 = easy
+}
+
+// FindCards searches for cards matching a query
+func (ac *AnkiConnect) FindCards(query string) ([]int64, error) {
+	return ac.FindCardsContext(context.Background(), query)
+}
+
+// FindCardsContext is like FindCards but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) FindCardsContext(ctx context.Context, query string) ([]int64, error) {
+	var ids []int64
+	err := ac.invokeInto(ctx, "findCards", map[string]string{"query": query}, &ids)
+	return ids, err
+}
+
+// CardsInfo returns information about cards
+func (ac *AnkiConnect) CardsInfo(cardIDs []int64) ([]CardInfo, error) {
+	return ac.CardsInfoContext(context.Background(), cardIDs)
+}
+
+// CardsInfoContext is like CardsInfo but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) CardsInfoContext(ctx context.Context, cardIDs []int64) ([]CardInfo, error) {
+	var cards []CardInfo
+	err := ac.invokeInto(ctx, "cardsInfo", map[string]interface{}{"cards": cardIDs}, &cards)
+	return cards, err
+}
+
+// Suspend suspends cards. It reports false if all of them were already suspended.
+func (ac *AnkiConnect) Suspend(cardIDs []int64) (bool, error) {
+	return ac.SuspendContext(context.Background(), cardIDs)
+}
+
+// SuspendContext is like Suspend but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) SuspendContext(ctx context.Context, cardIDs []int64) (bool, error) {
+	var changed bool
+	err := ac.invokeInto(ctx, "suspend", map[string]interface{}{"cards": cardIDs}, &changed)
+	return changed, err
+}
+
+// Unsuspend unsuspends cards. It reports false if none of them were suspended.
+func (ac *AnkiConnect) Unsuspend(cardIDs []int64) (bool, error) {
+	return ac.UnsuspendContext(context.Background(), cardIDs)
+}
+
+// UnsuspendContext is like Unsuspend but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) UnsuspendContext(ctx context.Context, cardIDs []int64) (bool, error) {
+	var changed bool
+	err := ac.invokeInto(ctx, "unsuspend", map[string]interface{}{"cards": cardIDs}, &changed)
+	return changed, err
+}
+
+// GetEaseFactors returns the ease factor of each card
+func (ac *AnkiConnect) GetEaseFactors(cardIDs []int64) ([]int, error) {
+	return ac.GetEaseFactorsContext(context.Background(), cardIDs)
+}
+
+// GetEaseFactorsContext is like GetEaseFactors but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) GetEaseFactorsContext(ctx context.Context, cardIDs []int64) ([]int, error) {
+	var factors []int
+	err := ac.invokeInto(ctx, "getEaseFactors", map[string]interface{}{"cards": cardIDs}, &factors)
+	return factors, err
+}
+
+// SetEaseFactors sets the ease factor of each card and reports which cards exist
+func (ac *AnkiConnect) SetEaseFactors(cardIDs []int64, easeFactors []int) ([]bool, error) {
+	return ac.SetEaseFactorsContext(context.Background(), cardIDs, easeFactors)
+}
+
+// SetEaseFactorsContext is like SetEaseFactors but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) SetEaseFactorsContext(ctx context.Context, cardIDs []int64, easeFactors []int) ([]bool, error) {
+	params := map[string]interface{}{
+		"cards":       cardIDs,
+		"easeFactors": easeFactors,
+	}
+	var results []bool
+	err := ac.invokeInto(ctx, "setEaseFactors", params, &results)
+	return results, err
+}
+
+// AnswerCards answers cards and reports which cards exist
+func (ac *AnkiConnect) AnswerCards(answers []CardAnswer) ([]bool, error) {
+	return ac.AnswerCardsContext(context.Background(), answers)
+}
+
+// AnswerCardsContext is like AnswerCards but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) AnswerCardsContext(ctx context.Context, answers []CardAnswer) ([]bool, error) {
+	var results []bool
+	err := ac.invokeInto(ctx, "answerCards", map[string]interface{}{"answers": answers}, &results)
+	return results, err
+}
+
+// Decks
+
+// DeckConfig is a deck options group. Settings not covered by the struct are
+// kept as read, so a config from GetDeckConfig can be changed and saved.
+type DeckConfig struct {
+	ID       int64        `json:"id"`
+	Name     string       `json:"name"`
+	Mod      int64        `json:"mod"`
+	Usn      int          `json:"usn"`
+	MaxTaken int          `json:"maxTaken"`
+	Autoplay bool         `json:"autoplay"`
+	Timer    int          `json:"timer"`
+	ReplayQ  bool         `json:"replayq"`
+	Dyn      bool         `json:"dyn"`
+	New      NewConfig    `json:"new"`
+	Rev      ReviewConfig `json:"rev"`
+	Lapse    LapseConfig  `json:"lapse"`
+
+	extra map[string]json.RawMessage
+}
+
+// NewConfig holds the settings for new cards
+type NewConfig struct {
+	Bury          bool      `json:"bury"`
+	Delays        []float64 `json:"delays"`
+	InitialFactor int       `json:"initialFactor"`
+	Ints          []int     `json:"ints"`
+	Order         int       `json:"order"`
+	PerDay        int       `json:"perDay"`
+
+	extra map[string]json.RawMessage
+}
+
+// ReviewConfig holds the settings for reviews
+type ReviewConfig struct {
+	Bury       bool    `json:"bury"`
+	Ease4      float64 `json:"ease4"`
+	IvlFct     float64 `json:"ivlFct"`
+	MaxIvl     int     `json:"maxIvl"`
+	PerDay     int     `json:"perDay"`
+	HardFactor float64 `json:"hardFactor"`
+
+	extra map[string]json.RawMessage
+}
+
+// LapseConfig holds the settings for lapsed cards
+type LapseConfig struct {
+	Delays      []float64 `json:"delays"`
+	LeechAction int       `json:"leechAction"`
+	LeechFails  int       `json:"leechFails"`
+	MinInt      int       `json:"minInt"`
+	Mult        float64   `json:"mult"`
+
+	extra map[string]json.RawMessage
+}
+
+// The aliases below drop the JSON methods so the structs can be encoded and
+// decoded with the default behaviour inside them.
+type (
+	deckConfigJSON   DeckConfig
+	newConfigJSON    NewConfig
+	reviewConfigJSON ReviewConfig
+	lapseConfigJSON  LapseConfig
+)
+
+func (c *DeckConfig) UnmarshalJSON(data []byte) error {
+	return unmarshalKeepingExtra(data, (*deckConfigJSON)(c), &c.extra)
+}
+
+func (c DeckConfig) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(deckConfigJSON(c), c.extra)
+}
+
+func (c *NewConfig) UnmarshalJSON(data []byte) error {
+	return unmarshalKeepingExtra(data, (*newConfigJSON)(c), &c.extra)
+}
+
+func (c NewConfig) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(newConfigJSON(c), c.extra)
+}
+
+func (c *ReviewConfig) UnmarshalJSON(data []byte) error {
+	return unmarshalKeepingExtra(data, (*reviewConfigJSON)(c), &c.extra)
+}
+
+func (c ReviewConfig) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(reviewConfigJSON(c), c.extra)
+}
+
+func (c *LapseConfig) UnmarshalJSON(data []byte) error {
+	return unmarshalKeepingExtra(data, (*lapseConfigJSON)(c), &c.extra)
+}
+
+func (c LapseConfig) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(lapseConfigJSON(c), c.extra)
+}
+
+// unmarshalKeepingExtra decodes data into v and stores every key in extra
+func unmarshalKeepingExtra(data []byte, v interface{}, extra *map[string]json.RawMessage) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, extra)
+}
+
+// marshalWithExtra encodes v over the keys in extra, so keys v doesn't
+// know about survive a round trip
+func marshalWithExtra(v interface{}, extra map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+
+	var known map[string]json.RawMessage
+	if err := json.Unmarshal(data, &known); err != nil {
+		return nil, err
+	}
+	merged := make(map[string]json.RawMessage, len(extra)+len(known))
+	for k, v := range extra {
+		merged[k] = v
+	}
+	for k, v := range known {
+		merged[k] = v
+	}
+	return json.Marshal(merged)
+}
+
+// DeckNamesAndIDs returns all deck names with their IDs
+func (ac *AnkiConnect) DeckNamesAndIDs() (map[string]int64, error) {
+	return ac.DeckNamesAndIDsContext(context.Background())
+}
+
+// DeckNamesAndIDsContext is like DeckNamesAndIDs but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) DeckNamesAndIDsContext(ctx context.Context) (map[string]int64, error) {
+	var decks map[string]int64
+	err := ac.invokeInto(ctx, "deckNamesAndIds", nil, &decks)
+	return decks, err
+}
+
+// GetDecks returns the IDs of the given cards grouped by deck name
+func (ac *AnkiConnect) GetDecks(cardIDs []int64) (map[string][]int64, error) {
+	return ac.GetDecksContext(context.Background(), cardIDs)
+}
+
+// GetDecksContext is like GetDecks but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) GetDecksContext(ctx context.Context, cardIDs []int64) (map[string][]int64, error) {
+	var decks map[string][]int64
+	err := ac.invokeInto(ctx, "getDecks", map[string]interface{}{"cards": cardIDs}, &decks)
+	return decks, err
+}
+
+// ChangeDeck moves cards to a deck, creating the deck if needed
+func (ac *AnkiConnect) ChangeDeck(cardIDs []int64, deck string) error {
+	return ac.ChangeDeckContext(context.Background(), cardIDs, deck)
+}
+
+// ChangeDeckContext is like ChangeDeck but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) ChangeDeckContext(ctx context.Context, cardIDs []int64, deck string) error {
+	params := map[string]interface{}{
+		"cards": cardIDs,
+		"deck":  deck,
+	}
+	_, err := ac.invoke(ctx, "changeDeck", params)
+	return err
+}
+
+// GetDeckConfig returns the options group used by a deck
+func (ac *AnkiConnect) GetDeckConfig(deck string) (*DeckConfig, error) {
+	return ac.GetDeckConfigContext(context.Background(), deck)
+}
+
+// GetDeckConfigContext is like GetDeckConfig but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) GetDeckConfigContext(ctx context.Context, deck string) (*DeckConfig, error) {
+	var config DeckConfig
+	if err := ac.invokeInto(ctx, "getDeckConfig", map[string]string{"deck": deck}, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// SaveDeckConfig saves an options group. It reports false if the group doesn't exist.
+func (ac *AnkiConnect) SaveDeckConfig(config *DeckConfig) (bool, error) {
+	return ac.SaveDeckConfigContext(context.Background(), config)
+}
+
+// SaveDeckConfigContext is like SaveDeckConfig but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) SaveDeckConfigContext(ctx context.Context, config *DeckConfig) (bool, error) {
+	var saved bool
+	err := ac.invokeInto(ctx, "saveDeckConfig", map[string]interface{}{"config": config}, &saved)
+	return saved, err
+}
+
+// SetDeckConfigID assigns an options group to decks
+func (ac *AnkiConnect) SetDeckConfigID(decks []string, configID int64) (bool, error) {
+	return ac.SetDeckConfigIDContext(context.Background(), decks, configID)
+}
+
+// SetDeckConfigIDContext is like SetDeckConfigID but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) SetDeckConfigIDContext(ctx context.Context, decks []string, configID int64) (bool, error) {
+	params := map[string]interface{}{
+		"decks":    decks,
+		"configId": configID,
+	}
+	var saved bool
+	err := ac.invokeInto(ctx, "setDeckConfigId", params, &saved)
+	return saved, err
+}
+
+// Models
+
+// ModelNamesAndIDs returns all note type names with their IDs
+func (ac *AnkiConnect) ModelNamesAndIDs() (map[string]int64, error) {
+	return ac.ModelNamesAndIDsContext(context.Background())
+}
+
+// ModelNamesAndIDsContext is like ModelNamesAndIDs but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) ModelNamesAndIDsContext(ctx context.Context) (map[string]int64, error) {
+	var models map[string]int64
+	err := ac.invokeInto(ctx, "modelNamesAndIds", nil, &models)
+	return models, err
+}
+
+// ModelFieldNames returns the field names of a note type in order
+func (ac *AnkiConnect) ModelFieldNames(modelName string) ([]string, error) {
+	return ac.ModelFieldNamesContext(context.Background(), modelName)
+}
+
+// ModelFieldNamesContext is like ModelFieldNames but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) ModelFieldNamesContext(ctx context.Context, modelName string) ([]string, error) {
+	var names []string
+	err := ac.invokeInto(ctx, "modelFieldNames", map[string]string{"modelName": modelName}, &names)
+	return names, err
+}
+
+// ModelTemplates returns the card templates of a note type in order
+func (ac *AnkiConnect) ModelTemplates(modelName string) ([]CardTemplate, error) {
+	return ac.ModelTemplatesContext(context.Background(), modelName)
+}
+
+// ModelTemplatesContext is like ModelTemplates but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) ModelTemplatesContext(ctx context.Context, modelName string) ([]CardTemplate, error) {
+	raw, err := ac.invokeRaw(ctx, "modelTemplates", map[string]string{"modelName": modelName})
+	if err != nil {
+		return nil, err
+	}
+
+	// The result is an object keyed by template name in card order
+	var byName map[string]CardTemplate
+	if err := json.Unmarshal(raw, &byName); err != nil {
+		return nil, fmt.Errorf("unexpected modelTemplates response: %w", err)
+	}
+	names, err := objectKeys(raw)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected modelTemplates response: %w", err)
+	}
+
+	templates := make([]CardTemplate, len(names))
+	for i, name := range names {
+		templates[i] = byName[name]
+		templates[i].Name = name
+	}
+	return templates, nil
+}
+
+// objectKeys returns the keys of a JSON object in document order
+func objectKeys(data []byte) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	var keys []string
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key.(string))
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// ModelStyling returns the CSS of a note type
+func (ac *AnkiConnect) ModelStyling(modelName string) (string, error) {
+	return ac.ModelStylingContext(context.Background(), modelName)
+}
+
+// ModelStylingContext is like ModelStyling but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) ModelStylingContext(ctx context.Context, modelName string) (string, error) {
+	var styling struct {
+		CSS string `json:"css"`
+	}
+	err := ac.invokeInto(ctx, "modelStyling", map[string]string{"modelName": modelName}, &styling)
+	return styling.CSS, err
+}
+
+// Notes
+
+// NoteInfo describes a note as returned by notesInfo
+type NoteInfo struct {
+	NoteID    int64                `json:"noteId"`
+	ModelName string               `json:"modelName"`
+	Tags      []string             `json:"tags"`
+	Fields    map[string]NoteField `json:"fields"`
+	Cards     []int64              `json:"cards"`
+	Mod       int64                `json:"mod"`
+}
+
+// NoteField is the value of a note field and its position in the note type
+type NoteField struct {
+	Value string `json:"value"`
+	Order int    `json:"order"`
+}
+
+// NotesInfo returns information about notes
+func (ac *AnkiConnect) NotesInfo(noteIDs []int64) ([]NoteInfo, error) {
+	return ac.NotesInfoContext(context.Background(), noteIDs)
+}
+
+// NotesInfoContext is like NotesInfo but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) NotesInfoContext(ctx context.Context, noteIDs []int64) ([]NoteInfo, error) {
+	var notes []NoteInfo
+	err := ac.invokeInto(ctx, "notesInfo", map[string]interface{}{"notes": noteIDs}, &notes)
+	return notes, err
+}
+
+// UpdateNote replaces the fields and tags of a note. A nil tags slice leaves
+// the tags unchanged.
+func (ac *AnkiConnect) UpdateNote(noteID int64, fields map[string]string, tags []string) error {
+	return ac.UpdateNoteContext(context.Background(), noteID, fields, tags)
+}
+
+// UpdateNoteContext is like UpdateNote but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) UpdateNoteContext(ctx context.Context, noteID int64, fields map[string]string, tags []string) error {
+	note := map[string]interface{}{
+		"id":     noteID,
+		"fields": fields,
+	}
+	if tags != nil {
+		note["tags"] = tags
+	}
+	if !ac.supports("updateNote") {
+		return ac.updateNoteEach(ctx, noteID, fields, tags)
+	}
+
+	_, err := ac.invoke(ctx, "updateNote", map[string]interface{}{"note": note})
+	if err != nil && ac.unsupportedFallback("updateNote", err) {
+		return ac.updateNoteEach(ctx, noteID, fields, tags)
+	}
+	return err
+}
+
+// updateNoteEach updates fields and tags with separate actions, for servers
+// without updateNote
+func (ac *AnkiConnect) updateNoteEach(ctx context.Context, noteID int64, fields map[string]string, tags []string) error {
+	if err := ac.UpdateNoteFieldsContext(ctx, noteID, fields); err != nil {
+		return err
+	}
+	if tags == nil {
+		return nil
+	}
+
+	notes, err := ac.NotesInfoContext(ctx, []int64{noteID})
+	if err != nil {
+		return err
+	}
+	if len(notes) == 0 {
+		return fmt.Errorf("note %d not found", noteID)
+	}
+	if remove := missingTags(notes[0].Tags, tags); len(remove) > 0 {
+		if err := ac.RemoveTagsContext(ctx, []int64{noteID}, remove); err != nil {
+			return err
+		}
+	}
+	if add := missingTags(tags, notes[0].Tags); len(add) > 0 {
+		if err := ac.AddTagsContext(ctx, []int64{noteID}, add); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTags returns all tags in the collection
+func (ac *AnkiConnect) GetTags() ([]string, error) {
+	return ac.GetTagsContext(context.Background())
+}
+
+// GetTagsContext is like GetTags but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) GetTagsContext(ctx context.Context) ([]string, error) {
+	var tags []string
+	err := ac.invokeInto(ctx, "getTags", nil, &tags)
+	return tags, err
+}
+
+// Media
+
+// RetrieveMediaFile returns the contents of a file in Anki's media folder
+func (ac *AnkiConnect) RetrieveMediaFile(filename string) ([]byte, error) {
+	return ac.RetrieveMediaFileContext(context.Background(), filename)
+}
+
+// RetrieveMediaFileContext is like RetrieveMediaFile but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) RetrieveMediaFileContext(ctx context.Context, filename string) ([]byte, error) {
+	result, err := ac.invoke(ctx, "retrieveMediaFile", map[string]string{"filename": filename})
+	if err != nil {
+		return nil, err
+	}
+
+	// AnkiConnect returns false for missing files
+	encoded, ok := result.(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMediaNotFound, filename)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode media file %s: %w", filename, err)
+	}
+	return data, nil
+}
+
+// GetMediaFilesNames returns the names of media files matching a glob pattern
+func (ac *AnkiConnect) GetMediaFilesNames(pattern string) ([]string, error) {
+	return ac.GetMediaFilesNamesContext(context.Background(), pattern)
+}
+
+// GetMediaFilesNamesContext is like GetMediaFilesNames but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) GetMediaFilesNamesContext(ctx context.Context, pattern string) ([]string, error) {
+	var names []string
+	err := ac.invokeInto(ctx, "getMediaFilesNames", map[string]string{"pattern": pattern}, &names)
+	return names, err
+}
+
+// GetMediaDirPath returns the path of Anki's media folder
+func (ac *AnkiConnect) GetMediaDirPath() (string, error) {
+	return ac.GetMediaDirPathContext(context.Background())
+}
+
+// GetMediaDirPathContext is like GetMediaDirPath but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) GetMediaDirPathContext(ctx context.Context) (string, error) {
+	var path string
+	err := ac.invokeInto(ctx, "getMediaDirPath", nil, &path)
+	return path, err
+}
+
+// DeleteMediaFile deletes a file from Anki's media folder
+func (ac *AnkiConnect) DeleteMediaFile(filename string) error {
+	return ac.DeleteMediaFileContext(context.Background(), filename)
+}
+
+// DeleteMediaFileContext is like DeleteMediaFile but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) DeleteMediaFileContext(ctx context.Context, filename string) error {
+	_, err := ac.invoke(ctx, "deleteMediaFile", map[string]string{"filename": filename})
+	return err
+}
+
+// Statistics
+
+// ReviewDay is the number of cards reviewed on a day
+type ReviewDay struct {
+	Date  string // YYYY-MM-DD
+	Count int
+}
+
+// UnmarshalJSON decodes the [date, count] pairs returned by AnkiConnect
+func (r *ReviewDay) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &[]interface{}{&r.Date, &r.Count})
+}
+
+// CardReview is an entry of a deck's review log
+type CardReview struct {
+	ReviewTime       int64 // Review ID, the time of the review in milliseconds
+	CardID           int64
+	Usn              int
+	ButtonPressed    int
+	NewInterval      int
+	PreviousInterval int
+	NewFactor        int
+	ReviewDuration   int // Milliseconds
+	ReviewType       int
+}
+
+// UnmarshalJSON decodes the review tuples returned by AnkiConnect
+func (r *CardReview) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &[]interface{}{
+		&r.ReviewTime, &r.CardID, &r.Usn, &r.ButtonPressed, &r.NewInterval,
+		&r.PreviousInterval, &r.NewFactor, &r.ReviewDuration, &r.ReviewType,
+	})
+}
+
+// GetNumCardsReviewedToday returns the number of cards reviewed today
+func (ac *AnkiConnect) GetNumCardsReviewedToday() (int, error) {
+	return ac.GetNumCardsReviewedTodayContext(context.Background())
+}
+
+// GetNumCardsReviewedTodayContext is like GetNumCardsReviewedToday but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) GetNumCardsReviewedTodayContext(ctx context.Context) (int, error) {
+	var count int
+	err := ac.invokeInto(ctx, "getNumCardsReviewedToday", nil, &count)
+	return count, err
+}
+
+// GetNumCardsReviewedByDay returns the number of cards reviewed on each day
+func (ac *AnkiConnect) GetNumCardsReviewedByDay() ([]ReviewDay, error) {
+	return ac.GetNumCardsReviewedByDayContext(context.Background())
+}
+
+// GetNumCardsReviewedByDayContext is like GetNumCardsReviewedByDay but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) GetNumCardsReviewedByDayContext(ctx context.Context) ([]ReviewDay, error) {
+	var days []ReviewDay
+	err := ac.invokeInto(ctx, "getNumCardsReviewedByDay", nil, &days)
+	return days, err
+}
+
+// GetCollectionStatsHTML returns the collection statistics report as HTML
+func (ac *AnkiConnect) GetCollectionStatsHTML(wholeCollection bool) (string, error) {
+	return ac.GetCollectionStatsHTMLContext(context.Background(), wholeCollection)
+}
+
+// GetCollectionStatsHTMLContext is like GetCollectionStatsHTML but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) GetCollectionStatsHTMLContext(ctx context.Context, wholeCollection bool) (string, error) {
+	var html string
+	err := ac.invokeInto(ctx, "getCollectionStatsHTML", map[string]bool{"wholeCollection": wholeCollection}, &html)
+	return html, err
+}
+
+// CardReviews returns the reviews of a deck made after startID
+func (ac *AnkiConnect) CardReviews(deck string, startID int64) ([]CardReview, error) {
+	return ac.CardReviewsContext(context.Background(), deck, startID)
+}
+
+// CardReviewsContext is like CardReviews but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) CardReviewsContext(ctx context.Context, deck string, startID int64) ([]CardReview, error) {
+	params := map[string]interface{}{
+		"deck":    deck,
+		"startID": startID,
+	}
+	var reviews []CardReview
+	err := ac.invokeInto(ctx, "cardReviews", params, &reviews)
+	return reviews, err
+}
+
+// GetLatestReviewID returns the ID of the latest review of a deck, or 0 if there is none
+func (ac *AnkiConnect) GetLatestReviewID(deck string) (int64, error) {
+	return ac.GetLatestReviewIDContext(context.Background(), deck)
+}
+
+// GetLatestReviewIDContext is like GetLatestReviewID but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) GetLatestReviewIDContext(ctx context.Context, deck string) (int64, error) {
+	var id int64
+	err := ac.invokeInto(ctx, "getLatestReviewID", map[string]string{"deck": deck}, &id)
+	return id, err
+}
@@ -0,0 +1,230 @@
+package anki
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// rawAnkiServer answers each action with a raw JSON result and records the
+// params of the last request per action
+func rawAnkiServer(t *testing.T, results map[string]string, params map[string]json.RawMessage) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Action string          `json:"action"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		result, ok := results[req.Action]
+		if !ok {
+			t.Errorf("unexpected action: %s", req.Action)
+			return
+		}
+		if params != nil {
+			params[req.Action] = req.Params
+		}
+		if _, err := w.Write([]byte(`{"result": ` + result + `, "error": null}`)); err != nil {
+			t.Fatal(err)
+		}
+	}))
+}
+
+func TestAnkiConnect_NotesInfo(t *testing.T) {
+	server := rawAnkiServer(t, map[string]string{
+		"notesInfo": `[{
+			"noteId": 1502298033753,
+			"modelName": "Basic",
+			"tags": ["tag"],
+			"fields": {"Front": {"value": "front", "order": 0}, "Back": {"value": "back", "order": 1}},
+			"cards": [1498938915662],
+			"mod": 1718377864
+		}]`,
+	}, nil)
+	defer server.Close()
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	notes, err := ac.NotesInfo([]int64{1502298033753})
+	if err != nil {
+		t.Fatalf("NotesInfo failed: %v", err)
+	}
+	if len(notes) != 1 {
+		t.Fatalf("expected 1 note, got %d", len(notes))
+	}
+	note := notes[0]
+	if note.NoteID != 1502298033753 || note.ModelName != "Basic" || note.Mod != 1718377864 {
+		t.Errorf("unexpected note %+v", note)
+	}
+	if note.Fields["Back"].Value != "back" || note.Fields["Back"].Order != 1 {
+		t.Errorf("unexpected Back field %+v", note.Fields["Back"])
+	}
+	if len(note.Cards) != 1 || note.Cards[0] != 1498938915662 {
+		t.Errorf("unexpected cards %v", note.Cards)
+	}
+}
+
+func TestAnkiConnect_CardsInfo(t *testing.T) {
+	params := make(map[string]json.RawMessage)
+	server := rawAnkiServer(t, map[string]string{
+		"cardsInfo": `[{
+			"cardId": 1498938915662, "note": 1502298033753, "deckName": "Default",
+			"modelName": "Basic", "fieldOrder": 1, "question": "q", "answer": "a",
+			"fields": {"Front": {"value": "front", "order": 0}},
+			"ord": 0, "type": 2, "queue": 2, "due": 120, "interval": 16,
+			"factor": 2500, "reps": 4, "lapses": 1, "left": 0, "mod": 1629454092
+		}]`,
+		"answerCards": `[true, false]`,
+	}, params)
+	defer server.Close()
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	cards, err := ac.CardsInfo([]int64{1498938915662})
+	if err != nil {
+		t.Fatalf("CardsInfo failed: %v", err)
+	}
+	if len(cards) != 1 {
+		t.Fatalf("expected 1 card, got %d", len(cards))
+	}
+	card := cards[0]
+	if card.CardID != 1498938915662 || card.NoteID != 1502298033753 || card.Interval != 16 || card.Factor != 2500 {
+		t.Errorf("unexpected card %+v", card)
+	}
+
+	answered, err := ac.AnswerCards([]CardAnswer{{CardID: 1, Ease: 3}, {CardID: 2, Ease: 1}})
+	if err != nil {
+		t.Fatalf("AnswerCards failed: %v", err)
+	}
+	if len(answered) != 2 || !answered[0] || answered[1] {
+		t.Errorf("unexpected answer results %v", answered)
+	}
+	if string(params["answerCards"]) != `{"answers":[{"cardId":1,"ease":3},{"cardId":2,"ease":1}]}` {
+		t.Errorf("unexpected answerCards params %s", params["answerCards"])
+	}
+}
+
+func TestAnkiConnect_ModelTemplates(t *testing.T) {
+	server := rawAnkiServer(t, map[string]string{
+		"modelTemplates": `{
+			"Recognition": {"Front": "{{Front}}", "Back": "{{Back}}"},
+			"Production": {"Front": "{{Back}}", "Back": "{{Front}}"}
+		}`,
+		"modelStyling": `{"css": ".card {}"}`,
+	}, nil)
+	defer server.Close()
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	templates, err := ac.ModelTemplates("Vocab")
+	if err != nil {
+		t.Fatalf("ModelTemplates failed: %v", err)
+	}
+	want := []CardTemplate{
+		{Name: "Recognition", Front: "{{Front}}", Back: "{{Back}}"},
+		{Name: "Production", Front: "{{Back}}", Back: "{{Front}}"},
+	}
+	if len(templates) != len(want) || templates[0] != want[0] || templates[1] != want[1] {
+		t.Errorf("expected templates %+v in card order, got %+v", want, templates)
+	}
+
+	css, err := ac.ModelStyling("Vocab")
+	if err != nil {
+		t.Fatalf("ModelStyling failed: %v", err)
+	}
+	if css != ".card {}" {
+		t.Errorf("unexpected css %q", css)
+	}
+}
+
+func TestAnkiConnect_DeckConfig(t *testing.T) {
+	params := make(map[string]json.RawMessage)
+	server := rawAnkiServer(t, map[string]string{
+		"getDeckConfig": `{
+			"id": 1, "name": "Default", "maxTaken": 60, "newSetting": "kept",
+			"new": {"perDay": 20, "delays": [1, 10], "separate": true},
+			"rev": {"perDay": 200, "maxIvl": 36500},
+			"lapse": {"leechFails": 8, "mult": 0}
+		}`,
+		"saveDeckConfig": `true`,
+	}, params)
+	defer server.Close()
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	config, err := ac.GetDeckConfig("Default")
+	if err != nil {
+		t.Fatalf("GetDeckConfig failed: %v", err)
+	}
+	if config.ID != 1 || config.New.PerDay != 20 || config.Rev.MaxIvl != 36500 || config.Lapse.LeechFails != 8 {
+		t.Errorf("unexpected config %+v", config)
+	}
+
+	config.New.PerDay = 50
+	saved, err := ac.SaveDeckConfig(config)
+	if err != nil || !saved {
+		t.Fatalf("SaveDeckConfig failed: %v, %v", saved, err)
+	}
+
+	var sent struct {
+		Config map[string]interface{} `json:"config"`
+	}
+	if err := json.Unmarshal(params["saveDeckConfig"], &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.Config["newSetting"] != "kept" {
+		t.Errorf("expected unknown settings to be kept, got %v", sent.Config)
+	}
+	newConfig := sent.Config["new"].(map[string]interface{})
+	if newConfig["perDay"] != float64(50) || newConfig["separate"] != true {
+		t.Errorf("expected changed and unknown new card settings, got %v", newConfig)
+	}
+}
+
+func TestAnkiConnect_RetrieveMediaFile(t *testing.T) {
+	results := map[string]string{"retrieveMediaFile": `"aGVsbG8="`}
+	server := rawAnkiServer(t, results, nil)
+	defer server.Close()
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	data, err := ac.RetrieveMediaFile("hello.txt")
+	if err != nil {
+		t.Fatalf("RetrieveMediaFile failed: %v", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("expected hello, got %q", data)
+	}
+
+	results["retrieveMediaFile"] = `false`
+	if _, err := ac.RetrieveMediaFile("missing.txt"); !errors.Is(err, ErrMediaNotFound) {
+		t.Errorf("expected ErrMediaNotFound, got %v", err)
+	}
+}
+
+func TestAnkiConnect_Statistics(t *testing.T) {
+	server := rawAnkiServer(t, map[string]string{
+		"getNumCardsReviewedByDay": `[["2021-02-28", 124], ["2021-02-27", 261]]`,
+		"cardReviews":              `[[1653772912146, 1651932027309, -1, 1, -60, -60, 0, 4049, 0]]`,
+	}, nil)
+	defer server.Close()
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	days, err := ac.GetNumCardsReviewedByDay()
+	if err != nil {
+		t.Fatalf("GetNumCardsReviewedByDay failed: %v", err)
+	}
+	if len(days) != 2 || days[0] != (ReviewDay{Date: "2021-02-28", Count: 124}) {
+		t.Errorf("unexpected review days %+v", days)
+	}
+
+	reviews, err := ac.CardReviews("Default", 0)
+	if err != nil {
+		t.Fatalf("CardReviews failed: %v", err)
+	}
+	want := CardReview{
+		ReviewTime: 1653772912146, CardID: 1651932027309, Usn: -1, ButtonPressed: 1,
+		NewInterval: -60, PreviousInterval: -60, ReviewDuration: 4049,
+	}
+	if len(reviews) != 1 || reviews[0] != want {
+		t.Errorf("expected review %+v, got %+v", want, reviews)
+	}
+}
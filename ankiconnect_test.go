@@ -2,9 +2,12 @@ package anki
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestAnkiConnect_Ping(t *testing.T) {
@@ -131,7 +134,7 @@ func TestAnkiConnect_AddNote(t *testing.T) {
 	defer server.Close()
 
 	ac := NewAnkiConnectWithURL(server.URL)
-	note := ankiNote{
+	note := AnkiNote{
 		DeckName:  "Test",
 		ModelName: "Basic",
 		Fields: map[string]string{
@@ -171,11 +174,60 @@ func TestAnkiConnect_ErrorHandling(t *testing.T) {
 	if err.Error() != "AnkiConnect error: deck already exists" {
 		t.Errorf("unexpected error: %v", err)
 	}
+	if !errors.Is(err, ErrDeckExists) {
+		t.Errorf("expected ErrDeckExists, got %v", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Action != "createDeck" {
+		t.Errorf("expected *APIError for createDeck, got %#v", err)
+	}
+}
+
+func TestAnkiConnect_TypedErrors(t *testing.T) {
+	tests := []struct {
+		message string
+		target  error
+	}{
+		{"cannot create note because it is a duplicate", ErrDuplicate},
+		{"deck already exists", ErrDeckExists},
+		{"model was not found: Vocab", ErrModelNotFound},
+		{"unsupported action", ErrUnsupportedAction},
+		{"Note was not found: 1 (duplicate model not found)", nil},
+	}
+	for _, tt := range tests {
+		err := error(&APIError{Action: "test", Message: tt.message})
+		for _, target := range []error{ErrDuplicate, ErrDeckExists, ErrModelNotFound, ErrUnsupportedAction} {
+			if got := errors.Is(err, target); got != (target == tt.target) {
+				t.Errorf("errors.Is(%q, %v) = %v", tt.message, target, got)
+			}
+		}
+	}
+
+	// Duplicates match the same sentinel whether Anki or the deck rejected them
+	if !errors.Is(&APIError{Message: "cannot create note because it is a duplicate"}, ErrDuplicateNote) {
+		t.Error("expected an AnkiConnect duplicate to match ErrDuplicateNote")
+	}
+	if !errors.Is(&DuplicateNoteError{NoteID: 1}, ErrDuplicate) {
+		t.Error("expected a local duplicate to match ErrDuplicate")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	ac.Retry = nil
+	err := ac.Ping()
+	var transportErr *TransportError
+	if !errors.As(err, &transportErr) || transportErr.Action != "version" {
+		t.Errorf("expected *TransportError for version, got %v", err)
+	}
 }
 
 func TestDeck_PushToAnki(t *testing.T) {
 	callCount := 0
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	var model map[string]interface{}
+	var modelNames []interface{}
+	server := httptest.NewServer(withMulti(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var req ankiRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			t.Fatal(err)
@@ -189,7 +241,14 @@ func TestDeck_PushToAnki(t *testing.T) {
 			resp = ankiResponse{Result: float64(6), Error: ""}
 		case "createDeck":
 			resp = ankiResponse{Result: float64(123), Error: ""}
+		case "modelNames":
+			resp = ankiResponse{Result: []interface{}{"Basic"}, Error: ""}
+		case "createModel":
+			model = req.Params.(map[string]interface{})
+			resp = ankiResponse{Result: nil, Error: ""}
 		case "addNote":
+			note := req.Params.(map[string]interface{})["note"].(map[string]interface{})
+			modelNames = append(modelNames, note["modelName"])
 			resp = ankiResponse{Result: float64(456), Error: ""}
 		default:
 			t.Errorf("unexpected action: %s", req.Action)
@@ -199,7 +258,7 @@ func TestDeck_PushToAnki(t *testing.T) {
 		if err := json.NewEncoder(w).Encode(resp); err != nil {
 			t.Fatal(err)
 		}
-	}))
+	})))
 	defer server.Close()
 
 	deck, err := NewDeck("Test Deck")
@@ -220,9 +279,66 @@ func TestDeck_PushToAnki(t *testing.T) {
 		t.Errorf("PushToAnki failed: %v", err)
 	}
 
-	// Should have called: version, createDeck, addNote x2
-	if callCount != 4 {
-		t.Errorf("expected 4 API calls, got %d", callCount)
+	// Should have called: version, createDeck, modelNames, createModel, addNote x2
+	if callCount != 6 {
+		t.Errorf("expected 6 API calls, got %d", callCount)
+	}
+
+	// The deck's own note type is created and used
+	if model["modelName"] != "Test Deck" {
+		t.Errorf("expected model Test Deck to be created, got %v", model["modelName"])
+	}
+	if fields := model["inOrderFields"].([]interface{}); len(fields) != 2 || fields[0] != "Front" {
+		t.Errorf("unexpected model fields %v", fields)
+	}
+	if templates := model["cardTemplates"].([]interface{}); len(templates) != 1 {
+		t.Errorf("expected 1 card template, got %v", templates)
+	}
+	for _, name := range modelNames {
+		if name != "Test Deck" {
+			t.Errorf("expected notes to use model Test Deck, got %v", name)
+		}
+	}
+}
+
+func TestDeck_PushToAnki_UnknownNoteType(t *testing.T) {
+	server := httptest.NewServer(withMulti(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ankiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		var resp ankiResponse
+		switch req.Action {
+		case "version":
+			resp = ankiResponse{Result: float64(6)}
+		case "createDeck":
+			resp = ankiResponse{Result: float64(123)}
+		default:
+			t.Errorf("unexpected action: %s", req.Action)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(err)
+		}
+	})))
+	defer server.Close()
+
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+	if err := deck.AddCard("Front 1", "Back 1"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+	if _, err := deck.db.Exec("UPDATE notes SET mid = 999"); err != nil {
+		t.Fatalf("Failed to change note type: %v", err)
+	}
+
+	// The note can't be pushed, so nothing is
+	err = deck.PushToAnki(NewAnkiConnectWithURL(server.URL))
+	if err == nil || !strings.Contains(err.Error(), "note type 999 not found") {
+		t.Errorf("expected an error for the unknown note type, got %v", err)
 	}
 }
 
@@ -269,7 +385,7 @@ func TestAnkiConnect_StoreMediaFile(t *testing.T) {
 
 func TestDeck_PushToAnkiWithMedia(t *testing.T) {
 	mediaStored := false
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	server := httptest.NewServer(withMulti(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var req ankiRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			t.Fatal(err)
@@ -279,8 +395,22 @@ func TestDeck_PushToAnkiWithMedia(t *testing.T) {
 		switch req.Action {
 		case "version":
 			resp = ankiResponse{Result: float64(6), Error: ""}
+		case "modelNames":
+			resp = ankiResponse{Result: []interface{}{"Basic", "Test Deck"}, Error: ""}
+		case "updateModelTemplates", "updateModelStyling":
+			resp = ankiResponse{Result: nil, Error: ""}
+		case "modelFieldNames":
+			resp = ankiResponse{Result: []interface{}{"Front", "Back"}, Error: ""}
+		case "modelTemplates":
+			resp = ankiResponse{Result: map[string]interface{}{
+				"Card 1": map[string]interface{}{"Front": "{{Front}}", "Back": "{{FrontSide}}<hr id=answer>{{Back}}"},
+			}, Error: ""}
+		case "modelStyling":
+			resp = ankiResponse{Result: map[string]interface{}{"css": ".card {}"}, Error: ""}
 		case "createDeck":
 			resp = ankiResponse{Result: float64(123), Error: ""}
+		case "getMediaFilesNames":
+			resp = ankiResponse{Result: []interface{}{}, Error: ""}
 		case "storeMediaFile":
 			mediaStored = true
 			resp = ankiResponse{Result: nil, Error: ""}
@@ -294,7 +424,7 @@ func TestDeck_PushToAnkiWithMedia(t *testing.T) {
 		if err := json.NewEncoder(w).Encode(resp); err != nil {
 			t.Fatal(err)
 		}
-	}))
+	})))
 	defer server.Close()
 
 	deck, err := NewDeck("Test Deck")
@@ -396,11 +526,20 @@ func TestDeck_PullFromAnki(t *testing.T) {
 			resp = ankiResponse{Result: float64(6), Error: ""}
 		case "findNotes":
 			resp = ankiResponse{Result: []interface{}{float64(123), float64(456)}, Error: ""}
+		case "modelFieldNames":
+			resp = ankiResponse{Result: []interface{}{"Front", "Back"}, Error: ""}
+		case "modelTemplates":
+			resp = ankiResponse{Result: map[string]interface{}{
+				"Card 1": map[string]interface{}{"Front": "{{Front}}", "Back": "{{FrontSide}}<hr id=answer>{{Back}}"},
+			}, Error: ""}
+		case "modelStyling":
+			resp = ankiResponse{Result: map[string]interface{}{"css": ".card {}"}, Error: ""}
 		case "notesInfo":
 			resp = ankiResponse{
 				Result: []interface{}{
 					map[string]interface{}{
-						"noteId": float64(123),
+						"noteId":    float64(123),
+						"modelName": "Test Deck",
 						"fields": map[string]interface{}{
 							"Front": map[string]interface{}{"value": "Q1"},
 							"Back":  map[string]interface{}{"value": "A1"},
@@ -408,7 +547,8 @@ func TestDeck_PullFromAnki(t *testing.T) {
 						"tags": []interface{}{"tag1"},
 					},
 					map[string]interface{}{
-						"noteId": float64(456),
+						"noteId":    float64(456),
+						"modelName": "Test Deck",
 						"fields": map[string]interface{}{
 							"Front": map[string]interface{}{"value": "Q2"},
 							"Back":  map[string]interface{}{"value": "A2"},
@@ -444,3 +584,457 @@ func TestDeck_PullFromAnki(t *testing.T) {
 	// Note: We can't easily verify the cards without exposing internal state
 	// In a real implementation, we might add a method to count cards
 }
+
+func TestDeck_SyncToAnki_DeleteMissing(t *testing.T) {
+	var deleted []interface{}
+	server := httptest.NewServer(withMulti(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ankiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+
+		var resp ankiResponse
+		switch req.Action {
+		case "version":
+			resp = ankiResponse{Result: float64(6), Error: ""}
+		case "modelNames":
+			resp = ankiResponse{Result: []interface{}{"Basic", "Test Deck"}, Error: ""}
+		case "updateModelTemplates", "updateModelStyling":
+			resp = ankiResponse{Result: nil, Error: ""}
+		case "modelFieldNames":
+			resp = ankiResponse{Result: []interface{}{"Front", "Back"}, Error: ""}
+		case "modelTemplates":
+			resp = ankiResponse{Result: map[string]interface{}{
+				"Card 1": map[string]interface{}{"Front": "{{Front}}", "Back": "{{FrontSide}}<hr id=answer>{{Back}}"},
+			}, Error: ""}
+		case "modelStyling":
+			resp = ankiResponse{Result: map[string]interface{}{"css": ".card {}"}, Error: ""}
+		case "createDeck":
+			resp = ankiResponse{Result: float64(123), Error: ""}
+		case "findNotes":
+			resp = ankiResponse{Result: []interface{}{float64(1), float64(2), float64(3)}, Error: ""}
+		case "notesInfo":
+			resp = ankiResponse{
+				Result: []interface{}{
+					map[string]interface{}{
+						"noteId":    float64(1),
+						"modelName": "Test Deck",
+						"fields": map[string]interface{}{
+							"Front": map[string]interface{}{"value": "Q1"},
+							"Back":  map[string]interface{}{"value": "A1"},
+						},
+					},
+					map[string]interface{}{
+						"noteId":    float64(2),
+						"modelName": "Test Deck",
+						"fields": map[string]interface{}{
+							"Front": map[string]interface{}{"value": "Removed"},
+							"Back":  map[string]interface{}{"value": "Gone"},
+						},
+					},
+					map[string]interface{}{
+						"noteId":    float64(3),
+						"modelName": "Test Deck",
+						"fields": map[string]interface{}{
+							"Front": map[string]interface{}{"value": "Also removed"},
+							"Back":  map[string]interface{}{"value": "Gone too"},
+						},
+					},
+				},
+				Error: "",
+			}
+		case "updateNoteFields":
+			resp = ankiResponse{Result: nil, Error: ""}
+		case "addNote":
+			resp = ankiResponse{Result: float64(456), Error: ""}
+		case "deleteNotes":
+			params := req.Params.(map[string]interface{})
+			deleted = params["notes"].([]interface{})
+			resp = ankiResponse{Result: nil, Error: ""}
+		default:
+			t.Errorf("unexpected action: %s", req.Action)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(err)
+		}
+	})))
+	defer server.Close()
+
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	if err := deck.AddCard("Q1", "A1"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+	if err := deck.AddCard("Q2", "A2"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+
+	ac := NewAnkiConnectWithURL(server.URL)
+
+	// The safety cap prevents any deletion
+	_, err = deck.SyncToAnkiWithReport(ac, &SyncOptions{UpdateExisting: true, DeleteMissing: true, MaxDeletes: 1})
+	if !errors.Is(err, ErrTooManyDeletes) {
+		t.Fatalf("expected ErrTooManyDeletes, got %v", err)
+	}
+	if deleted != nil {
+		t.Fatalf("expected no notes to be deleted, got %v", deleted)
+	}
+
+	report, err := deck.SyncToAnkiWithReport(ac, &SyncOptions{UpdateExisting: true, DeleteMissing: true})
+	if err != nil {
+		t.Fatalf("SyncToAnkiWithReport failed: %v", err)
+	}
+
+	if len(deleted) != 2 || deleted[0] != float64(2) || deleted[1] != float64(3) {
+		t.Errorf("expected notes 2 and 3 to be deleted, got %v", deleted)
+	}
+	if len(report.Deleted) != 2 {
+		t.Fatalf("expected 2 deleted notes in report, got %d", len(report.Deleted))
+	}
+	if report.Deleted[0].NoteID != 2 || report.Deleted[0].Fields["Front"] != "Removed" {
+		t.Errorf("unexpected deleted note in report: %+v", report.Deleted[0])
+	}
+}
+
+func TestDeck_PullFromAnki_Merge(t *testing.T) {
+	lastSync := time.Now().Add(-time.Hour)
+	remoteMod := float64(time.Now().Unix())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ankiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+
+		var resp ankiResponse
+		switch req.Action {
+		case "version":
+			resp = ankiResponse{Result: float64(6), Error: ""}
+		case "findNotes":
+			resp = ankiResponse{Result: []interface{}{float64(1), float64(2), float64(3), float64(4)}, Error: ""}
+		case "modelFieldNames":
+			resp = ankiResponse{Result: []interface{}{"Front", "Back"}, Error: ""}
+		case "modelTemplates":
+			resp = ankiResponse{Result: map[string]interface{}{
+				"Card 1": map[string]interface{}{"Front": "{{Front}}", "Back": "{{FrontSide}}<hr id=answer>{{Back}}"},
+			}, Error: ""}
+		case "modelStyling":
+			resp = ankiResponse{Result: map[string]interface{}{"css": ".card {}"}, Error: ""}
+		case "notesInfo":
+			note := func(id float64, front, back string, tags ...interface{}) map[string]interface{} {
+				return map[string]interface{}{
+					"noteId":    id,
+					"modelName": "Test Deck",
+					"mod":       remoteMod,
+					"fields": map[string]interface{}{
+						"Front": map[string]interface{}{"value": front},
+						"Back":  map[string]interface{}{"value": back},
+					},
+					"tags": tags,
+				}
+			}
+			resp = ankiResponse{
+				Result: []interface{}{
+					note(1, "Q1", "A1 edited in Anki", "remote"),
+					note(2, "Q2", "A2 edited in Anki"),
+					note(3, "Q3", "A3"),
+					note(4, "Same", "Same"),
+				},
+				Error: "",
+			}
+		default:
+			t.Errorf("unexpected action: %s", req.Action)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	defer server.Close()
+
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	for _, card := range [][2]string{{"Q1", "A1"}, {"Q2", "A2"}, {"Same", "Same"}, {"Local only", "Kept"}} {
+		if err := deck.AddCard(card[0], card[1]); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+	}
+
+	// Pretend the deck was synced an hour ago, then edit Q2 locally
+	if _, err := deck.db.Exec("UPDATE notes SET usn = 0"); err != nil {
+		t.Fatalf("Failed to mark notes synced: %v", err)
+	}
+	if err := deck.setLastSync(lastSync.UnixMilli()); err != nil {
+		t.Fatalf("Failed to set last sync: %v", err)
+	}
+	ids, err := deck.FindNotes("front:Q2")
+	if err != nil {
+		t.Fatalf("FindNotes failed: %v", err)
+	}
+	q2, err := deck.GetNote(ids[0])
+	if err != nil {
+		t.Fatalf("GetNote failed: %v", err)
+	}
+	q2.Fields[1] = "A2 edited locally"
+	if err := deck.UpdateNote(q2); err != nil {
+		t.Fatalf("UpdateNote failed: %v", err)
+	}
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	report, err := deck.PullFromAnkiWithOptions(ac, nil)
+	if err != nil {
+		t.Fatalf("PullFromAnkiWithOptions failed: %v", err)
+	}
+
+	if report.Added != 1 || report.Updated != 1 || report.Unchanged != 1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if len(report.Conflicts) != 1 {
+		t.Fatalf("expected 1 conflict, got %d", len(report.Conflicts))
+	}
+	conflict := report.Conflicts[0]
+	if conflict.NoteID != q2.ID || conflict.RemoteNoteID != 2 {
+		t.Errorf("unexpected conflict: %+v", conflict)
+	}
+	if conflict.Local["Back"] != "A2 edited locally" || conflict.Remote["Back"] != "A2 edited in Anki" {
+		t.Errorf("unexpected conflict values: %+v", conflict)
+	}
+
+	notes, err := deck.Notes()
+	if err != nil {
+		t.Fatalf("Notes failed: %v", err)
+	}
+	backs := make(map[string]string)
+	tags := make(map[string][]string)
+	for _, note := range notes {
+		backs[note.Fields[0]] = note.Fields[1]
+		tags[note.Fields[0]] = note.Tags
+	}
+
+	expected := map[string]string{
+		"Q1":         "A1 edited in Anki",
+		"Q2":         "A2 edited locally",
+		"Q3":         "A3",
+		"Same":       "Same",
+		"Local only": "Kept",
+	}
+	if len(backs) != len(expected) {
+		t.Errorf("expected %d notes, got %d", len(expected), len(backs))
+	}
+	for front, back := range expected {
+		if backs[front] != back {
+			t.Errorf("expected %s to have back %q, got %q", front, back, backs[front])
+		}
+	}
+	if len(tags["Q1"]) != 1 || tags["Q1"][0] != "remote" {
+		t.Errorf("expected Q1 to get the remote tags, got %v", tags["Q1"])
+	}
+}
+
+func TestDeck_SyncToAnki_MatchesNotes(t *testing.T) {
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	for _, card := range [][2]string{{"Q1", "A1 edited"}, {"Renamed", "A2"}, {"a|b", "c"}} {
+		if err := deck.AddCard(card[0], card[1]); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+	}
+	notes, err := deck.Notes()
+	if err != nil {
+		t.Fatalf("Notes failed: %v", err)
+	}
+	if err := deck.setRemoteNoteIDs([]int64{notes[1].ID}, []int64{2}); err != nil {
+		t.Fatalf("setRemoteNoteIDs failed: %v", err)
+	}
+
+	updated := make(map[float64]map[string]interface{})
+	var added []map[string]interface{}
+	server := httptest.NewServer(withMulti(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ankiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+
+		var resp ankiResponse
+		switch req.Action {
+		case "version":
+			resp = ankiResponse{Result: float64(6), Error: ""}
+		case "modelNames":
+			resp = ankiResponse{Result: []interface{}{"Basic", "Test Deck"}, Error: ""}
+		case "updateModelTemplates", "updateModelStyling":
+			resp = ankiResponse{Result: nil, Error: ""}
+		case "modelFieldNames":
+			resp = ankiResponse{Result: []interface{}{"Front", "Back"}, Error: ""}
+		case "modelTemplates":
+			resp = ankiResponse{Result: map[string]interface{}{
+				"Card 1": map[string]interface{}{"Front": "{{Front}}", "Back": "{{FrontSide}}<hr id=answer>{{Back}}"},
+			}, Error: ""}
+		case "modelStyling":
+			resp = ankiResponse{Result: map[string]interface{}{"css": ".card {}"}, Error: ""}
+		case "createDeck":
+			resp = ankiResponse{Result: float64(123), Error: ""}
+		case "findNotes":
+			resp = ankiResponse{Result: []interface{}{float64(1), float64(2), float64(3)}, Error: ""}
+		case "notesInfo":
+			resp = ankiResponse{
+				Result: []interface{}{
+					// Matched by key field although the back differs
+					map[string]interface{}{
+						"noteId":    float64(1),
+						"modelName": "Test Deck",
+						"fields": map[string]interface{}{
+							"Front": map[string]interface{}{"value": "Q1"},
+							"Back":  map[string]interface{}{"value": "A1"},
+						},
+					},
+					// Matched by the recorded note ID although the key field differs
+					map[string]interface{}{
+						"noteId":    float64(2),
+						"modelName": "Test Deck",
+						"fields": map[string]interface{}{
+							"Front": map[string]interface{}{"value": "Q2"},
+							"Back":  map[string]interface{}{"value": "A2"},
+						},
+					},
+					// Would collide with "a|b" + "c" under front|back keys
+					map[string]interface{}{
+						"noteId":    float64(3),
+						"modelName": "Test Deck",
+						"fields": map[string]interface{}{
+							"Front": map[string]interface{}{"value": "a"},
+							"Back":  map[string]interface{}{"value": "b|c"},
+						},
+					},
+				},
+				Error: "",
+			}
+		case "updateNoteFields":
+			note := req.Params.(map[string]interface{})["note"].(map[string]interface{})
+			updated[note["id"].(float64)] = note["fields"].(map[string]interface{})
+			resp = ankiResponse{Result: nil, Error: ""}
+		case "addNote":
+			added = append(added, req.Params.(map[string]interface{})["note"].(map[string]interface{}))
+			resp = ankiResponse{Result: float64(456), Error: ""}
+		default:
+			t.Errorf("unexpected action: %s", req.Action)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(err)
+		}
+	})))
+	defer server.Close()
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	if err := deck.SyncToAnki(ac, &SyncOptions{UpdateExisting: true}); err != nil {
+		t.Fatalf("SyncToAnki failed: %v", err)
+	}
+
+	if len(updated) != 2 {
+		t.Fatalf("expected 2 notes to be updated, got %v", updated)
+	}
+	if updated[1]["Back"] != "A1 edited" {
+		t.Errorf("expected note 1 to get the edited back, got %v", updated[1])
+	}
+	if updated[2]["Front"] != "Renamed" {
+		t.Errorf("expected note 2 to get the renamed front, got %v", updated[2])
+	}
+	if len(added) != 1 || added[0]["fields"].(map[string]interface{})["Front"] != "a|b" {
+		t.Errorf("expected only the a|b note to be added, got %v", added)
+	}
+
+	// An unknown key field is rejected
+	if err := deck.SyncToAnki(ac, &SyncOptions{UpdateExisting: true, KeyField: "Missing"}); err == nil {
+		t.Error("expected error for unknown key field")
+	}
+}
+
+// roundTripFunc adapts a function to http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestNewAnkiConnect_Options(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Action string `json:"action"`
+			Key    string `json:"key"`
+			Params struct {
+				Actions []struct {
+					Key string `json:"key"`
+				} `json:"actions"`
+			} `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if r.Header.Get("User-Agent") != "nightly-push/1.0" {
+			t.Errorf("unexpected user agent %q", r.Header.Get("User-Agent"))
+		}
+
+		resp := ankiResponse{Result: float64(6)}
+		if req.Key != "secret" {
+			resp = ankiResponse{Error: "valid api key must be provided"}
+		}
+		if req.Action == "multi" {
+			results := make([]ankiResponse, len(req.Params.Actions))
+			for i, action := range req.Params.Actions {
+				if action.Key != "secret" {
+					t.Errorf("expected key in multi action %d", i)
+				}
+				results[i] = ankiResponse{Result: float64(6)}
+			}
+			resp.Result = results
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	defer server.Close()
+
+	requests := 0
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		requests++
+		return http.DefaultTransport.RoundTrip(r)
+	})
+
+	ac := NewAnkiConnect(
+		WithURL(server.URL),
+		WithAPIKey("secret"),
+		WithUserAgent("nightly-push/1.0"),
+		WithHTTPClient(&http.Client{}),
+		WithTransport(transport),
+	)
+	if err := ac.Ping(); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	if _, err := ac.Multi([]MultiAction{{Action: "version"}}); err != nil {
+		t.Fatalf("Multi failed: %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("expected requests through the custom transport, got %d", requests)
+	}
+
+	ac = NewAnkiConnectWithURL(server.URL, WithUserAgent("nightly-push/1.0"))
+	if err := ac.Ping(); err == nil {
+		t.Error("expected error without API key")
+	}
+}
@@ -0,0 +1,169 @@
+package ankitest
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	anki "github.com/ezynda3/go-anki-deck"
+	"github.com/ezynda3/go-anki-deck/internal/search"
+)
+
+func (c *collection) handleFindCards(p params) (interface{}, error) {
+	var args struct {
+		Query string `json:"query"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+	return c.findCards(args.Query)
+}
+
+// findCards returns the sorted IDs of the cards matching an Anki search query
+func (c *collection) findCards(query string) ([]int64, error) {
+	cards, err := c.searchCards(query)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(cards))
+	for i, cd := range cards {
+		ids[i] = cd.id
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// searchCards returns the cards matching an Anki search query, which is
+// evaluated like the package's own SearchNotes
+func (c *collection) searchCards(query string) ([]*card, error) {
+	q, err := search.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var cards []*card
+	for _, cd := range c.cards {
+		n := cd.note
+		target := &search.Target{
+			NoteID:        n.id,
+			Fields:        n.fields,
+			FieldNames:    n.model.fields,
+			Tags:          n.tags,
+			ModelName:     n.model.name,
+			TemplateNames: n.model.templateNames(),
+			Card:          &search.Card{ID: cd.id, Ord: cd.ord, Queue: cd.queue},
+			DeckName:      c.decks[cd.deck],
+			Now:           now,
+		}
+		if q.Match(target) {
+			cards = append(cards, cd)
+		}
+	}
+	return cards, nil
+}
+
+// cardParams are the arguments of actions on cards
+type cardParams struct {
+	Cards []int64 `json:"cards"`
+}
+
+// handleCardsInfo returns an empty object for unknown cards
+func (c *collection) handleCardsInfo(p params) (interface{}, error) {
+	var args cardParams
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+
+	infos := make([]interface{}, len(args.Cards))
+	for i, id := range args.Cards {
+		cd, ok := c.cards[id]
+		if !ok {
+			infos[i] = struct{}{}
+			continue
+		}
+		n := cd.note
+		question, answer := n.model.textModel().RenderCard(n.fields, cd.ord)
+		infos[i] = anki.CardInfo{
+			CardID:    cd.id,
+			NoteID:    n.id,
+			DeckName:  c.decks[cd.deck],
+			ModelName: n.model.name,
+			Fields:    n.fieldInfo(),
+			Question:  question,
+			Answer:    answer,
+			CSS:       n.model.css,
+			Ord:       cd.ord,
+			Queue:     cd.queue,
+			Due:       cd.id,
+			Factor:    cd.factor,
+			Mod:       cd.mod,
+		}
+	}
+	return infos, nil
+}
+
+// setQueue moves cards to a queue and reports whether any card changed
+func (c *collection) setQueue(p params, queue int) (interface{}, error) {
+	var args cardParams
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+
+	changed := false
+	for _, id := range args.Cards {
+		if cd, ok := c.cards[id]; ok && cd.queue != queue {
+			cd.queue = queue
+			cd.mod = time.Now().Unix()
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+func (c *collection) handleSuspend(p params) (interface{}, error) {
+	return c.setQueue(p, -1)
+}
+
+func (c *collection) handleUnsuspend(p params) (interface{}, error) {
+	return c.setQueue(p, 0)
+}
+
+func (c *collection) handleGetEaseFactors(p params) (interface{}, error) {
+	var args cardParams
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+
+	factors := make([]int, len(args.Cards))
+	for i, id := range args.Cards {
+		cd, ok := c.cards[id]
+		if !ok {
+			return nil, fmt.Errorf("Card was not found: %d", id)
+		}
+		factors[i] = cd.factor
+	}
+	return factors, nil
+}
+
+func (c *collection) handleSetEaseFactors(p params) (interface{}, error) {
+	var args struct {
+		Cards       []int64 `json:"cards"`
+		EaseFactors []int   `json:"easeFactors"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+
+	results := make([]bool, len(args.Cards))
+	for i, id := range args.Cards {
+		cd, ok := c.cards[id]
+		if !ok || i >= len(args.EaseFactors) {
+			continue
+		}
+		cd.factor = args.EaseFactors[i]
+		results[i] = true
+	}
+	return results, nil
+}
@@ -0,0 +1,541 @@
+package ankitest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	anki "github.com/ezynda3/go-anki-deck"
+	"github.com/ezynda3/go-anki-deck/internal/text"
+)
+
+// Errors with the messages AnkiConnect uses
+var (
+	errUnsupportedAction = errors.New("unsupported action")
+	errEmpty             = errors.New("cannot create note because it is empty")
+	errDuplicate         = errors.New("cannot create note because it is a duplicate")
+)
+
+const defaultCSS = `.card {
+    font-family: arial;
+    font-size: 20px;
+    text-align: center;
+    color: black;
+    background-color: white;
+}
+`
+
+// params are the undecoded parameters of an action
+type params json.RawMessage
+
+func (p params) decode(v interface{}) error {
+	if len(p) == 0 || string(p) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(p, v); err != nil {
+		return fmt.Errorf("invalid params: %w", err)
+	}
+	return nil
+}
+
+type model struct {
+	id        int64
+	name      string
+	fields    []string
+	templates []anki.CardTemplate
+	css       string
+	cloze     bool
+}
+
+// noteModel returns the note type in AnkiConnect's format
+func (m *model) noteModel() anki.NoteModel {
+	return anki.NoteModel{
+		Name:      m.name,
+		Fields:    append([]string(nil), m.fields...),
+		CSS:       m.css,
+		IsCloze:   m.cloze,
+		Templates: append([]anki.CardTemplate(nil), m.templates...),
+	}
+}
+
+// textModel returns the fields and templates that decide the cards of the
+// note type's notes
+func (m *model) textModel() text.Model {
+	templates := make([]text.Template, len(m.templates))
+	for i, t := range m.templates {
+		templates[i] = text.Template{Front: t.Front, Back: t.Back}
+	}
+	return text.Model{Fields: m.fields, Templates: templates, IsCloze: m.cloze}
+}
+
+// templateNames returns the names of the note type's templates by ord
+func (m *model) templateNames() []string {
+	names := make([]string, len(m.templates))
+	for i, t := range m.templates {
+		names[i] = t.Name
+	}
+	return names
+}
+
+type note struct {
+	id     int64
+	model  *model
+	fields []string
+	tags   []string
+	mod    int64 // Seconds since the epoch
+	cards  []int64
+}
+
+type card struct {
+	id     int64
+	note   *note
+	deck   int64
+	ord    int
+	queue  int // 0 new, -1 suspended
+	factor int
+	mod    int64
+}
+
+// collection is the state of an emulated Anki collection
+type collection struct {
+	lastID int64
+	decks  map[int64]string
+	models []*model
+	notes  map[int64]*note
+	cards  map[int64]*card
+	media  map[string][]byte
+}
+
+// newCollection creates a collection with the Default deck and the stock
+// note types of Anki
+func newCollection() *collection {
+	c := &collection{
+		lastID: time.Now().UnixMilli(),
+		decks:  map[int64]string{1: "Default"},
+		notes:  make(map[int64]*note),
+		cards:  make(map[int64]*card),
+		media:  make(map[string][]byte),
+	}
+
+	basicBack := "{{FrontSide}}\n\n<hr id=answer>\n\n{{Back}}"
+	c.models = []*model{
+		{
+			id:     c.newID(),
+			name:   "Basic",
+			fields: []string{"Front", "Back"},
+			templates: []anki.CardTemplate{
+				{Name: "Card 1", Front: "{{Front}}", Back: basicBack},
+			},
+			css: defaultCSS,
+		},
+		{
+			id:     c.newID(),
+			name:   "Basic (and reversed card)",
+			fields: []string{"Front", "Back"},
+			templates: []anki.CardTemplate{
+				{Name: "Card 1", Front: "{{Front}}", Back: basicBack},
+				{Name: "Card 2", Front: "{{Back}}", Back: "{{FrontSide}}\n\n<hr id=answer>\n\n{{Front}}"},
+			},
+			css: defaultCSS,
+		},
+		{
+			id:     c.newID(),
+			name:   "Cloze",
+			fields: []string{"Text", "Back Extra"},
+			templates: []anki.CardTemplate{
+				{Name: "Cloze", Front: "{{cloze:Text}}", Back: "{{cloze:Text}}<br>\n{{Back Extra}}"},
+			},
+			css:   defaultCSS,
+			cloze: true,
+		},
+	}
+	return c
+}
+
+// newID returns a new ID, increasing like Anki's millisecond timestamps
+func (c *collection) newID() int64 {
+	c.lastID++
+	return c.lastID
+}
+
+// handlers run the actions on the collection, apart from those answered by
+// the server itself
+var handlers = map[string]func(*collection, params) (interface{}, error){
+	"deckNames":            (*collection).handleDeckNames,
+	"deckNamesAndIds":      (*collection).handleDeckNamesAndIDs,
+	"getDecks":             (*collection).handleGetDecks,
+	"createDeck":           (*collection).handleCreateDeck,
+	"changeDeck":           (*collection).handleChangeDeck,
+	"deleteDecks":          (*collection).handleDeleteDecks,
+	"modelNames":           (*collection).handleModelNames,
+	"modelNamesAndIds":     (*collection).handleModelNamesAndIDs,
+	"modelFieldNames":      (*collection).handleModelFieldNames,
+	"modelTemplates":       (*collection).handleModelTemplates,
+	"modelStyling":         (*collection).handleModelStyling,
+	"createModel":          (*collection).handleCreateModel,
+	"updateModelTemplates": (*collection).handleUpdateModelTemplates,
+	"updateModelStyling":   (*collection).handleUpdateModelStyling,
+	"addNote":              (*collection).handleAddNote,
+	"addNotes":             (*collection).handleAddNotes,
+	"canAddNotes":          (*collection).handleCanAddNotes,
+	"updateNoteFields":     (*collection).handleUpdateNoteFields,
+	"updateNote":           (*collection).handleUpdateNote,
+	"notesInfo":            (*collection).handleNotesInfo,
+	"findNotes":            (*collection).handleFindNotes,
+	"deleteNotes":          (*collection).handleDeleteNotes,
+	"addTags":              (*collection).handleAddTags,
+	"removeTags":           (*collection).handleRemoveTags,
+	"getTags":              (*collection).handleGetTags,
+	"findCards":            (*collection).handleFindCards,
+	"cardsInfo":            (*collection).handleCardsInfo,
+	"suspend":              (*collection).handleSuspend,
+	"unsuspend":            (*collection).handleUnsuspend,
+	"getEaseFactors":       (*collection).handleGetEaseFactors,
+	"setEaseFactors":       (*collection).handleSetEaseFactors,
+	"storeMediaFile":       (*collection).handleStoreMediaFile,
+	"retrieveMediaFile":    (*collection).handleRetrieveMediaFile,
+	"getMediaFilesNames":   (*collection).handleGetMediaFilesNames,
+	"deleteMediaFile":      (*collection).handleDeleteMediaFile,
+	"sync":                 (*collection).handleSync,
+}
+
+// Decks
+
+// deckID looks up a deck by name, ignoring case like Anki
+func (c *collection) deckID(name string) (int64, bool) {
+	for id, deckName := range c.decks {
+		if strings.EqualFold(deckName, name) {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// createDeck returns the ID of a deck, creating it and its parents if needed
+func (c *collection) createDeck(name string) int64 {
+	var id int64
+	parts := strings.Split(name, "::")
+	for i := range parts {
+		prefix := strings.Join(parts[:i+1], "::")
+		var ok bool
+		if id, ok = c.deckID(prefix); !ok {
+			id = c.newID()
+			c.decks[id] = prefix
+		}
+	}
+	return id
+}
+
+func (c *collection) deckNames() []string {
+	names := make([]string, 0, len(c.decks))
+	for _, name := range c.decks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *collection) handleDeckNames(params) (interface{}, error) {
+	return c.deckNames(), nil
+}
+
+func (c *collection) handleDeckNamesAndIDs(params) (interface{}, error) {
+	ids := make(map[string]int64, len(c.decks))
+	for id, name := range c.decks {
+		ids[name] = id
+	}
+	return ids, nil
+}
+
+func (c *collection) handleGetDecks(p params) (interface{}, error) {
+	var args struct {
+		Cards []int64 `json:"cards"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+
+	decks := make(map[string][]int64)
+	for _, id := range args.Cards {
+		if cd, ok := c.cards[id]; ok {
+			name := c.decks[cd.deck]
+			decks[name] = append(decks[name], id)
+		}
+	}
+	return decks, nil
+}
+
+func (c *collection) handleCreateDeck(p params) (interface{}, error) {
+	var args struct {
+		Deck string `json:"deck"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(args.Deck) == "" {
+		return nil, errors.New("deck name must not be empty")
+	}
+	return c.createDeck(args.Deck), nil
+}
+
+func (c *collection) handleChangeDeck(p params) (interface{}, error) {
+	var args struct {
+		Cards []int64 `json:"cards"`
+		Deck  string  `json:"deck"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+
+	deckID := c.createDeck(args.Deck)
+	for _, id := range args.Cards {
+		if cd, ok := c.cards[id]; ok {
+			cd.deck = deckID
+			cd.mod = time.Now().Unix()
+		}
+	}
+	return nil, nil
+}
+
+func (c *collection) handleDeleteDecks(p params) (interface{}, error) {
+	var args struct {
+		Decks    []string `json:"decks"`
+		CardsToo bool     `json:"cardsToo"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+	if !args.CardsToo {
+		return nil, errors.New("since Anki 2.1.28 it's not possible to delete decks without deleting cards as well")
+	}
+
+	deleted := make(map[int64]bool)
+	for _, name := range args.Decks {
+		for id, deckName := range c.decks {
+			if strings.EqualFold(deckName, name) || strings.HasPrefix(strings.ToLower(deckName), strings.ToLower(name)+"::") {
+				deleted[id] = true
+			}
+		}
+	}
+	for _, cd := range c.cards {
+		if deleted[cd.deck] {
+			c.deleteCard(cd)
+		}
+	}
+	for id := range deleted {
+		// The default deck can't be deleted
+		if id != 1 {
+			delete(c.decks, id)
+		}
+	}
+	return nil, nil
+}
+
+// deleteCard removes a card and its note once the note has no cards left
+func (c *collection) deleteCard(cd *card) {
+	delete(c.cards, cd.id)
+	n := cd.note
+	for i, id := range n.cards {
+		if id == cd.id {
+			n.cards = append(n.cards[:i], n.cards[i+1:]...)
+			break
+		}
+	}
+	if len(n.cards) == 0 {
+		delete(c.notes, n.id)
+	}
+}
+
+// Note types
+
+// model looks up a note type by name
+func (c *collection) model(name string) (*model, bool) {
+	for _, m := range c.models {
+		if m.name == name {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+func (c *collection) modelByParam(p params) (*model, error) {
+	var args struct {
+		ModelName string `json:"modelName"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+	m, ok := c.model(args.ModelName)
+	if !ok {
+		return nil, fmt.Errorf("model was not found: %s", args.ModelName)
+	}
+	return m, nil
+}
+
+func (c *collection) createModel(spec anki.NoteModel) (*model, error) {
+	switch {
+	case spec.Name == "":
+		return nil, errors.New("must provide a model name")
+	case len(spec.Fields) == 0:
+		return nil, errors.New("must provide at least one field for inOrderFields")
+	case len(spec.Templates) == 0:
+		return nil, errors.New("must provide at least one card for cardTemplates")
+	}
+	if _, ok := c.model(spec.Name); ok {
+		return nil, errors.New("Model name already exists")
+	}
+
+	m := &model{
+		id:        c.newID(),
+		name:      spec.Name,
+		fields:    append([]string(nil), spec.Fields...),
+		templates: append([]anki.CardTemplate(nil), spec.Templates...),
+		css:       spec.CSS,
+		cloze:     spec.IsCloze,
+	}
+	if m.css == "" {
+		m.css = defaultCSS
+	}
+	for i := range m.templates {
+		if m.templates[i].Name == "" {
+			m.templates[i].Name = fmt.Sprintf("Card %d", i+1)
+		}
+	}
+	c.models = append(c.models, m)
+	return m, nil
+}
+
+func (c *collection) handleModelNames(params) (interface{}, error) {
+	names := make([]string, len(c.models))
+	for i, m := range c.models {
+		names[i] = m.name
+	}
+	return names, nil
+}
+
+func (c *collection) handleModelNamesAndIDs(params) (interface{}, error) {
+	ids := make(map[string]int64, len(c.models))
+	for _, m := range c.models {
+		ids[m.name] = m.id
+	}
+	return ids, nil
+}
+
+func (c *collection) handleModelFieldNames(p params) (interface{}, error) {
+	m, err := c.modelByParam(p)
+	if err != nil {
+		return nil, err
+	}
+	return m.fields, nil
+}
+
+// handleModelTemplates returns the templates keyed by name in card order
+func (c *collection) handleModelTemplates(p params) (interface{}, error) {
+	m, err := c.modelByParam(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString("{")
+	for i, t := range m.templates {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		name, _ := json.Marshal(t.Name)
+		sides, _ := json.Marshal(map[string]string{"Front": t.Front, "Back": t.Back})
+		b.Write(name)
+		b.WriteString(":")
+		b.Write(sides)
+	}
+	b.WriteString("}")
+	return json.RawMessage(b.String()), nil
+}
+
+func (c *collection) handleModelStyling(p params) (interface{}, error) {
+	m, err := c.modelByParam(p)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"css": m.css}, nil
+}
+
+func (c *collection) handleCreateModel(p params) (interface{}, error) {
+	var args struct {
+		ModelName     string              `json:"modelName"`
+		InOrderFields []string            `json:"inOrderFields"`
+		CSS           string              `json:"css"`
+		IsCloze       bool                `json:"isCloze"`
+		CardTemplates []anki.CardTemplate `json:"cardTemplates"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+
+	m, err := c.createModel(anki.NoteModel{
+		Name:      args.ModelName,
+		Fields:    args.InOrderFields,
+		CSS:       args.CSS,
+		IsCloze:   args.IsCloze,
+		Templates: args.CardTemplates,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"id": m.id, "name": m.name}, nil
+}
+
+func (c *collection) handleUpdateModelTemplates(p params) (interface{}, error) {
+	var args struct {
+		Model struct {
+			Name      string `json:"name"`
+			Templates map[string]struct {
+				Front *string `json:"Front"`
+				Back  *string `json:"Back"`
+			} `json:"templates"`
+		} `json:"model"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+
+	m, ok := c.model(args.Model.Name)
+	if !ok {
+		return nil, fmt.Errorf("model was not found: %s", args.Model.Name)
+	}
+	for i, t := range m.templates {
+		update, ok := args.Model.Templates[t.Name]
+		if !ok {
+			continue
+		}
+		if update.Front != nil {
+			m.templates[i].Front = *update.Front
+		}
+		if update.Back != nil {
+			m.templates[i].Back = *update.Back
+		}
+	}
+	return nil, nil
+}
+
+func (c *collection) handleUpdateModelStyling(p params) (interface{}, error) {
+	var args struct {
+		Model struct {
+			Name string `json:"name"`
+			CSS  string `json:"css"`
+		} `json:"model"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+
+	m, ok := c.model(args.Model.Name)
+	if !ok {
+		return nil, fmt.Errorf("model was not found: %s", args.Model.Name)
+	}
+	m.css = args.Model.CSS
+	return nil, nil
+}
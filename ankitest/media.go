@@ -0,0 +1,119 @@
+package ankitest
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// handleStoreMediaFile stores a file sent as data, read from a path or
+// downloaded from a URL
+func (c *collection) handleStoreMediaFile(p params) (interface{}, error) {
+	var args struct {
+		Filename       string `json:"filename"`
+		Data           string `json:"data"`
+		Path           string `json:"path"`
+		URL            string `json:"url"`
+		DeleteExisting *bool  `json:"deleteExisting"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	var err error
+	switch {
+	case args.Data != "":
+		data, err = base64.StdEncoding.DecodeString(args.Data)
+	case args.Path != "":
+		data, err = os.ReadFile(args.Path)
+	case args.URL != "":
+		data, err = download(args.URL)
+	default:
+		return nil, errors.New(`you must provide a "data", "path", or "url" field`)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	filename := args.Filename
+	if _, exists := c.media[filename]; exists && args.DeleteExisting != nil && !*args.DeleteExisting {
+		ext := path.Ext(filename)
+		filename = fmt.Sprintf("%s-%x%s", strings.TrimSuffix(filename, ext), c.newID(), ext)
+	}
+	c.media[filename] = data
+	return filename, nil
+}
+
+func download(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// handleRetrieveMediaFile returns false for missing files
+func (c *collection) handleRetrieveMediaFile(p params) (interface{}, error) {
+	var args struct {
+		Filename string `json:"filename"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+
+	data, ok := c.media[args.Filename]
+	if !ok {
+		return false, nil
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+func (c *collection) handleGetMediaFilesNames(p params) (interface{}, error) {
+	var args struct {
+		Pattern string `json:"pattern"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+	if args.Pattern == "" {
+		args.Pattern = "*"
+	}
+
+	names := []string{}
+	for name := range c.media {
+		if ok, err := path.Match(args.Pattern, name); err != nil {
+			return nil, err
+		} else if ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (c *collection) handleDeleteMediaFile(p params) (interface{}, error) {
+	var args struct {
+		Filename string `json:"filename"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+	delete(c.media, args.Filename)
+	return nil, nil
+}
+
+// handleSync does nothing as there is no AnkiWeb to sync with
+func (c *collection) handleSync(params) (interface{}, error) {
+	return nil, nil
+}
@@ -0,0 +1,417 @@
+package ankitest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	anki "github.com/ezynda3/go-anki-deck"
+	"github.com/ezynda3/go-anki-deck/internal/text"
+)
+
+// noteParams is a note as passed to addNote
+type noteParams struct {
+	DeckName  string            `json:"deckName"`
+	ModelName string            `json:"modelName"`
+	Fields    map[string]string `json:"fields"`
+	Tags      []string          `json:"tags"`
+	Options   struct {
+		AllowDuplicate        bool   `json:"allowDuplicate"`
+		DuplicateScope        string `json:"duplicateScope"`
+		DuplicateScopeOptions struct {
+			DeckName       string `json:"deckName"`
+			CheckChildren  bool   `json:"checkChildren"`
+			CheckAllModels bool   `json:"checkAllModels"`
+		} `json:"duplicateScopeOptions"`
+	} `json:"options"`
+}
+
+func (c *collection) note(id int64) (*note, error) {
+	n, ok := c.notes[id]
+	if !ok {
+		return nil, fmt.Errorf("Note was not found: %d", id)
+	}
+	return n, nil
+}
+
+// prepareNote checks a new note like Anki and returns its deck, note type
+// and field values
+func (c *collection) prepareNote(p noteParams) (int64, *model, []string, error) {
+	m, ok := c.model(p.ModelName)
+	if !ok {
+		return 0, nil, nil, fmt.Errorf("model was not found: %s", p.ModelName)
+	}
+	deckID, ok := c.deckID(p.DeckName)
+	if !ok {
+		return 0, nil, nil, fmt.Errorf("deck was not found: %s", p.DeckName)
+	}
+
+	values := make([]string, len(m.fields))
+	for i, name := range m.fields {
+		values[i] = p.Fields[name]
+	}
+	if strings.TrimSpace(text.StripHTMLMedia(values[0])) == "" || len(m.textModel().CardOrds(values)) == 0 {
+		return 0, nil, nil, errEmpty
+	}
+	if !p.Options.AllowDuplicate && c.isDuplicate(deckID, m, values[0], p) {
+		return 0, nil, nil, errDuplicate
+	}
+	return deckID, m, values, nil
+}
+
+// isDuplicate reports whether another note has the same first field, within
+// the scope set by the note's options
+func (c *collection) isDuplicate(deckID int64, m *model, first string, p noteParams) bool {
+	scope := p.Options.DuplicateScopeOptions
+	if scope.DeckName != "" {
+		id, ok := c.deckID(scope.DeckName)
+		if !ok {
+			return false
+		}
+		deckID = id
+	}
+	deckName := strings.ToLower(c.decks[deckID])
+
+	key := strings.TrimSpace(text.StripHTMLMedia(first))
+	for _, n := range c.notes {
+		if n.model != m && !scope.CheckAllModels {
+			continue
+		}
+		if strings.TrimSpace(text.StripHTMLMedia(n.fields[0])) != key {
+			continue
+		}
+		if p.Options.DuplicateScope != "deck" {
+			return true
+		}
+		for _, id := range n.cards {
+			name := strings.ToLower(c.decks[c.cards[id].deck])
+			if name == deckName || (scope.CheckChildren && strings.HasPrefix(name, deckName+"::")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addNote adds a note and generates its cards
+func (c *collection) addNote(p noteParams) (int64, error) {
+	deckID, m, values, err := c.prepareNote(p)
+	if err != nil {
+		return 0, err
+	}
+
+	n := &note{
+		id:     c.newID(),
+		model:  m,
+		fields: values,
+		tags:   addTags(nil, p.Tags),
+		mod:    time.Now().Unix(),
+	}
+	c.notes[n.id] = n
+	c.generateCards(n, deckID)
+	return n.id, nil
+}
+
+// generateCards adds the cards the note's fields call for that it doesn't
+// have yet
+func (c *collection) generateCards(n *note, deckID int64) {
+	have := make(map[int]bool)
+	for _, id := range n.cards {
+		have[c.cards[id].ord] = true
+	}
+	for _, ord := range n.model.textModel().CardOrds(n.fields) {
+		if have[ord] {
+			continue
+		}
+		cd := &card{
+			id:    c.newID(),
+			note:  n,
+			deck:  deckID,
+			ord:   ord,
+			queue: 0,
+			mod:   time.Now().Unix(),
+		}
+		c.cards[cd.id] = cd
+		n.cards = append(n.cards, cd.id)
+	}
+}
+
+// setFields changes the named fields of a note, ignoring unknown names
+func (c *collection) setFields(n *note, fields map[string]string) {
+	for name, value := range fields {
+		if i := indexOf(n.model.fields, name); i >= 0 {
+			n.fields[i] = value
+		}
+	}
+	n.mod = time.Now().Unix()
+	if len(n.cards) > 0 {
+		c.generateCards(n, c.cards[n.cards[0]].deck)
+	}
+}
+
+func (c *collection) handleAddNote(p params) (interface{}, error) {
+	var args struct {
+		Note noteParams `json:"note"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+	return c.addNote(args.Note)
+}
+
+// handleAddNotes returns null for the notes that could not be added
+func (c *collection) handleAddNotes(p params) (interface{}, error) {
+	var args struct {
+		Notes []noteParams `json:"notes"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+
+	ids := make([]*int64, len(args.Notes))
+	for i, n := range args.Notes {
+		if id, err := c.addNote(n); err == nil {
+			ids[i] = &id
+		}
+	}
+	return ids, nil
+}
+
+func (c *collection) handleCanAddNotes(p params) (interface{}, error) {
+	var args struct {
+		Notes []noteParams `json:"notes"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+
+	results := make([]bool, len(args.Notes))
+	for i, n := range args.Notes {
+		_, _, _, err := c.prepareNote(n)
+		results[i] = err == nil
+	}
+	return results, nil
+}
+
+func (c *collection) handleUpdateNoteFields(p params) (interface{}, error) {
+	var args struct {
+		Note struct {
+			ID     int64             `json:"id"`
+			Fields map[string]string `json:"fields"`
+		} `json:"note"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+
+	n, err := c.note(args.Note.ID)
+	if err != nil {
+		return nil, err
+	}
+	c.setFields(n, args.Note.Fields)
+	return nil, nil
+}
+
+func (c *collection) handleUpdateNote(p params) (interface{}, error) {
+	var args struct {
+		Note struct {
+			ID     int64             `json:"id"`
+			Fields map[string]string `json:"fields"`
+			Tags   *[]string         `json:"tags"`
+		} `json:"note"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+	if args.Note.Fields == nil && args.Note.Tags == nil {
+		return nil, errors.New(`must provide a "fields" or "tags" property`)
+	}
+
+	n, err := c.note(args.Note.ID)
+	if err != nil {
+		return nil, err
+	}
+	if args.Note.Fields != nil {
+		c.setFields(n, args.Note.Fields)
+	}
+	if args.Note.Tags != nil {
+		n.tags = addTags(nil, *args.Note.Tags)
+		n.mod = time.Now().Unix()
+	}
+	return nil, nil
+}
+
+// noteInfo describes a note like notesInfo
+func (c *collection) noteInfo(n *note) anki.NoteInfo {
+	return anki.NoteInfo{
+		NoteID:    n.id,
+		ModelName: n.model.name,
+		Tags:      append([]string{}, n.tags...),
+		Fields:    n.fieldInfo(),
+		Cards:     append([]int64{}, n.cards...),
+		Mod:       n.mod,
+	}
+}
+
+func (n *note) fieldInfo() map[string]anki.NoteField {
+	fields := make(map[string]anki.NoteField, len(n.fields))
+	for i, name := range n.model.fields {
+		fields[name] = anki.NoteField{Value: n.fields[i], Order: i}
+	}
+	return fields
+}
+
+// handleNotesInfo returns an empty object for unknown notes
+func (c *collection) handleNotesInfo(p params) (interface{}, error) {
+	var args struct {
+		Notes []int64 `json:"notes"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+
+	infos := make([]interface{}, len(args.Notes))
+	for i, id := range args.Notes {
+		if n, ok := c.notes[id]; ok {
+			infos[i] = c.noteInfo(n)
+		} else {
+			infos[i] = struct{}{}
+		}
+	}
+	return infos, nil
+}
+
+func (c *collection) handleFindNotes(p params) (interface{}, error) {
+	var args struct {
+		Query string `json:"query"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+	return c.findNotes(args.Query)
+}
+
+// findNotes returns the sorted IDs of the notes with a card matching an Anki
+// search query
+func (c *collection) findNotes(query string) ([]int64, error) {
+	cards, err := c.searchCards(query)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int64]bool)
+	ids := []int64{}
+	for _, cd := range cards {
+		if !seen[cd.note.id] {
+			seen[cd.note.id] = true
+			ids = append(ids, cd.note.id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (c *collection) handleDeleteNotes(p params) (interface{}, error) {
+	var args struct {
+		Notes []int64 `json:"notes"`
+	}
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+
+	for _, id := range args.Notes {
+		n, ok := c.notes[id]
+		if !ok {
+			continue
+		}
+		for _, cardID := range n.cards {
+			delete(c.cards, cardID)
+		}
+		delete(c.notes, id)
+	}
+	return nil, nil
+}
+
+// tagParams are the arguments of addTags and removeTags
+type tagParams struct {
+	Notes []int64 `json:"notes"`
+	Tags  string  `json:"tags"`
+}
+
+func (c *collection) handleAddTags(p params) (interface{}, error) {
+	var args tagParams
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+	for _, id := range args.Notes {
+		if n, ok := c.notes[id]; ok {
+			n.tags = addTags(n.tags, strings.Fields(args.Tags))
+			n.mod = time.Now().Unix()
+		}
+	}
+	return nil, nil
+}
+
+func (c *collection) handleRemoveTags(p params) (interface{}, error) {
+	var args tagParams
+	if err := p.decode(&args); err != nil {
+		return nil, err
+	}
+	for _, id := range args.Notes {
+		if n, ok := c.notes[id]; ok {
+			n.tags = removeTags(n.tags, strings.Fields(args.Tags))
+			n.mod = time.Now().Unix()
+		}
+	}
+	return nil, nil
+}
+
+func (c *collection) handleGetTags(params) (interface{}, error) {
+	var tags []string
+	for _, n := range c.notes {
+		tags = addTags(tags, n.tags)
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	return tags, nil
+}
+
+// addTags adds tags that are not there yet, ignoring case like Anki, and
+// returns them sorted. Tags are separated by spaces.
+func addTags(tags, add []string) []string {
+	result := append([]string(nil), tags...)
+	for _, tag := range add {
+		for _, t := range strings.Fields(tag) {
+			if indexFold(result, t) < 0 {
+				result = append(result, t)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return strings.ToLower(result[i]) < strings.ToLower(result[j])
+	})
+	return result
+}
+
+// removeTags removes tags ignoring case
+func removeTags(tags, remove []string) []string {
+	var result []string
+	for _, tag := range tags {
+		if indexFold(remove, tag) < 0 {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+func indexFold(values []string, value string) int {
+	for i, v := range values {
+		if strings.EqualFold(v, value) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,333 @@
+// Package ankitest provides an in-memory AnkiConnect emulator for tests.
+//
+// A Server keeps a collection of decks, note types, notes, cards and media
+// files and answers AnkiConnect requests against it. Results and error
+// messages are modelled on the add-on's for the common cases, not every
+// detail of Anki, so code using AnkiConnect can be tested end-to-end without
+// Anki:
+//
+//	server := ankitest.NewServer()
+//	defer server.Close()
+//
+//	err := deck.PushToAnki(server.Client())
+//	ids, err := server.FindNotes(`deck:"My Deck"`)
+package ankitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+
+	anki "github.com/ezynda3/go-anki-deck"
+)
+
+// Server is an in-memory AnkiConnect server for tests
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	version  int
+	apiKey   string
+	disabled map[string]bool
+	col      *collection
+	actions  []string
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithVersion sets the API version the server reports, 6 by default
+func WithVersion(version int) Option {
+	return func(s *Server) {
+		s.version = version
+	}
+}
+
+// WithAPIKey makes the server reject requests without the key
+func WithAPIKey(key string) Option {
+	return func(s *Server) {
+		s.apiKey = key
+	}
+}
+
+// WithoutActions makes the server answer the actions as unsupported, like
+// older versions of AnkiConnect
+func WithoutActions(actions ...string) Option {
+	return func(s *Server) {
+		for _, action := range actions {
+			s.disabled[action] = true
+		}
+	}
+}
+
+// NewServer starts a server with an empty collection holding the Default
+// deck and the Basic, Basic (and reversed card) and Cloze note types.
+// The caller should call Close when finished.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		version:  6,
+		disabled: make(map[string]bool),
+		col:      newCollection(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Client returns an AnkiConnect client for the server. Retries are disabled
+// so failures show up immediately.
+func (s *Server) Client(opts ...anki.Option) *anki.AnkiConnect {
+	ac := anki.NewAnkiConnectWithURL(s.URL, opts...)
+	ac.Retry = nil
+	return ac
+}
+
+// request is a request to the AnkiConnect API
+type request struct {
+	Action  string          `json:"action"`
+	Version int             `json:"version"`
+	Params  json.RawMessage `json:"params"`
+	Key     string          `json:"key"`
+}
+
+// response is the answer to a request in the format of API version 5 and up
+type response struct {
+	Result interface{} `json:"result"`
+	Error  *string     `json:"error"`
+}
+
+// ServeHTTP answers a single AnkiConnect request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req request
+	var resp response
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		resp = errorResponse(fmt.Errorf("invalid request: %w", err))
+	} else {
+		s.mu.Lock()
+		resp = s.call(req)
+		s.mu.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// call runs an action against the collection
+func (s *Server) call(req request) response {
+	s.actions = append(s.actions, req.Action)
+	if s.apiKey != "" && req.Key != s.apiKey {
+		return errorResponse(fmt.Errorf("valid api key must be provided"))
+	}
+
+	var result interface{}
+	var err error
+	switch {
+	case s.disabled[req.Action]:
+		err = errUnsupportedAction
+	case req.Action == "version":
+		result = s.version
+	case req.Action == "multi":
+		result, err = s.multi(params(req.Params))
+	case req.Action == "apiReflect":
+		result, err = s.apiReflect(params(req.Params))
+	default:
+		handler, ok := handlers[req.Action]
+		if !ok {
+			err = errUnsupportedAction
+			break
+		}
+		result, err = handler(s.col, params(req.Params))
+	}
+	if err != nil {
+		return errorResponse(err)
+	}
+	return response{Result: result}
+}
+
+func errorResponse(err error) response {
+	msg := err.Error()
+	return response{Error: &msg}
+}
+
+// multi runs several requests and returns their responses
+func (s *Server) multi(raw params) (interface{}, error) {
+	var p struct {
+		Actions []request `json:"actions"`
+	}
+	if err := raw.decode(&p); err != nil {
+		return nil, err
+	}
+
+	responses := make([]response, len(p.Actions))
+	for i, req := range p.Actions {
+		if req.Key == "" {
+			req.Key = s.apiKey
+		}
+		responses[i] = s.call(req)
+	}
+	return responses, nil
+}
+
+// apiReflect lists the supported actions, limited to the requested ones
+func (s *Server) apiReflect(raw params) (interface{}, error) {
+	var p struct {
+		Scopes  []string `json:"scopes"`
+		Actions []string `json:"actions"`
+	}
+	if err := raw.decode(&p); err != nil {
+		return nil, err
+	}
+
+	supported := []string{"version", "multi", "apiReflect"}
+	for action := range handlers {
+		supported = append(supported, action)
+	}
+	var actions []string
+	for _, action := range supported {
+		if s.disabled[action] || (p.Actions != nil && indexOf(p.Actions, action) < 0) {
+			continue
+		}
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	var scopes []string
+	if indexOf(p.Scopes, "actions") >= 0 {
+		scopes = []string{"actions"}
+	}
+	return map[string]interface{}{"scopes": scopes, "actions": actions}, nil
+}
+
+// Actions returns the actions received so far in order, including those
+// inside multi requests
+func (s *Server) Actions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.actions...)
+}
+
+// AddNote adds a note to the collection as if it was added in Anki,
+// creating the deck if needed
+func (s *Server) AddNote(deckName, modelName string, fields map[string]string, tags ...string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.col.createDeck(deckName)
+	return s.col.addNote(noteParams{
+		DeckName:  deckName,
+		ModelName: modelName,
+		Fields:    fields,
+		Tags:      tags,
+	})
+}
+
+// UpdateNote changes the fields of a note as if it was edited in Anki
+func (s *Server) UpdateNote(id int64, fields map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, err := s.col.note(id)
+	if err != nil {
+		return err
+	}
+	s.col.setFields(n, fields)
+	return nil
+}
+
+// ChangeNoteModel changes the note type of a note as if it was changed in
+// Anki, setting the fields of the new note type. The note keeps its cards.
+func (s *Server) ChangeNoteModel(id int64, modelName string, fields map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, err := s.col.note(id)
+	if err != nil {
+		return err
+	}
+	m, ok := s.col.model(modelName)
+	if !ok {
+		return fmt.Errorf("model was not found: %s", modelName)
+	}
+	n.model = m
+	n.fields = make([]string, len(m.fields))
+	s.col.setFields(n, fields)
+	return nil
+}
+
+// NoteInfo returns a note as reported by notesInfo
+func (s *Server) NoteInfo(id int64) (anki.NoteInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.col.notes[id]
+	if !ok {
+		return anki.NoteInfo{}, false
+	}
+	return s.col.noteInfo(n), true
+}
+
+// FindNotes returns the IDs of the notes matching an Anki search query
+func (s *Server) FindNotes(query string) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.col.findNotes(query)
+}
+
+// DeckNames returns the names of all decks
+func (s *Server) DeckNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.col.deckNames()
+}
+
+// AddModel adds a note type to the collection
+func (s *Server) AddModel(model anki.NoteModel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.col.createModel(model)
+	return err
+}
+
+// Model returns a note type by name
+func (s *Server) Model(name string) (anki.NoteModel, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.col.model(name)
+	if !ok {
+		return anki.NoteModel{}, false
+	}
+	return m.noteModel(), true
+}
+
+// StoreMedia puts a file in the media folder
+func (s *Server) StoreMedia(filename string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.col.media[filename] = append([]byte(nil), data...)
+}
+
+// Media returns the content of a file in the media folder
+func (s *Server) Media(filename string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.col.media[filename]
+	return append([]byte(nil), data...), ok
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,262 @@
+package ankitest_test
+
+import (
+	"errors"
+	"testing"
+
+	anki "github.com/ezynda3/go-anki-deck"
+	"github.com/ezynda3/go-anki-deck/ankitest"
+)
+
+func addNoteAction(deck, front, back string, tags ...string) anki.MultiAction {
+	return anki.MultiAction{
+		Action: "addNote",
+		Params: map[string]interface{}{
+			"note": map[string]interface{}{
+				"deckName":  deck,
+				"modelName": "Basic",
+				"fields":    map[string]string{"Front": front, "Back": back},
+				"tags":      tags,
+			},
+		},
+	}
+}
+
+func TestServer_Notes(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+
+	if err := ac.CreateDeck("Spanish::Verbs"); err != nil {
+		t.Fatalf("CreateDeck failed: %v", err)
+	}
+	names, err := ac.GetDeckNames()
+	if err != nil {
+		t.Fatalf("GetDeckNames failed: %v", err)
+	}
+	if len(names) != 3 || names[0] != "Default" || names[1] != "Spanish" || names[2] != "Spanish::Verbs" {
+		t.Errorf("expected parent decks to be created, got %v", names)
+	}
+
+	results, err := ac.Multi([]anki.MultiAction{
+		addNoteAction("Spanish::Verbs", "ser", "to be", "verb", "irregular"),
+		addNoteAction("Spanish::Verbs", "<b>ser</b>", "to be"),
+		addNoteAction("Spanish", "", "empty"),
+		addNoteAction("Missing", "hola", "hello"),
+		addNoteAction("Spanish", "hablar", "to speak", "verb"),
+	})
+	if err != nil {
+		t.Fatalf("Multi failed: %v", err)
+	}
+	if results[0].Err != nil || results[4].Err != nil {
+		t.Fatalf("expected notes to be added, got %v and %v", results[0].Err, results[4].Err)
+	}
+	if !errors.Is(results[1].Err, anki.ErrDuplicate) {
+		t.Errorf("expected a duplicate error ignoring HTML, got %v", results[1].Err)
+	}
+	if results[2].Err == nil || results[3].Err == nil {
+		t.Errorf("expected errors for an empty note and a missing deck, got %v and %v", results[2].Err, results[3].Err)
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{`deck:Spanish`, 2},
+		{`deck:Spanish::Verbs`, 1},
+		{`deck:"spanish::verbs"`, 1},
+		{`tag:irregular`, 1},
+		{`tag:verb -tag:irregular`, 1},
+		{`front:ser`, 1},
+		{`front:s*`, 1},
+		{`speak`, 1},
+		{`ser or hablar`, 2},
+		{`(ser or hablar) tag:irregular`, 1},
+		{`note:Basic is:new`, 2},
+		{`is:suspended`, 0},
+		{`deck:*`, 2},
+	}
+	for _, tt := range tests {
+		ids, err := ac.FindNotes(tt.query)
+		if err != nil {
+			t.Errorf("FindNotes(%q) failed: %v", tt.query, err)
+			continue
+		}
+		if len(ids) != tt.want {
+			t.Errorf("FindNotes(%q) = %d notes, want %d", tt.query, len(ids), tt.want)
+		}
+	}
+
+	ids, err := ac.FindNotes("ser")
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("FindNotes failed: %v %v", ids, err)
+	}
+	if err := ac.UpdateNote(ids[0], map[string]string{"Back": "to be (permanent)"}, []string{"verb"}); err != nil {
+		t.Fatalf("UpdateNote failed: %v", err)
+	}
+	notes, err := ac.NotesInfo(ids)
+	if err != nil {
+		t.Fatalf("NotesInfo failed: %v", err)
+	}
+	if got := notes[0].Fields["Back"]; got.Value != "to be (permanent)" || got.Order != 1 {
+		t.Errorf("unexpected Back field: %+v", got)
+	}
+	if len(notes[0].Tags) != 1 || notes[0].Tags[0] != "verb" {
+		t.Errorf("expected tags to be replaced, got %v", notes[0].Tags)
+	}
+
+	cards, err := ac.CardsInfo(notes[0].Cards)
+	if err != nil {
+		t.Fatalf("CardsInfo failed: %v", err)
+	}
+	if cards[0].DeckName != "Spanish::Verbs" || cards[0].Question != "ser" {
+		t.Errorf("unexpected card: %+v", cards[0])
+	}
+	if _, err := ac.Suspend(notes[0].Cards); err != nil {
+		t.Fatalf("Suspend failed: %v", err)
+	}
+	if ids, _ := ac.FindNotes("is:suspended"); len(ids) != 1 {
+		t.Errorf("expected a suspended note, got %v", ids)
+	}
+
+	if err := ac.DeleteDeck("Spanish"); err != nil {
+		t.Fatalf("DeleteDeck failed: %v", err)
+	}
+	if ids, _ := ac.FindNotes(""); len(ids) != 0 {
+		t.Errorf("expected notes to be deleted with their deck, got %v", ids)
+	}
+}
+
+func TestServer_Models(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+
+	err := ac.CreateModel(anki.NoteModel{
+		Name:   "Vocab",
+		Fields: []string{"Word", "Meaning", "Example"},
+		Templates: []anki.CardTemplate{
+			{Name: "Recognition", Front: "{{Word}}", Back: "{{FrontSide}}<hr>{{Meaning}}"},
+			{Name: "Example", Front: "{{#Example}}{{Example}}{{/Example}}", Back: "{{Word}}"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateModel failed: %v", err)
+	}
+	if err := ac.CreateModel(anki.NoteModel{Name: "Vocab", Fields: []string{"A"}, Templates: []anki.CardTemplate{{Front: "{{A}}"}}}); err == nil {
+		t.Error("expected an error for an existing model")
+	}
+
+	templates, err := ac.ModelTemplates("Vocab")
+	if err != nil {
+		t.Fatalf("ModelTemplates failed: %v", err)
+	}
+	if len(templates) != 2 || templates[0].Name != "Recognition" || templates[1].Name != "Example" {
+		t.Errorf("expected templates in card order, got %+v", templates)
+	}
+	if _, err := ac.ModelFieldNames("Missing"); !errors.Is(err, anki.ErrModelNotFound) {
+		t.Errorf("expected ErrModelNotFound, got %v", err)
+	}
+
+	// Cards are only generated for templates with a non-empty front
+	id, err := server.AddNote("Default", "Vocab", map[string]string{"Word": "gato", "Meaning": "cat"})
+	if err != nil {
+		t.Fatalf("AddNote failed: %v", err)
+	}
+	info, _ := server.NoteInfo(id)
+	if len(info.Cards) != 1 {
+		t.Errorf("expected one card, got %d", len(info.Cards))
+	}
+	if err := server.UpdateNote(id, map[string]string{"Example": "El gato duerme."}); err != nil {
+		t.Fatalf("UpdateNote failed: %v", err)
+	}
+	info, _ = server.NoteInfo(id)
+	if len(info.Cards) != 2 {
+		t.Errorf("expected a card for the filled in template, got %d", len(info.Cards))
+	}
+
+	// Changing the note type keeps the note and its cards
+	basic, err := server.AddNote("Default", "Basic", map[string]string{"Front": "perro", "Back": "dog"})
+	if err != nil {
+		t.Fatalf("AddNote failed: %v", err)
+	}
+	if err := server.ChangeNoteModel(basic, "Vocab", map[string]string{"Word": "perro", "Meaning": "dog"}); err != nil {
+		t.Fatalf("ChangeNoteModel failed: %v", err)
+	}
+	info, _ = server.NoteInfo(basic)
+	if info.ModelName != "Vocab" || info.Fields["Meaning"].Value != "dog" || len(info.Cards) != 1 {
+		t.Errorf("expected a Vocab note with one card, got %+v", info)
+	}
+	if err := server.ChangeNoteModel(basic, "Missing", nil); err == nil {
+		t.Error("expected an error for a missing model")
+	}
+
+	id, err = server.AddNote("Default", "Cloze", map[string]string{"Text": "{{c1::Madrid}} is the capital of {{c2::Spain}}"})
+	if err != nil {
+		t.Fatalf("AddNote failed: %v", err)
+	}
+	info, _ = server.NoteInfo(id)
+	cards, err := ac.CardsInfo(info.Cards)
+	if err != nil {
+		t.Fatalf("CardsInfo failed: %v", err)
+	}
+	if len(cards) != 2 || cards[1].Question != `Madrid is the capital of <span class="cloze">[...]</span>` {
+		t.Errorf("unexpected cloze cards: %+v", cards)
+	}
+}
+
+func TestServer_Media(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+
+	if err := ac.StoreMediaFile("cat.jpg", []byte("meow")); err != nil {
+		t.Fatalf("StoreMediaFile failed: %v", err)
+	}
+	data, err := ac.RetrieveMediaFile("cat.jpg")
+	if err != nil || string(data) != "meow" {
+		t.Errorf("RetrieveMediaFile = %q, %v", data, err)
+	}
+	if _, err := ac.RetrieveMediaFile("dog.jpg"); !errors.Is(err, anki.ErrMediaNotFound) {
+		t.Errorf("expected ErrMediaNotFound, got %v", err)
+	}
+	names, err := ac.GetMediaFilesNames("*.jpg")
+	if err != nil || len(names) != 1 {
+		t.Errorf("GetMediaFilesNames = %v, %v", names, err)
+	}
+	if err := ac.DeleteMediaFile("cat.jpg"); err != nil {
+		t.Fatalf("DeleteMediaFile failed: %v", err)
+	}
+	if _, ok := server.Media("cat.jpg"); ok {
+		t.Error("expected the file to be deleted")
+	}
+}
+
+func TestServer_Options(t *testing.T) {
+	server := ankitest.NewServer(ankitest.WithAPIKey("secret"), ankitest.WithVersion(5), ankitest.WithoutActions("multi"))
+	defer server.Close()
+
+	if err := server.Client().Ping(); err == nil {
+		t.Error("expected requests without the key to fail")
+	}
+
+	ac := server.Client(anki.WithAPIKey("secret"))
+	caps, err := ac.Capabilities()
+	if err != nil {
+		t.Fatalf("Capabilities failed: %v", err)
+	}
+	if caps.Version != 5 || caps.Supports("multi") || !caps.Supports("addNote") {
+		t.Errorf("unexpected capabilities: %+v", caps)
+	}
+
+	// Multi falls back to single requests
+	results, err := ac.Multi([]anki.MultiAction{addNoteAction("Default", "one", "1"), addNoteAction("Default", "two", "2")})
+	if err != nil || results[0].Err != nil || results[1].Err != nil {
+		t.Fatalf("Multi failed: %v %+v", err, results)
+	}
+	for _, action := range server.Actions() {
+		if action == "multi" {
+			t.Error("expected no multi requests")
+		}
+	}
+}
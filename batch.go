@@ -0,0 +1,285 @@
+package anki
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// defaultBatchSize is the number of actions sent per multi request
+const defaultBatchSize = 100
+
+// MultiAction is a single action of a Multi request
+type MultiAction struct {
+	Action string
+	Params interface{}
+}
+
+// MultiResult is the outcome of a single action of a Multi request
+type MultiResult struct {
+	Result json.RawMessage
+	Err    error
+}
+
+// NoteError is the error of a single note in a batch
+type NoteError struct {
+	NoteID int64 // Local note ID
+	Err    error
+}
+
+func (e *NoteError) Error() string {
+	return fmt.Sprintf("note %d: %v", e.NoteID, e.Err)
+}
+
+func (e *NoteError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError collects the errors of the notes that failed in a batch.
+// The other notes of the batch were processed.
+type BatchError struct {
+	Errors []*NoteError
+}
+
+func (e *BatchError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+	return fmt.Sprintf("%d notes failed, first: %v", len(e.Errors), e.Errors[0])
+}
+
+// Unwrap returns the errors of the failed notes
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Multi runs several actions in a single request. Each action succeeds or
+// fails on its own; the error return is for the request as a whole. Servers
+// without multi get one request per action.
+func (ac *AnkiConnect) Multi(actions []MultiAction) ([]MultiResult, error) {
+	return ac.MultiContext(context.Background(), actions)
+}
+
+// MultiContext is like Multi but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) MultiContext(ctx context.Context, actions []MultiAction) ([]MultiResult, error) {
+	version := ac.APIVersion()
+	requests := make([]ankiRequest, len(actions))
+	for i, action := range actions {
+		requests[i] = ankiRequest{
+			Action:  action.Action,
+			Version: version,
+			Params:  action.Params,
+			Key:     ac.APIKey,
+		}
+	}
+
+	if !ac.supports("multi") {
+		return ac.runEach(ctx, actions)
+	}
+
+	var responses []json.RawMessage
+	if err := ac.invokeInto(ctx, "multi", map[string]interface{}{"actions": requests}, &responses); err != nil {
+		if ac.unsupportedFallback("multi", err) {
+			return ac.runEach(ctx, actions)
+		}
+		return nil, err
+	}
+	if len(responses) != len(actions) {
+		return nil, fmt.Errorf("multi returned %d results for %d actions", len(responses), len(actions))
+	}
+
+	results := make([]MultiResult, len(responses))
+	for i, raw := range responses {
+		var response struct {
+			Result json.RawMessage `json:"result"`
+			Error  *string         `json:"error"`
+		}
+		if err := json.Unmarshal(raw, &response); err != nil {
+			return nil, fmt.Errorf("unexpected multi response: %w", err)
+		}
+		results[i].Result = response.Result
+		if response.Error != nil && *response.Error != "" {
+			results[i].Err = &APIError{Action: actions[i].Action, Message: *response.Error}
+		}
+	}
+	return results, nil
+}
+
+// runEach runs actions one request at a time, for servers without multi
+func (ac *AnkiConnect) runEach(ctx context.Context, actions []MultiAction) ([]MultiResult, error) {
+	results := make([]MultiResult, len(actions))
+	for i, action := range actions {
+		result, err := ac.invokeRaw(ctx, action.Action, action.Params)
+		var apiErr *APIError
+		if err != nil && !errors.As(err, &apiErr) {
+			return nil, err
+		}
+		results[i] = MultiResult{Result: result, Err: err}
+	}
+	return results, nil
+}
+
+// runBatched runs actions with Multi, at most BatchSize per request. It stops
+// between requests once ctx is done. onBatch, if not nil, is called with the
+// number of actions run so far after each request. On error the results of
+// the requests that completed are returned with it, as Anki has applied them.
+func (ac *AnkiConnect) runBatched(ctx context.Context, actions []MultiAction, onBatch func(done int)) ([]MultiResult, error) {
+	size := ac.BatchSize
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+
+	results := make([]MultiResult, 0, len(actions))
+	for start := 0; start < len(actions); start += size {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		end := min(start+size, len(actions))
+		chunk, err := ac.MultiContext(ctx, actions[start:end])
+		if err != nil {
+			return results, err
+		}
+		results = append(results, chunk...)
+		if onBatch != nil {
+			onBatch(len(results))
+		}
+	}
+	return results, nil
+}
+
+// AddNotes adds several notes in one request and returns their IDs. Older
+// AnkiConnect versions report a note that could not be added, such as a
+// duplicate, with ID 0. Current versions fail the whole request instead; the
+// notes are then added one at a time, so the ID of each failed note is 0.
+func (ac *AnkiConnect) AddNotes(notes []AnkiNote) ([]int64, error) {
+	return ac.AddNotesContext(context.Background(), notes)
+}
+
+// AddNotesContext is like AddNotes but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) AddNotesContext(ctx context.Context, notes []AnkiNote) ([]int64, error) {
+	if !ac.supports("addNotes") {
+		return ac.addNotesEach(ctx, notes)
+	}
+
+	var ids []*int64
+	if err := ac.invokeInto(ctx, "addNotes", map[string]interface{}{"notes": notes}, &ids); err != nil {
+		if ac.unsupportedFallback("addNotes", err) {
+			return ac.addNotesEach(ctx, notes)
+		}
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			ac.logger().InfoContext(ctx, "addNotes failed, adding notes one at a time", "error", err)
+			return ac.addNotesEach(ctx, notes)
+		}
+		return nil, err
+	}
+
+	noteIDs := make([]int64, len(ids))
+	for i, id := range ids {
+		if id != nil {
+			noteIDs[i] = *id
+		}
+	}
+	return noteIDs, nil
+}
+
+// addNotesEach adds notes with one addNote action each, for servers without
+// addNotes or when addNotes failed as a whole
+func (ac *AnkiConnect) addNotesEach(ctx context.Context, notes []AnkiNote) ([]int64, error) {
+	actions := make([]MultiAction, len(notes))
+	for i, note := range notes {
+		actions[i] = MultiAction{Action: "addNote", Params: map[string]interface{}{"note": note}}
+	}
+
+	results, err := ac.runBatched(ctx, actions, nil)
+	if err != nil {
+		return nil, err
+	}
+	noteIDs := make([]int64, len(results))
+	for i, result := range results {
+		if result.Err == nil {
+			_ = json.Unmarshal(result.Result, &noteIDs[i])
+		}
+	}
+	return noteIDs, nil
+}
+
+// CanAddNotes reports for each note whether it could be added
+func (ac *AnkiConnect) CanAddNotes(notes []AnkiNote) ([]bool, error) {
+	return ac.CanAddNotesContext(context.Background(), notes)
+}
+
+// CanAddNotesContext is like CanAddNotes but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) CanAddNotesContext(ctx context.Context, notes []AnkiNote) ([]bool, error) {
+	var results []bool
+	err := ac.invokeInto(ctx, "canAddNotes", map[string]interface{}{"notes": notes}, &results)
+	return results, err
+}
+
+// addNotesBatched adds notes in batches, skipping duplicates. It returns the
+// Anki note ID of each note, 0 for notes that were not added, and a
+// *BatchError naming the local notes that failed. If the batches stop early,
+// e.g. because ctx is done, the IDs of the notes added so far are returned
+// with the error, so they can be recorded.
+func (d *Deck) addNotesBatched(ctx context.Context, client *AnkiConnect, localIDs []int64, notes []AnkiNote, onBatch func(done int)) ([]int64, error) {
+	actions := make([]MultiAction, len(notes))
+	for i, note := range notes {
+		actions[i] = MultiAction{Action: "addNote", Params: map[string]interface{}{"note": note}}
+	}
+
+	results, runErr := client.runBatched(ctx, actions, onBatch)
+
+	ids := make([]int64, len(notes))
+	batchErr := &BatchError{}
+	for i, result := range results {
+		if result.Err != nil {
+			if errors.Is(result.Err, ErrDuplicate) {
+				d.log(client).InfoContext(ctx, "skipped duplicate note", "note_id", localIDs[i])
+				continue
+			}
+			batchErr.Errors = append(batchErr.Errors, &NoteError{NoteID: localIDs[i], Err: result.Err})
+			continue
+		}
+		if err := json.Unmarshal(result.Result, &ids[i]); err != nil {
+			batchErr.Errors = append(batchErr.Errors, &NoteError{NoteID: localIDs[i], Err: fmt.Errorf("unexpected note ID: %w", err)})
+		}
+	}
+	if runErr != nil {
+		return ids, fmt.Errorf("failed to add notes: %w", runErr)
+	}
+	if len(batchErr.Errors) > 0 {
+		return ids, batchErr
+	}
+	return ids, nil
+}
+
+// runNoteActions runs one action per local note in batches and returns a
+// *BatchError naming the notes whose action failed
+func (ac *AnkiConnect) runNoteActions(ctx context.Context, localIDs []int64, actions []MultiAction, onBatch func(done int)) error {
+	results, err := ac.runBatched(ctx, actions, onBatch)
+	if err != nil {
+		return err
+	}
+
+	batchErr := &BatchError{}
+	for i, result := range results {
+		if result.Err != nil {
+			batchErr.Errors = append(batchErr.Errors, &NoteError{NoteID: localIDs[i], Err: result.Err})
+		}
+	}
+	if len(batchErr.Errors) > 0 {
+		return batchErr
+	}
+	return nil
+}
+
+// tagsParam formats tags for the addTags and removeTags actions
+func tagsParam(tags []string) string {
+	return strings.Join(tags, " ")
+}
@@ -0,0 +1,314 @@
+package anki
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withMulti expands multi requests into one request per action for the
+// wrapped handler, so mock servers only need to handle single actions
+func withMulti(t *testing.T, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Action string `json:"action"`
+			Params struct {
+				Actions []json.RawMessage `json:"actions"`
+			} `json:"params"`
+		}
+		body := new(bytes.Buffer)
+		if _, err := body.ReadFrom(r.Body); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(body.Bytes(), &req); err != nil {
+			t.Fatal(err)
+		}
+
+		if req.Action != "multi" {
+			r.Body = io.NopCloser(bytes.NewReader(body.Bytes()))
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		var results []json.RawMessage
+		for _, action := range req.Params.Actions {
+			sub := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(action))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, sub)
+			results = append(results, json.RawMessage(rec.Body.Bytes()))
+		}
+		if err := json.NewEncoder(w).Encode(ankiResponse{Result: results}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestAnkiConnect_Multi(t *testing.T) {
+	server := httptest.NewServer(withMulti(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ankiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+
+		var resp ankiResponse
+		switch req.Action {
+		case "deckNames":
+			resp = ankiResponse{Result: []interface{}{"Default"}, Error: ""}
+		case "version":
+			resp = ankiResponse{Result: float64(6), Error: ""}
+		default:
+			resp = ankiResponse{Result: nil, Error: "unsupported action"}
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(err)
+		}
+	})))
+	defer server.Close()
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	results, err := ac.Multi([]MultiAction{
+		{Action: "deckNames"},
+		{Action: "bogus"},
+		{Action: "version"},
+	})
+	if err != nil {
+		t.Fatalf("Multi failed: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	if results[0].Err != nil || string(results[0].Result) != `["Default"]` {
+		t.Errorf("unexpected first result %+v", results[0])
+	}
+	if results[1].Err == nil {
+		t.Error("expected error for unsupported action")
+	}
+	if results[2].Err != nil || string(results[2].Result) != "6" {
+		t.Errorf("unexpected third result %+v", results[2])
+	}
+}
+
+func TestAnkiConnect_AddNotesFallback(t *testing.T) {
+	var actions []string
+	server := httptest.NewServer(withMulti(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ankiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		actions = append(actions, req.Action)
+
+		var resp ankiResponse
+		switch req.Action {
+		case "addNotes":
+			// Current AnkiConnect versions fail the whole call
+			resp = ankiResponse{Error: "['cannot create note because it is a duplicate']"}
+		case "addNote":
+			note := req.Params.(map[string]interface{})["note"].(map[string]interface{})
+			if note["fields"].(map[string]interface{})["Front"] == "Duplicate" {
+				resp = ankiResponse{Error: "cannot create note because it is a duplicate"}
+			} else {
+				resp = ankiResponse{Result: float64(len(actions))}
+			}
+		default:
+			t.Errorf("unexpected action: %s", req.Action)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(err)
+		}
+	})))
+	defer server.Close()
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	ids, err := ac.AddNotes([]AnkiNote{
+		{Fields: map[string]string{"Front": "New"}},
+		{Fields: map[string]string{"Front": "Duplicate"}},
+		{Fields: map[string]string{"Front": "Also new"}},
+	})
+	if err != nil {
+		t.Fatalf("AddNotes failed: %v", err)
+	}
+	if len(ids) != 3 || ids[0] == 0 || ids[1] != 0 || ids[2] == 0 {
+		t.Errorf("expected only the duplicate to get no ID, got %v", ids)
+	}
+	if len(actions) != 4 || actions[0] != "addNotes" {
+		t.Errorf("expected addNotes and then one addNote per note, got %v", actions)
+	}
+}
+
+func TestDeck_PushToAnki_Batches(t *testing.T) {
+	multiRequests := 0
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ankiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+
+		var resp ankiResponse
+		switch req.Action {
+		case "version":
+			resp = ankiResponse{Result: float64(6), Error: ""}
+		case "createDeck":
+			resp = ankiResponse{Result: float64(123), Error: ""}
+		case "modelNames":
+			resp = ankiResponse{Result: []interface{}{"Test Deck"}, Error: ""}
+		case "updateModelTemplates", "updateModelStyling":
+			resp = ankiResponse{Result: nil, Error: ""}
+		case "modelFieldNames":
+			resp = ankiResponse{Result: []interface{}{"Front", "Back"}, Error: ""}
+		case "modelTemplates":
+			resp = ankiResponse{Result: map[string]interface{}{
+				"Card 1": map[string]interface{}{"Front": "{{Front}}", "Back": "{{FrontSide}}<hr id=answer>{{Back}}"},
+			}, Error: ""}
+		case "modelStyling":
+			resp = ankiResponse{Result: map[string]interface{}{"css": ".card {}"}, Error: ""}
+		case "addNote":
+			note := req.Params.(map[string]interface{})["note"].(map[string]interface{})
+			switch note["fields"].(map[string]interface{})["Front"] {
+			case "Duplicate":
+				resp = ankiResponse{Result: nil, Error: "cannot create note because it is a duplicate"}
+			case "Broken":
+				resp = ankiResponse{Result: nil, Error: "model was not found"}
+			default:
+				resp = ankiResponse{Result: float64(456), Error: ""}
+			}
+		default:
+			t.Errorf("unexpected action: %s", req.Action)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(err)
+		}
+	})
+	multi := withMulti(t, inner)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := new(bytes.Buffer)
+		if _, err := body.ReadFrom(r.Body); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(body.Bytes(), []byte(`"action":"multi"`)) {
+			multiRequests++
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body.Bytes()))
+		multi.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	for _, front := range []string{"One", "Duplicate", "Two", "Broken", "Three"} {
+		if err := deck.AddCard(front, "Back"); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+	}
+	notes, err := deck.Notes()
+	if err != nil {
+		t.Fatalf("Notes failed: %v", err)
+	}
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	ac.BatchSize = 2
+	err = deck.PushToAnki(ac)
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected *BatchError, got %v", err)
+	}
+	if len(batchErr.Errors) != 1 || batchErr.Errors[0].NoteID != notes[3].ID {
+		t.Errorf("expected only the Broken note to fail, got %v", batchErr.Errors)
+	}
+	if multiRequests != 3 {
+		t.Errorf("expected 5 notes in 3 multi requests, got %d", multiRequests)
+	}
+}
+
+func TestDeck_PushToAnkiContext_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	multiRequests := 0
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ankiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+
+		var resp ankiResponse
+		switch req.Action {
+		case "version":
+			resp = ankiResponse{Result: float64(6), Error: ""}
+		case "createDeck":
+			resp = ankiResponse{Result: float64(123), Error: ""}
+		case "modelNames":
+			resp = ankiResponse{Result: []interface{}{"Test Deck"}, Error: ""}
+		case "updateModelTemplates", "updateModelStyling":
+			resp = ankiResponse{Result: nil, Error: ""}
+		case "modelFieldNames":
+			resp = ankiResponse{Result: []interface{}{"Front", "Back"}, Error: ""}
+		case "modelTemplates":
+			resp = ankiResponse{Result: map[string]interface{}{
+				"Card 1": map[string]interface{}{"Front": "{{Front}}", "Back": "{{FrontSide}}<hr id=answer>{{Back}}"},
+			}, Error: ""}
+		case "modelStyling":
+			resp = ankiResponse{Result: map[string]interface{}{"css": ".card {}"}, Error: ""}
+		case "addNote":
+			resp = ankiResponse{Result: float64(456), Error: ""}
+		default:
+			t.Errorf("unexpected action: %s", req.Action)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(err)
+		}
+	})
+	multi := withMulti(t, inner)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := new(bytes.Buffer)
+		if _, err := body.ReadFrom(r.Body); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(body.Bytes(), []byte(`"action":"multi"`)) {
+			multiRequests++
+			// Cancel once the first batch has been sent
+			cancel()
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body.Bytes()))
+		multi.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	for _, front := range []string{"One", "Two", "Three"} {
+		if err := deck.AddCard(front, "Back"); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+	}
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	ac.BatchSize = 1
+	err = deck.PushToAnkiContext(ctx, ac)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if multiRequests != 1 {
+		t.Errorf("expected push to stop after 1 multi request, got %d", multiRequests)
+	}
+}
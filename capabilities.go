@@ -0,0 +1,134 @@
+package anki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupportedVersion is returned when the AnkiConnect server is too old
+var ErrUnsupportedVersion = errors.New("unsupported AnkiConnect version")
+
+// Capabilities describes what the AnkiConnect server supports
+type Capabilities struct {
+	Version int             // API version of the server
+	Actions map[string]bool // Actions reported by apiReflect, nil if unavailable
+}
+
+// Supports reports whether the server knows an action. Without apiReflect
+// every action is assumed to be supported.
+func (c *Capabilities) Supports(action string) bool {
+	if c.Actions == nil {
+		return true
+	}
+	return c.Actions[action]
+}
+
+// ServerVersion returns the API version of the AnkiConnect server
+func (ac *AnkiConnect) ServerVersion() (int, error) {
+	return ac.ServerVersionContext(context.Background())
+}
+
+// ServerVersionContext is like ServerVersion but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) ServerVersionContext(ctx context.Context) (int, error) {
+	var version int
+	err := ac.invokeInto(ctx, "version", nil, &version)
+	return version, err
+}
+
+// Capabilities queries the server version and, where available, the
+// supported actions with apiReflect. Requests are sent with the server's
+// version if it is older than Version. The result is remembered and used to
+// skip unsupported actions in favour of fallbacks.
+func (ac *AnkiConnect) Capabilities() (*Capabilities, error) {
+	return ac.CapabilitiesContext(context.Background())
+}
+
+// CapabilitiesContext is like Capabilities but uses ctx for cancellation and deadlines.
+func (ac *AnkiConnect) CapabilitiesContext(ctx context.Context) (*Capabilities, error) {
+	version, err := ac.ServerVersionContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get AnkiConnect version: %w", err)
+	}
+	if err := ac.negotiateVersion(version); err != nil {
+		return nil, err
+	}
+
+	caps := &Capabilities{Version: version}
+	var reflected struct {
+		Actions []string `json:"actions"`
+	}
+	err = ac.invokeInto(ctx, "apiReflect", map[string]interface{}{
+		"scopes":  []string{"actions"},
+		"actions": nil,
+	}, &reflected)
+	switch {
+	case err == nil:
+		caps.Actions = make(map[string]bool, len(reflected.Actions))
+		for _, action := range reflected.Actions {
+			caps.Actions[action] = true
+		}
+	case !errors.Is(err, ErrUnsupportedAction):
+		return nil, fmt.Errorf("failed to reflect AnkiConnect API: %w", err)
+	}
+
+	ac.mu.Lock()
+	ac.caps = caps
+	ac.mu.Unlock()
+	return caps, nil
+}
+
+// negotiateVersion rejects servers older than minAnkiConnectVersion and
+// sends later requests with the server's version if it is older than Version
+func (ac *AnkiConnect) negotiateVersion(version int) error {
+	if version < minAnkiConnectVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	ac.mu.Lock()
+	ac.serverVersion = version
+	ac.mu.Unlock()
+	return nil
+}
+
+// APIVersion returns the API version requests are sent with: Version, or the
+// server's version if Ping or Capabilities found it to be older
+func (ac *AnkiConnect) APIVersion() int {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	if ac.serverVersion > 0 && ac.serverVersion < ac.Version {
+		return ac.serverVersion
+	}
+	return ac.Version
+}
+
+// supports reports whether an action is worth trying, based on the last
+// negotiated capabilities and the actions that failed as unsupported
+func (ac *AnkiConnect) supports(action string) bool {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	if ac.unsupported[action] {
+		return false
+	}
+	return ac.caps == nil || ac.caps.Supports(action)
+}
+
+// unsupportedFallback reports whether err means the action is unknown to the
+// server, in which case it is remembered so the fallback is used directly
+// next time
+func (ac *AnkiConnect) unsupportedFallback(action string, err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Action != action || !errors.Is(err, ErrUnsupportedAction) {
+		return false
+	}
+
+	ac.mu.Lock()
+	if ac.unsupported == nil {
+		ac.unsupported = make(map[string]bool)
+	}
+	ac.unsupported[action] = true
+	ac.mu.Unlock()
+
+	ac.logger().Info("AnkiConnect action unsupported, using fallback", "action", action)
+	return true
+}
@@ -0,0 +1,140 @@
+package anki
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestAnkiConnect_Capabilities(t *testing.T) {
+	server := rawAnkiServer(t, map[string]string{
+		"version":    `5`,
+		"apiReflect": `{"scopes": ["actions"], "actions": ["version", "apiReflect", "addNote"]}`,
+		"addNote":    `1496198395707`,
+	}, nil)
+	defer server.Close()
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	caps, err := ac.Capabilities()
+	if err != nil {
+		t.Fatalf("Capabilities failed: %v", err)
+	}
+	if caps.Version != 5 || ac.APIVersion() != 5 {
+		t.Errorf("expected version 5 to be negotiated, got %d and %d", caps.Version, ac.APIVersion())
+	}
+	if !caps.Supports("addNote") || caps.Supports("addNotes") {
+		t.Errorf("unexpected supported actions %v", caps.Actions)
+	}
+
+	// Neither addNotes nor multi are sent to the server
+	ids, err := ac.AddNotes([]AnkiNote{{DeckName: "Default"}, {DeckName: "Default"}})
+	if err != nil {
+		t.Fatalf("AddNotes failed: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != 1496198395707 || ids[1] != 1496198395707 {
+		t.Errorf("unexpected note IDs %v", ids)
+	}
+}
+
+func TestAnkiConnect_UnsupportedVersion(t *testing.T) {
+	server := rawAnkiServer(t, map[string]string{"version": `4`}, nil)
+	defer server.Close()
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	if _, err := ac.Capabilities(); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestAnkiConnect_UnsupportedActionFallbacks(t *testing.T) {
+	requests := make(map[string]int)
+	params := make(map[string]json.RawMessage)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Action string          `json:"action"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		requests[req.Action]++
+		params[req.Action] = req.Params
+
+		result := `null`
+		switch req.Action {
+		case "multi", "updateNote":
+			if _, err := w.Write([]byte(`{"result": null, "error": "unsupported action"}`)); err != nil {
+				t.Fatal(err)
+			}
+			return
+		case "version":
+			result = `6`
+		case "notesInfo":
+			result = `[{"noteId": 1, "tags": ["old", "keep"], "fields": {}}]`
+		}
+		if _, err := w.Write([]byte(`{"result": ` + result + `, "error": null}`)); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	defer server.Close()
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	for i := 0; i < 2; i++ {
+		results, err := ac.Multi([]MultiAction{{Action: "version"}, {Action: "version"}})
+		if err != nil {
+			t.Fatalf("Multi failed: %v", err)
+		}
+		if len(results) != 2 || string(results[1].Result) != "6" {
+			t.Errorf("unexpected results %+v", results)
+		}
+	}
+	if requests["multi"] != 1 || requests["version"] != 4 {
+		t.Errorf("expected multi to be tried once, got %v", requests)
+	}
+
+	if err := ac.UpdateNote(1, map[string]string{"Front": "new"}, []string{"keep", "new"}); err != nil {
+		t.Fatalf("UpdateNote failed: %v", err)
+	}
+	if requests["updateNoteFields"] != 1 {
+		t.Errorf("expected fallback to updateNoteFields, got %v", requests)
+	}
+	if string(params["removeTags"]) != `{"notes":[1],"tags":"old"}` || string(params["addTags"]) != `{"notes":[1],"tags":"new"}` {
+		t.Errorf("unexpected tag changes %s and %s", params["removeTags"], params["addTags"])
+	}
+}
+
+func TestAnkiConnect_PingNegotiatesVersion(t *testing.T) {
+	server := rawAnkiServer(t, map[string]string{"version": `5`, "deckNames": `["Default"]`}, nil)
+	defer server.Close()
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := ac.Ping(); err != nil {
+				t.Errorf("Ping failed: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := ac.GetDeckNames(); err != nil {
+				t.Errorf("GetDeckNames failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if ac.APIVersion() != 5 || ac.Version != 6 {
+		t.Errorf("expected Ping to negotiate version 5 and keep Version, got %d and %d", ac.APIVersion(), ac.Version)
+	}
+
+	old := rawAnkiServer(t, map[string]string{"version": `4`}, nil)
+	defer old.Close()
+	if err := NewAnkiConnectWithURL(old.URL).Ping(); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
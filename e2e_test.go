@@ -0,0 +1,1158 @@
+package anki_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	anki "github.com/ezynda3/go-anki-deck"
+	"github.com/ezynda3/go-anki-deck/ankitest"
+)
+
+// remoteFronts returns the Front field of the notes in an emulated deck
+func remoteFronts(t *testing.T, server *ankitest.Server, deck string) map[string]int64 {
+	t.Helper()
+	ids, err := server.FindNotes(`deck:"` + deck + `"`)
+	if err != nil {
+		t.Fatalf("FindNotes failed: %v", err)
+	}
+	fronts := make(map[string]int64, len(ids))
+	for _, id := range ids {
+		info, _ := server.NoteInfo(id)
+		fronts[info.Fields["Front"].Value] = id
+	}
+	return fronts
+}
+
+func TestEndToEnd_PushAndSync(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+
+	deck, err := anki.NewDeck("Spanish")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	deck.AddMedia("gato.mp3", []byte("miau"))
+	for _, card := range [][2]string{{"gato", "cat [sound:gato.mp3]"}, {"perro", "dog"}, {"casa", "house"}} {
+		if err := deck.AddCardWithOptions(card[0], card[1], &anki.CardOptions{Tags: []string{"noun"}}); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+	}
+
+	if err := deck.PushToAnkiWithMedia(ac, true); err != nil {
+		t.Fatalf("PushToAnkiWithMedia failed: %v", err)
+	}
+	if fronts := remoteFronts(t, server, "Spanish"); len(fronts) != 3 {
+		t.Fatalf("expected 3 notes in Anki, got %v", fronts)
+	}
+	if data, ok := server.Media("gato.mp3"); !ok || string(data) != "miau" {
+		t.Errorf("expected the media file in Anki, got %q", data)
+	}
+
+	// Pushing again skips the duplicates
+	if err := deck.PushToAnki(ac); err != nil {
+		t.Fatalf("PushToAnki failed: %v", err)
+	}
+	if fronts := remoteFronts(t, server, "Spanish"); len(fronts) != 3 {
+		t.Fatalf("expected duplicates to be skipped, got %v", fronts)
+	}
+
+	// Edit and delete locally, then sync the changes
+	notes, err := deck.Notes()
+	if err != nil {
+		t.Fatalf("Notes failed: %v", err)
+	}
+	for _, note := range notes {
+		switch note.Fields[0] {
+		case "perro":
+			note.Fields[1] = "dog, hound"
+			note.Tags = []string{"noun", "animal"}
+			if err := deck.UpdateNote(&note); err != nil {
+				t.Fatalf("UpdateNote failed: %v", err)
+			}
+		case "casa":
+			if err := deck.DeleteNote(note.ID); err != nil {
+				t.Fatalf("DeleteNote failed: %v", err)
+			}
+		}
+	}
+
+	report, err := deck.SyncToAnkiWithReport(ac, &anki.SyncOptions{UpdateExisting: true, DeleteMissing: true})
+	if err != nil {
+		t.Fatalf("SyncToAnkiWithReport failed: %v", err)
+	}
+	if len(report.Deleted) != 1 || report.Deleted[0].Fields["Front"] != "casa" {
+		t.Errorf("expected casa to be deleted, got %+v", report.Deleted)
+	}
+
+	fronts := remoteFronts(t, server, "Spanish")
+	if _, ok := fronts["casa"]; ok || len(fronts) != 2 {
+		t.Fatalf("expected casa to be gone from Anki, got %v", fronts)
+	}
+	info, _ := server.NoteInfo(fronts["perro"])
+	if info.Fields["Back"].Value != "dog, hound" || len(info.Tags) != 2 {
+		t.Errorf("expected the edit to reach Anki, got %+v", info)
+	}
+}
+
+func TestEndToEnd_PushReportAndMediaURL(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+
+	// Only gato.mp3 can be downloaded
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/media/gato.mp3" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("miau"))
+	}))
+	defer files.Close()
+
+	deck, err := anki.NewDeck("Spanish")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+	deck.AddMedia("gato.mp3", []byte("miau"))
+	deck.AddMedia("perro.mp3", []byte("guau"))
+	if err := deck.AddCard("gato", "cat [sound:gato.mp3]"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+	if err := deck.AddCard("perro", "dog [sound:perro.mp3]"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+
+	report, err := deck.PushToAnkiWithOptions(ac, &anki.PushOptions{
+		SyncMedia:    true,
+		MediaOptions: &anki.MediaSyncOptions{BaseURL: files.URL + "/media/"},
+	})
+	if err != nil {
+		t.Fatalf("PushToAnkiWithOptions failed: %v", err)
+	}
+	if report.Added != 2 {
+		t.Errorf("expected 2 notes to be added, got %d", report.Added)
+	}
+	if report.Media == nil || len(report.Media.Uploaded) != 1 || report.Media.Uploaded[0] != "gato.mp3" {
+		t.Fatalf("expected gato.mp3 to be uploaded, got %+v", report.Media)
+	}
+	if len(report.Media.Failed) != 1 || report.Media.Failed[0].Filename != "perro.mp3" {
+		t.Errorf("expected perro.mp3 to fail, got %v", report.Media.Failed)
+	}
+	if data, ok := server.Media("gato.mp3"); !ok || string(data) != "miau" {
+		t.Errorf("expected Anki to download gato.mp3, got %q", data)
+	}
+
+	// Pushing again adds nothing and finds the media unchanged
+	report, err = deck.PushToAnkiWithOptions(ac, &anki.PushOptions{SyncMedia: true})
+	if err != nil {
+		t.Fatalf("PushToAnkiWithOptions failed: %v", err)
+	}
+	if report.Added != 0 || len(report.Media.Unchanged) != 1 || len(report.Media.Uploaded) != 1 {
+		t.Errorf("expected only perro.mp3 to be uploaded again, got %d added and %+v", report.Added, report.Media)
+	}
+}
+
+func TestEndToEnd_DeleteMissingLimits(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+
+	deck, err := anki.NewDeck("Spanish")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+	if err := deck.AddCard("gato", "cat"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+	if err := deck.PushToAnki(ac); err != nil {
+		t.Fatalf("PushToAnki failed: %v", err)
+	}
+
+	// Notes of subdecks are not part of the deck
+	subID, err := server.AddNote("Spanish::Verbs", "Spanish", map[string]string{"Front": "ser", "Back": "to be"})
+	if err != nil {
+		t.Fatalf("AddNote failed: %v", err)
+	}
+	for i := 0; i <= anki.DefaultMaxDeletes; i++ {
+		fields := map[string]string{"Front": fmt.Sprintf("extra %d", i), "Back": "-"}
+		if _, err := server.AddNote("Spanish", "Spanish", fields); err != nil {
+			t.Fatalf("AddNote failed: %v", err)
+		}
+	}
+
+	// Without MaxDeletes the default cap applies
+	opts := &anki.SyncOptions{UpdateExisting: true, DeleteMissing: true}
+	if _, err := deck.SyncToAnkiWithReport(ac, opts); !errors.Is(err, anki.ErrTooManyDeletes) {
+		t.Fatalf("expected ErrTooManyDeletes, got %v", err)
+	}
+
+	opts.MaxDeletes = -1
+	report, err := deck.SyncToAnkiWithReport(ac, opts)
+	if err != nil {
+		t.Fatalf("SyncToAnkiWithReport failed: %v", err)
+	}
+	if len(report.Deleted) != anki.DefaultMaxDeletes+1 {
+		t.Errorf("expected %d notes to be deleted, got %d", anki.DefaultMaxDeletes+1, len(report.Deleted))
+	}
+	for _, deleted := range report.Deleted {
+		if deleted.NoteID == subID {
+			t.Errorf("expected the subdeck note to be kept")
+		}
+	}
+	if _, ok := server.NoteInfo(subID); !ok {
+		t.Errorf("expected the subdeck note to stay in Anki")
+	}
+	if fronts := remoteFronts(t, server, "Spanish"); len(fronts) != 2 {
+		t.Errorf("expected gato and the subdeck note in Anki, got %v", fronts)
+	}
+}
+
+func TestEndToEnd_CancelledPushKeepsAddedNotes(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+	ac.BatchSize = 1
+
+	deck, err := anki.NewDeck("Spanish")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+	for _, card := range [][2]string{{"gato", "cat"}, {"perro", "dog"}, {"casa", "house"}, {"agua", "water"}} {
+		if err := deck.AddCard(card[0], card[1]); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+	}
+
+	// Cancel after the second batch
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	deck.SetProgress(func(p anki.Progress) {
+		if p.Phase == anki.PhaseNotes && p.Done == 2 {
+			cancel()
+		}
+	})
+	if err := deck.PushToAnkiContext(ctx, ac); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	deck.SetProgress(nil)
+	if fronts := remoteFronts(t, server, "Spanish"); len(fronts) != 2 {
+		t.Fatalf("expected 2 notes in Anki, got %v", fronts)
+	}
+
+	// The added notes stay paired even when their key field changes
+	notes, err := deck.SearchNotes("front:gato")
+	if err != nil || len(notes) != 1 {
+		t.Fatalf("SearchNotes failed: %v", err)
+	}
+	notes[0].Fields[0] = "el gato"
+	if err := deck.UpdateNote(&notes[0]); err != nil {
+		t.Fatalf("UpdateNote failed: %v", err)
+	}
+	if err := deck.SyncToAnki(ac, &anki.SyncOptions{UpdateExisting: true}); err != nil {
+		t.Fatalf("SyncToAnki failed: %v", err)
+	}
+	fronts := remoteFronts(t, server, "Spanish")
+	if _, ok := fronts["gato"]; ok || len(fronts) != 4 {
+		t.Errorf("expected the edited note to be updated, not added again, got %v", fronts)
+	}
+}
+
+func TestEndToEnd_Pull(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+
+	deck, err := anki.NewDeck("Spanish")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+	if err := deck.AddCard("gato", "cat"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+	if err := deck.PushToAnki(ac); err != nil {
+		t.Fatalf("PushToAnki failed: %v", err)
+	}
+
+	// Edit the note in Anki and add another one there
+	fronts := remoteFronts(t, server, "Spanish")
+	if err := server.UpdateNote(fronts["gato"], map[string]string{"Back": "cat, kitty"}); err != nil {
+		t.Fatalf("UpdateNote failed: %v", err)
+	}
+	info, _ := server.NoteInfo(fronts["gato"])
+	if _, err := server.AddNote("Spanish", info.ModelName, map[string]string{"Front": "perro", "Back": "dog"}, "noun"); err != nil {
+		t.Fatalf("AddNote failed: %v", err)
+	}
+
+	report, err := deck.PullFromAnkiWithOptions(ac, nil)
+	if err != nil {
+		t.Fatalf("PullFromAnkiWithOptions failed: %v", err)
+	}
+	if report.Added != 1 || report.Updated != 1 {
+		t.Errorf("expected one added and one updated note, got %+v", report)
+	}
+
+	notes, err := deck.SearchNotes("back:cat*")
+	if err != nil {
+		t.Fatalf("SearchNotes failed: %v", err)
+	}
+	if len(notes) != 1 || notes[0].Fields[1] != "cat, kitty" {
+		t.Errorf("expected the edit from Anki, got %+v", notes)
+	}
+
+	// Pulled notes stay paired with their note in Anki when its key field changes
+	fronts = remoteFronts(t, server, "Spanish")
+	if err := server.UpdateNote(fronts["perro"], map[string]string{"Front": "el perro"}); err != nil {
+		t.Fatalf("UpdateNote failed: %v", err)
+	}
+	report, err = deck.PullFromAnkiWithOptions(ac, nil)
+	if err != nil {
+		t.Fatalf("PullFromAnkiWithOptions failed: %v", err)
+	}
+	if report.Added != 0 || report.Updated != 1 {
+		t.Errorf("expected the renamed note to be updated, got %+v", report)
+	}
+	if all, _ := deck.Notes(); len(all) != 2 {
+		t.Errorf("expected 2 local notes, got %+v", all)
+	}
+}
+
+func TestEndToEnd_OldServer(t *testing.T) {
+	server := ankitest.NewServer(ankitest.WithoutActions("multi", "addNotes", "updateNote"))
+	defer server.Close()
+	ac := server.Client()
+
+	deck, err := anki.NewDeck("Spanish")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+	for _, front := range []string{"uno", "dos", "tres"} {
+		if err := deck.AddCard(front, "number"); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+	}
+
+	if err := deck.SyncToAnki(ac, nil); err != nil {
+		t.Fatalf("SyncToAnki failed: %v", err)
+	}
+	fronts := remoteFronts(t, server, "Spanish")
+	if len(fronts) != 3 {
+		t.Fatalf("expected 3 notes in Anki, got %v", fronts)
+	}
+
+	if err := ac.UpdateNote(fronts["uno"], map[string]string{"Back": "one"}, []string{"number"}); err != nil {
+		t.Fatalf("UpdateNote failed: %v", err)
+	}
+	info, _ := server.NoteInfo(fronts["uno"])
+	if info.Fields["Back"].Value != "one" || len(info.Tags) != 1 {
+		t.Errorf("expected the fallback to update fields and tags, got %+v", info)
+	}
+}
+
+func TestEndToEnd_PullNoteTypes(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+
+	err := server.AddModel(anki.NoteModel{
+		Name:   "Vocab",
+		Fields: []string{"Word", "Meaning", "Example"},
+		Templates: []anki.CardTemplate{
+			{Name: "Recognition", Front: "{{Word}}", Back: "{{FrontSide}}<hr>{{Meaning}}"},
+			{Name: "Example", Front: "{{#Example}}{{Example}}{{/Example}}", Back: "{{Word}}"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("AddModel failed: %v", err)
+	}
+	server.StoreMedia("gato.jpg", []byte("jpeg"))
+	fields := map[string]string{"Word": "gato", "Meaning": `cat <img src="gato.jpg">`, "Example": "El gato duerme."}
+	if _, err := server.AddNote("Spanish", "Vocab", fields, "noun"); err != nil {
+		t.Fatalf("AddNote failed: %v", err)
+	}
+	fields = map[string]string{"Text": "{{c1::Madrid}} is the capital of {{c2::Spain}}", "Back Extra": "[sound:madrid.mp3]"}
+	if _, err := server.AddNote("Spanish", "Cloze", fields); err != nil {
+		t.Fatalf("AddNote failed: %v", err)
+	}
+
+	deck, err := anki.NewDeck("Spanish")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	report, err := deck.PullFromAnkiWithOptions(ac, &anki.PullOptions{Media: true})
+	if err != nil {
+		t.Fatalf("PullFromAnkiWithOptions failed: %v", err)
+	}
+	if report.Added != 2 {
+		t.Errorf("expected two added notes, got %+v", report)
+	}
+	if len(report.Media) != 1 || report.Media[0] != "gato.jpg" {
+		t.Errorf("expected gato.jpg to be downloaded, got %v", report.Media)
+	}
+	if len(report.MediaFailed) != 1 || !errors.Is(report.MediaFailed[0], anki.ErrMediaNotFound) {
+		t.Errorf("expected madrid.mp3 to be missing, got %v", report.MediaFailed)
+	}
+
+	notes, err := deck.SearchNotes("word:gato")
+	if err != nil {
+		t.Fatalf("SearchNotes failed: %v", err)
+	}
+	if len(notes) != 1 || len(notes[0].Fields) != 3 || notes[0].Fields[2] != "El gato duerme." {
+		t.Fatalf("expected the note with all its fields, got %+v", notes)
+	}
+	if len(notes[0].Cards) != 2 || len(notes[0].Tags) != 1 {
+		t.Errorf("expected a card per template and the tag, got %+v", notes[0])
+	}
+	notes, err = deck.SearchNotes("text:*Madrid*")
+	if err != nil {
+		t.Fatalf("SearchNotes failed: %v", err)
+	}
+	if len(notes) != 1 || len(notes[0].Cards) != 2 || notes[0].Fields[1] != "[sound:madrid.mp3]" {
+		t.Fatalf("expected the cloze note with a card per deletion, got %+v", notes)
+	}
+
+	var missing *anki.MissingMediaError
+	if err := deck.ValidateMedia(); !errors.As(err, &missing) || len(missing.Missing) != 1 {
+		t.Errorf("expected only madrid.mp3 to be missing in the deck, got %v", err)
+	}
+	if _, err := deck.Save(); !errors.As(err, &missing) {
+		t.Errorf("expected Save to refuse the missing media, got %v", err)
+	}
+	deck.SetAllowMissingMedia(true)
+	if _, err := deck.Save(); err != nil {
+		t.Errorf("Save failed: %v", err)
+	}
+
+	// Pulling again matches the notes of each note type
+	report, err = deck.PullFromAnkiWithOptions(ac, nil)
+	if err != nil {
+		t.Fatalf("PullFromAnkiWithOptions failed: %v", err)
+	}
+	if report.Added != 0 || report.Unchanged != 2 {
+		t.Errorf("expected unchanged notes, got %+v", report)
+	}
+}
+
+func TestEndToEnd_SyncNoteTypes(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+
+	// The deck's own note type has gained a field in Anki
+	err := server.AddModel(anki.NoteModel{
+		Name:      "Spanish",
+		Fields:    []string{"Front", "Back", "Notes"},
+		Templates: []anki.CardTemplate{{Name: "Card 1", Front: "{{Front}}", Back: "{{Back}}<br>{{Notes}}"}},
+	})
+	if err != nil {
+		t.Fatalf("AddModel failed: %v", err)
+	}
+	fields := map[string]string{"Front": "gato", "Back": "cat", "Notes": "feline"}
+	if _, err := server.AddNote("Spanish", "Spanish", fields); err != nil {
+		t.Fatalf("AddNote failed: %v", err)
+	}
+	fields = map[string]string{"Text": "{{c1::Madrid}} is the capital of Spain"}
+	clozeID, err := server.AddNote("Spanish", "Cloze", fields)
+	if err != nil {
+		t.Fatalf("AddNote failed: %v", err)
+	}
+
+	deck, err := anki.NewDeck("Spanish")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+	if _, err := deck.PullFromAnkiWithOptions(ac, nil); err != nil {
+		t.Fatalf("PullFromAnkiWithOptions failed: %v", err)
+	}
+
+	// Cards fill the first two fields of the deck's note type
+	deck.SetDuplicatePolicy(anki.DuplicateUpdate)
+	if err := deck.AddCard("perro", "dog"); err != nil {
+		t.Fatalf("AddCard failed: %v", err)
+	}
+	if err := deck.AddCard("gato", "cat, kitty"); err != nil {
+		t.Fatalf("AddCard failed: %v", err)
+	}
+	notes, err := deck.SearchNotes("front:gato")
+	if err != nil {
+		t.Fatalf("SearchNotes failed: %v", err)
+	}
+	if len(notes) != 1 || len(notes[0].Fields) != 3 || notes[0].Fields[1] != "cat, kitty" || notes[0].Fields[2] != "feline" {
+		t.Fatalf("expected the update to keep the other fields, got %+v", notes)
+	}
+
+	// Each note is synced with its own note type
+	report, err := deck.SyncToAnkiWithReport(ac, &anki.SyncOptions{UpdateExisting: true, DeleteMissing: true})
+	if err != nil {
+		t.Fatalf("SyncToAnkiWithReport failed: %v", err)
+	}
+	if len(report.Deleted) != 0 {
+		t.Errorf("expected no notes to be deleted, got %v", report.Deleted)
+	}
+	ids, err := server.FindNotes(`deck:"Spanish"`)
+	if err != nil {
+		t.Fatalf("FindNotes failed: %v", err)
+	}
+	if len(ids) != 3 {
+		t.Errorf("expected 3 notes in Anki, got %v", ids)
+	}
+	if info, ok := server.NoteInfo(clozeID); !ok || info.ModelName != "Cloze" {
+		t.Errorf("expected the cloze note to be kept, got %+v", info)
+	}
+	for _, id := range ids {
+		info, _ := server.NoteInfo(id)
+		if info.ModelName == "Spanish" && info.Fields["Front"].Value == "gato" && info.Fields["Notes"].Value != "feline" {
+			t.Errorf("expected the note to keep its third field, got %+v", info)
+		}
+	}
+
+	state := &anki.SyncState{}
+	twoWay, err := deck.TwoWaySync(ac, state, nil)
+	if err != nil {
+		t.Fatalf("TwoWaySync failed: %v", err)
+	}
+	if twoWay.AddedRemote != 0 || twoWay.AddedLocal != 0 || twoWay.DeletedRemote != 0 || len(state.Notes) != 3 {
+		t.Errorf("expected every note to be paired, got %+v with %d states", twoWay, len(state.Notes))
+	}
+}
+
+func TestEndToEnd_PushSingleFieldNoteType(t *testing.T) {
+	source := ankitest.NewServer()
+	defer source.Close()
+	err := source.AddModel(anki.NoteModel{
+		Name:      "Word",
+		Fields:    []string{"Word"},
+		Templates: []anki.CardTemplate{{Name: "Card 1", Front: "{{Word}}", Back: "{{FrontSide}}"}},
+	})
+	if err != nil {
+		t.Fatalf("AddModel failed: %v", err)
+	}
+	if _, err := source.AddNote("Spanish", "Word", map[string]string{"Word": "gato"}); err != nil {
+		t.Fatalf("AddNote failed: %v", err)
+	}
+
+	deck, err := anki.NewDeck("Spanish")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+	if _, err := deck.PullFromAnkiWithOptions(source.Client(), nil); err != nil {
+		t.Fatalf("PullFromAnkiWithOptions failed: %v", err)
+	}
+
+	// The note is pushed with its one field to another Anki
+	for _, push := range []func(ac *anki.AnkiConnect) error{
+		func(ac *anki.AnkiConnect) error { return deck.PushToAnki(ac) },
+		func(ac *anki.AnkiConnect) error { return deck.SyncToAnki(ac, nil) },
+		func(ac *anki.AnkiConnect) error {
+			_, err := deck.TwoWaySync(ac, &anki.SyncState{}, nil)
+			return err
+		},
+	} {
+		target := ankitest.NewServer()
+		if err := push(target.Client()); err != nil {
+			t.Fatalf("push failed: %v", err)
+		}
+		ids, err := target.FindNotes(`deck:"Spanish"`)
+		if err != nil {
+			t.Fatalf("FindNotes failed: %v", err)
+		}
+		if len(ids) != 1 {
+			t.Fatalf("expected the note to be pushed, got %v", ids)
+		}
+		if info, _ := target.NoteInfo(ids[0]); info.ModelName != "Word" || info.Fields["Word"].Value != "gato" {
+			t.Errorf("expected the note with its own note type, got %+v", info)
+		}
+		target.Close()
+	}
+}
+
+func TestEndToEnd_PushKeepsAnkiNoteTypes(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+
+	deck, err := anki.NewDeck("Spanish")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+	if err := deck.AddCard("gato", "cat"); err != nil {
+		t.Fatalf("AddCard failed: %v", err)
+	}
+	if err := deck.PushToAnki(ac); err != nil {
+		t.Fatalf("PushToAnki failed: %v", err)
+	}
+
+	// The note type is restyled in Anki and left alone by later pushes
+	if err := ac.UpdateModelStyling("Spanish", ".card { color: red }"); err != nil {
+		t.Fatalf("UpdateModelStyling failed: %v", err)
+	}
+	if err := deck.AddCard("perro", "dog"); err != nil {
+		t.Fatalf("AddCard failed: %v", err)
+	}
+	before := len(server.Actions())
+	if err := deck.PushToAnki(ac); err != nil {
+		t.Fatalf("PushToAnki failed: %v", err)
+	}
+	if err := deck.SyncToAnki(ac, &anki.SyncOptions{UpdateExisting: true}); err != nil {
+		t.Fatalf("SyncToAnki failed: %v", err)
+	}
+	for _, action := range server.Actions()[before:] {
+		if action == "updateModelTemplates" || action == "updateModelStyling" {
+			t.Errorf("expected the note type to be left alone, got %s", action)
+		}
+	}
+	if css, err := ac.ModelStyling("Spanish"); err != nil || css != ".card { color: red }" {
+		t.Errorf("expected the styling from Anki to be kept, got %q (%v)", css, err)
+	}
+}
+
+func TestEndToEnd_PushModelMismatch(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+
+	// Anki has a note type with the deck's name but without its Back field
+	err := server.AddModel(anki.NoteModel{
+		Name:      "Spanish",
+		Fields:    []string{"Front", "Notes"},
+		Templates: []anki.CardTemplate{{Name: "Card 1", Front: "{{Front}}", Back: "{{Notes}}"}},
+	})
+	if err != nil {
+		t.Fatalf("AddModel failed: %v", err)
+	}
+
+	deck, err := anki.NewDeck("Spanish")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+	if err := deck.AddCard("gato", "cat"); err != nil {
+		t.Fatalf("AddCard failed: %v", err)
+	}
+
+	var mismatch *anki.ModelMismatchError
+	err = deck.PushToAnki(ac)
+	if !errors.As(err, &mismatch) || len(mismatch.MissingFields) != 1 || mismatch.MissingFields[0] != "Back" {
+		t.Fatalf("expected a mismatch for the Back field, got %v", err)
+	}
+	if _, err := deck.PlanSync(ac, nil); !errors.Is(err, anki.ErrModelMismatch) {
+		t.Errorf("expected PlanSync to report the mismatch, got %v", err)
+	}
+	if ids, _ := server.FindNotes(`deck:"Spanish"`); len(ids) != 0 {
+		t.Errorf("expected no notes to be pushed, got %v", ids)
+	}
+}
+
+func TestEndToEnd_AddNotes(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+
+	note := func(front string) anki.AnkiNote {
+		return anki.AnkiNote{
+			DeckName:  "Default",
+			ModelName: "Basic",
+			Fields:    map[string]string{"Front": front, "Back": "back"},
+			Tags:      []string{"batch"},
+		}
+	}
+	ids, err := ac.AddNotes([]anki.AnkiNote{note("one"), note("one"), note("two")})
+	if err != nil {
+		t.Fatalf("AddNotes failed: %v", err)
+	}
+	if len(ids) != 3 || ids[0] == 0 || ids[1] != 0 || ids[2] == 0 {
+		t.Errorf("expected the duplicate to get no ID, got %v", ids)
+	}
+
+	ok, err := ac.CanAddNotes([]anki.AnkiNote{note("one"), note("three")})
+	if err != nil {
+		t.Fatalf("CanAddNotes failed: %v", err)
+	}
+	if len(ok) != 2 || ok[0] || !ok[1] {
+		t.Errorf("expected only the new note to be addable, got %v", ok)
+	}
+}
+
+func TestEndToEnd_SyncKeepsPairingAfterRename(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+
+	deck, err := anki.NewDeck("Spanish")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	for _, card := range [][2]string{{"gato", "cat"}, {"perro", "dog"}} {
+		if err := deck.AddCard(card[0], card[1]); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+	}
+	if err := deck.PushToAnki(ac); err != nil {
+		t.Fatalf("PushToAnki failed: %v", err)
+	}
+	pushed := remoteFronts(t, server, "Spanish")
+
+	// Editing the key field keeps the note paired with the one in Anki
+	notes, err := deck.Notes()
+	if err != nil {
+		t.Fatalf("Notes failed: %v", err)
+	}
+	for _, note := range notes {
+		if note.Fields[0] == "gato" {
+			note.Fields[0] = "el gato"
+			if err := deck.UpdateNote(&note); err != nil {
+				t.Fatalf("UpdateNote failed: %v", err)
+			}
+		}
+	}
+
+	report, err := deck.SyncToAnkiWithReport(ac, &anki.SyncOptions{UpdateExisting: true, DeleteMissing: true})
+	if err != nil {
+		t.Fatalf("SyncToAnkiWithReport failed: %v", err)
+	}
+	if len(report.Deleted) != 0 {
+		t.Errorf("expected no notes to be deleted, got %v", report.Deleted)
+	}
+	fronts := remoteFronts(t, server, "Spanish")
+	if len(fronts) != 2 || fronts["el gato"] != pushed["gato"] || fronts["perro"] != pushed["perro"] {
+		t.Errorf("expected the renamed note to be updated in place, got %v (pushed %v)", fronts, pushed)
+	}
+}
+
+func TestEndToEnd_SkippedNotesStayModified(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+
+	deck, err := anki.NewDeck("Spanish")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+	if err := deck.AddCard("gato", "cat"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+	if err := deck.PushToAnki(ac); err != nil {
+		t.Fatalf("PushToAnki failed: %v", err)
+	}
+
+	// Nothing changed since the push, not even the note type
+	plan, err := deck.PlanSync(ac, nil)
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	if !plan.Empty() {
+		t.Errorf("expected an empty plan after a push, got %+v", plan)
+	}
+
+	notes, err := deck.Notes()
+	if err != nil {
+		t.Fatalf("Notes failed: %v", err)
+	}
+	notes[0].Fields[1] = "cat, kitty"
+	if err := deck.UpdateNote(&notes[0]); err != nil {
+		t.Fatalf("UpdateNote failed: %v", err)
+	}
+
+	// The edit isn't sent to Anki, so a pull must not overwrite it
+	if err := deck.SyncToAnki(ac, &anki.SyncOptions{UpdateExisting: false}); err != nil {
+		t.Fatalf("SyncToAnki failed: %v", err)
+	}
+	report, err := deck.PullFromAnkiWithOptions(ac, nil)
+	if err != nil {
+		t.Fatalf("PullFromAnkiWithOptions failed: %v", err)
+	}
+	if report.Kept != 1 || report.Updated != 0 {
+		t.Errorf("expected the local edit to be kept, got %+v", report)
+	}
+	if note, _ := deck.GetNote(notes[0].ID); note == nil || note.Fields[1] != "cat, kitty" {
+		t.Errorf("expected the local edit, got %+v", note)
+	}
+}
+
+func TestEndToEnd_TwoWaySyncTagsAndDuplicates(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+
+	deck, err := anki.NewDeck("Spanish")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+	deck.SetDuplicatePolicy(anki.DuplicateAllow)
+	tags := &anki.CardOptions{Tags: []string{"spanish::noun", "spanish::old"}}
+	for _, card := range [][2]string{{"gato", "cat"}, {"gato", "cat again"}} {
+		if err := deck.AddCardWithOptions(card[0], card[1], tags); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+	}
+
+	// The duplicate is skipped rather than failing the sync
+	state := &anki.SyncState{}
+	opts := &anki.TwoWaySyncOptions{ManagedTagPrefixes: []string{"spanish::"}}
+	report, err := deck.TwoWaySync(ac, state, opts)
+	if err != nil {
+		t.Fatalf("TwoWaySync failed: %v", err)
+	}
+	if report.AddedRemote != 1 || len(report.Skipped) != 1 {
+		t.Fatalf("expected one added and one skipped note, got %+v", report)
+	}
+	remoteID := remoteFronts(t, server, "Spanish")["gato"]
+
+	// A personal tag added in Anki survives a local edit winning the conflict
+	if err := ac.AddTags([]int64{remoteID}, []string{"marked"}); err != nil {
+		t.Fatalf("AddTags failed: %v", err)
+	}
+	notes, err := deck.SearchNotes("back:cat")
+	if err != nil || len(notes) != 1 {
+		t.Fatalf("SearchNotes failed: %v %+v", err, notes)
+	}
+	note := notes[0]
+	note.Fields[1] = "cat, kitty"
+	note.Tags = []string{"spanish::noun"}
+	if err := deck.UpdateNote(&note); err != nil {
+		t.Fatalf("UpdateNote failed: %v", err)
+	}
+
+	report, err = deck.TwoWaySync(ac, state, opts)
+	if err != nil {
+		t.Fatalf("TwoWaySync failed: %v", err)
+	}
+	if report.Pushed != 1 {
+		t.Errorf("expected the local edit to be pushed, got %+v", report)
+	}
+	info, _ := server.NoteInfo(remoteID)
+	if !equalTagSet(info.Tags, "marked", "spanish::noun") {
+		t.Errorf("expected only the managed tag to be removed in Anki, got %v", info.Tags)
+	}
+	local, err := deck.GetNote(note.ID)
+	if err != nil || !equalTagSet(local.Tags, "marked", "spanish::noun") {
+		t.Errorf("expected the personal tag to be added locally, got %+v", local)
+	}
+
+	// Both sides now match
+	report, err = deck.TwoWaySync(ac, state, opts)
+	if err != nil {
+		t.Fatalf("TwoWaySync failed: %v", err)
+	}
+	if report.Pushed != 0 || report.Pulled != 0 || len(report.Conflicts) != 0 {
+		t.Errorf("expected nothing to sync, got %+v", report)
+	}
+}
+
+// equalTagSet reports whether tags holds exactly the wanted tags in any order
+func equalTagSet(tags []string, want ...string) bool {
+	if len(tags) != len(want) {
+		return false
+	}
+	have := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		have[tag] = true
+	}
+	for _, tag := range want {
+		if !have[tag] {
+			return false
+		}
+	}
+	return true
+}
+
+// localBacks returns the Back field of the local notes by Front
+func localBacks(t *testing.T, deck *anki.Deck) map[string]string {
+	t.Helper()
+	notes, err := deck.Notes()
+	if err != nil {
+		t.Fatalf("Notes failed: %v", err)
+	}
+	backs := make(map[string]string, len(notes))
+	for _, note := range notes {
+		backs[note.Fields[0]] = note.Fields[1]
+	}
+	return backs
+}
+
+// editLocal sets the Back field of the local note with the given Front
+func editLocal(t *testing.T, deck *anki.Deck, front, back string) {
+	t.Helper()
+	notes, err := deck.SearchNotes(`front:"` + front + `"`)
+	if err != nil || len(notes) != 1 {
+		t.Fatalf("SearchNotes(%s) failed: %v %+v", front, err, notes)
+	}
+	notes[0].Fields[1] = back
+	if err := deck.UpdateNote(&notes[0]); err != nil {
+		t.Fatalf("UpdateNote failed: %v", err)
+	}
+}
+
+// deleteLocal deletes the local note with the given Front
+func deleteLocal(t *testing.T, deck *anki.Deck, front string) {
+	t.Helper()
+	ids, err := deck.FindNotes(`front:"` + front + `"`)
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("FindNotes(%s) failed: %v %v", front, err, ids)
+	}
+	if err := deck.DeleteNote(ids[0]); err != nil {
+		t.Fatalf("DeleteNote failed: %v", err)
+	}
+}
+
+// newSyncedDeck returns a deck with the given Front and Back pairs, synced
+// to the server for the first time
+func newSyncedDeck(t *testing.T, server *ankitest.Server, cards ...[2]string) (*anki.Deck, *anki.SyncState) {
+	t.Helper()
+	deck, err := anki.NewDeck("Spanish")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	for _, card := range cards {
+		if err := deck.AddCard(card[0], card[1]); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+	}
+	state := &anki.SyncState{}
+	if _, err := deck.TwoWaySync(server.Client(), state, nil); err != nil {
+		t.Fatalf("TwoWaySync failed: %v", err)
+	}
+	return deck, state
+}
+
+func TestEndToEnd_TwoWaySyncFirstSync(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+
+	// Anki has notes from an earlier push of the deck
+	err := server.AddModel(anki.NoteModel{
+		Name:      "Spanish",
+		Fields:    []string{"Front", "Back"},
+		Templates: []anki.CardTemplate{{Name: "Card 1", Front: "{{Front}}", Back: "{{Back}}"}},
+	})
+	if err != nil {
+		t.Fatalf("AddModel failed: %v", err)
+	}
+	for _, card := range [][2]string{{"gato", "cat"}, {"perro", "dog, canine"}, {"casa", "house"}} {
+		if _, err := server.AddNote("Spanish", "Spanish", map[string]string{"Front": card[0], "Back": card[1]}); err != nil {
+			t.Fatalf("AddNote failed: %v", err)
+		}
+	}
+	deck, err := anki.NewDeck("Spanish")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+	for _, card := range [][2]string{{"gato", "cat"}, {"perro", "dog"}, {"sol", "sun"}} {
+		if err := deck.AddCard(card[0], card[1]); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+	}
+
+	// Without saved state, notes are paired by their first field and a
+	// difference is a conflict
+	state := &anki.SyncState{}
+	report, err := deck.TwoWaySync(ac, state, nil)
+	if err != nil {
+		t.Fatalf("TwoWaySync failed: %v", err)
+	}
+	if report.AddedRemote != 1 || report.AddedLocal != 1 || len(report.Conflicts) != 1 || len(state.Notes) != 4 {
+		t.Fatalf("expected sol and casa to be added and perro to conflict, got %+v", report)
+	}
+	if conflict := report.Conflicts[0]; conflict.Local["Back"] != "dog" || conflict.Remote["Back"] != "dog, canine" {
+		t.Errorf("unexpected conflict %+v", conflict)
+	}
+	fronts := remoteFronts(t, server, "Spanish")
+	if len(fronts) != 4 {
+		t.Errorf("expected no duplicates in Anki, got %v", fronts)
+	}
+	if info, _ := server.NoteInfo(fronts["perro"]); info.Fields["Back"].Value != "dog" {
+		t.Errorf("expected the local note to win, got %+v", info)
+	}
+	if backs := localBacks(t, deck); len(backs) != 4 || backs["casa"] != "house" {
+		t.Errorf("expected casa to be added locally, got %v", backs)
+	}
+
+	report, err = deck.TwoWaySync(ac, state, nil)
+	if err != nil {
+		t.Fatalf("TwoWaySync failed: %v", err)
+	}
+	if report.Pushed != 0 || report.Pulled != 0 || report.AddedLocal != 0 || report.AddedRemote != 0 {
+		t.Errorf("expected nothing to sync, got %+v", report)
+	}
+}
+
+func TestEndToEnd_TwoWaySyncEditsOnBothSides(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+	deck, state := newSyncedDeck(t, server, [2]string{"gato", "cat"}, [2]string{"perro", "dog"}, [2]string{"sol", "sun"})
+	defer deck.Close()
+	fronts := remoteFronts(t, server, "Spanish")
+
+	for front, backs := range map[string][2]string{
+		"gato":  {"cat, kitty", "feline"},
+		"perro": {"dog, hound", "canine"},
+	} {
+		editLocal(t, deck, front, backs[0])
+		if err := server.UpdateNote(fronts[front], map[string]string{"Back": backs[1]}); err != nil {
+			t.Fatalf("UpdateNote failed: %v", err)
+		}
+	}
+	editLocal(t, deck, "sol", "sun, star")
+
+	report, err := deck.TwoWaySync(ac, state, &anki.TwoWaySyncOptions{
+		OnConflict: func(c anki.SyncConflict) anki.ConflictPolicy {
+			if c.Local["Front"] == "perro" {
+				return anki.RemoteWins
+			}
+			return anki.LocalWins
+		},
+	})
+	if err != nil {
+		t.Fatalf("TwoWaySync failed: %v", err)
+	}
+	if len(report.Conflicts) != 2 || report.Pushed != 2 || report.Pulled != 1 {
+		t.Fatalf("expected 2 conflicts, gato and sol pushed and perro pulled, got %+v", report)
+	}
+	for front, want := range map[string]string{"gato": "cat, kitty", "perro": "canine", "sol": "sun, star"} {
+		if info, _ := server.NoteInfo(fronts[front]); info.Fields["Back"].Value != want {
+			t.Errorf("expected %s to be %q in Anki, got %q", front, want, info.Fields["Back"].Value)
+		}
+		if back := localBacks(t, deck)[front]; back != want {
+			t.Errorf("expected %s to be %q locally, got %q", front, want, back)
+		}
+	}
+}
+
+func TestEndToEnd_TwoWaySyncDeleteAndEdit(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+	deck, state := newSyncedDeck(t, server, [2]string{"gato", "cat"}, [2]string{"perro", "dog"}, [2]string{"sol", "sun"})
+	defer deck.Close()
+	fronts := remoteFronts(t, server, "Spanish")
+
+	// gato and sol are deleted locally and edited in Anki, perro the
+	// other way round
+	for front, back := range map[string]string{"gato": "feline", "sol": "star"} {
+		deleteLocal(t, deck, front)
+		if err := server.UpdateNote(fronts[front], map[string]string{"Back": back}); err != nil {
+			t.Fatalf("UpdateNote failed: %v", err)
+		}
+	}
+	editLocal(t, deck, "perro", "dog, hound")
+	if err := ac.DeleteNotes([]int64{fronts["perro"]}); err != nil {
+		t.Fatalf("DeleteNotes failed: %v", err)
+	}
+
+	report, err := deck.TwoWaySync(ac, state, &anki.TwoWaySyncOptions{
+		OnConflict: func(c anki.SyncConflict) anki.ConflictPolicy {
+			if c.Remote != nil && c.Remote["Front"] == "sol" {
+				return anki.RemoteWins
+			}
+			return anki.LocalWins
+		},
+	})
+	if err != nil {
+		t.Fatalf("TwoWaySync failed: %v", err)
+	}
+	if len(report.Conflicts) != 3 {
+		t.Fatalf("expected 3 conflicts, got %+v", report.Conflicts)
+	}
+	for _, c := range report.Conflicts {
+		if (c.Local == nil) == (c.Remote == nil) {
+			t.Errorf("expected one side of %+v to be deleted", c)
+		}
+	}
+	if report.DeletedRemote != 1 || report.AddedRemote != 1 || report.AddedLocal != 1 {
+		t.Errorf("expected gato deleted in Anki, perro added to Anki and sol added locally, got %+v", report)
+	}
+
+	fronts = remoteFronts(t, server, "Spanish")
+	if _, ok := fronts["gato"]; ok || len(fronts) != 2 {
+		t.Errorf("expected gato to be deleted in Anki, got %v", fronts)
+	}
+	if info, _ := server.NoteInfo(fronts["perro"]); info.Fields["Back"].Value != "dog, hound" {
+		t.Errorf("expected the local perro to be added again, got %+v", info)
+	}
+	backs := localBacks(t, deck)
+	if _, ok := backs["gato"]; ok || backs["sol"] != "star" || backs["perro"] != "dog, hound" {
+		t.Errorf("expected sol to come back with the edit from Anki, got %v", backs)
+	}
+
+	report, err = deck.TwoWaySync(ac, state, nil)
+	if err != nil {
+		t.Fatalf("TwoWaySync failed: %v", err)
+	}
+	if len(report.Conflicts) != 0 || report.AddedLocal != 0 || report.AddedRemote != 0 || report.DeletedLocal != 0 || report.DeletedRemote != 0 {
+		t.Errorf("expected nothing to sync, got %+v", report)
+	}
+}
+
+func TestEndToEnd_TwoWaySyncChangedNoteType(t *testing.T) {
+	server := ankitest.NewServer()
+	defer server.Close()
+	ac := server.Client()
+	deck, state := newSyncedDeck(t, server, [2]string{"gato", "cat"})
+	defer deck.Close()
+	remoteID := remoteFronts(t, server, "Spanish")["gato"]
+
+	// The note type is changed in Anki to one with other field names
+	err := server.AddModel(anki.NoteModel{
+		Name:      "Vocab",
+		Fields:    []string{"Spanish", "English"},
+		Templates: []anki.CardTemplate{{Name: "Card 1", Front: "{{Spanish}}", Back: "{{English}}"}},
+	})
+	if err != nil {
+		t.Fatalf("AddModel failed: %v", err)
+	}
+	if err := server.ChangeNoteModel(remoteID, "Vocab", map[string]string{"Spanish": "gato", "English": "cat"}); err != nil {
+		t.Fatalf("ChangeNoteModel failed: %v", err)
+	}
+
+	report, err := deck.TwoWaySync(ac, state, nil)
+	if err != nil {
+		t.Fatalf("TwoWaySync failed: %v", err)
+	}
+	if report.Pulled != 0 || report.Pushed != 0 || len(report.Conflicts) != 0 {
+		t.Errorf("expected the note to be unchanged, got %+v", report)
+	}
+	if backs := localBacks(t, deck); backs["gato"] != "cat" {
+		t.Errorf("expected the local note to keep its values, got %v", backs)
+	}
+
+	// Edits go through the note type in Anki
+	editLocal(t, deck, "gato", "cat, kitty")
+	if _, err := deck.TwoWaySync(ac, state, nil); err != nil {
+		t.Fatalf("TwoWaySync failed: %v", err)
+	}
+	if info, _ := server.NoteInfo(remoteID); info.ModelName != "Vocab" || info.Fields["English"].Value != "cat, kitty" {
+		t.Errorf("expected the edit to be pushed to the Vocab note, got %+v", info)
+	}
+	if err := server.UpdateNote(remoteID, map[string]string{"English": "feline"}); err != nil {
+		t.Fatalf("UpdateNote failed: %v", err)
+	}
+	if _, err := deck.TwoWaySync(ac, state, nil); err != nil {
+		t.Fatalf("TwoWaySync failed: %v", err)
+	}
+	if backs := localBacks(t, deck); backs["gato"] != "feline" {
+		t.Errorf("expected the edit in Anki to be pulled, got %v", backs)
+	}
+}
@@ -27,7 +27,7 @@ func (d *Deck) exportDatabase(w *bytes.Buffer) error {
 	// Get the schema from the in-memory database
 	rows, err := d.db.Query(`
 		SELECT sql FROM sqlite_master 
-		WHERE sql NOT NULL AND type IN ('table', 'index')
+		WHERE sql NOT NULL AND type IN ('table', 'index') AND name != 'remote_notes'
 		ORDER BY CASE type WHEN 'table' THEN 1 ELSE 2 END
 	`)
 	if err != nil {
@@ -50,7 +50,13 @@ func (d *Deck) exportDatabase(w *bytes.Buffer) error {
 	// Copy data from each table
 	tables := []string{"col", "notes", "cards", "revlog", "graves"}
 	for _, table := range tables {
-		if err := d.copyTableData(d.db, fileDB, table); err != nil {
+		var onRow func(done, total int)
+		if table == "notes" {
+			onRow = func(done, total int) {
+				d.progress(Progress{Phase: PhaseDatabase, Done: done, Total: total})
+			}
+		}
+		if err := d.copyTableData(d.db, fileDB, table, onRow); err != nil {
 			// Some tables might be empty, that's OK
 			continue
 		}
@@ -69,8 +75,9 @@ func (d *Deck) exportDatabase(w *bytes.Buffer) error {
 	return err
 }
 
-// copyTableData copies all data from a table in the source database to the destination
-func (d *Deck) copyTableData(srcDB, destDB *sql.DB, tableName string) error {
+// copyTableData copies all data from a table in the source database to the destination.
+// onRow, if not nil, is called with the number of rows copied so far.
+func (d *Deck) copyTableData(srcDB, destDB *sql.DB, tableName string, onRow func(done, total int)) error {
 	// First, check if the table exists and has data
 	var count int
 	err := srcDB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", tableName)).Scan(&count)
@@ -112,6 +119,7 @@ func (d *Deck) copyTableData(srcDB, destDB *sql.DB, tableName string) error {
 		valuePtrs[i] = &values[i]
 	}
 
+	done := 0
 	for rows.Next() {
 		if err := rows.Scan(valuePtrs...); err != nil {
 			return err
@@ -119,6 +127,10 @@ func (d *Deck) copyTableData(srcDB, destDB *sql.DB, tableName string) error {
 		if _, err := stmt.Exec(values...); err != nil {
 			return err
 		}
+		done++
+		if onRow != nil {
+			onRow(done, count)
+		}
 	}
 
 	return rows.Err()
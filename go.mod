@@ -2,4 +2,4 @@ module github.com/ezynda3/go-anki-deck
 
 go 1.23.10
 
-require github.com/mattn/go-sqlite3 v1.14.28 // indirect
+require github.com/mattn/go-sqlite3 v1.14.28
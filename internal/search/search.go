@@ -0,0 +1,537 @@
+// Package search parses Anki search queries and matches notes and their cards
+// against them. It is shared by the anki package and the ankitest emulator.
+package search
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query is a parsed Anki search query
+type Query struct {
+	expr searchNode
+}
+
+// Parse parses an Anki search query.
+//
+// Supported syntax: plain text with * and _ wildcards, "quoted phrases",
+// field:value, tag:, deck:, note:, card:, nid:, cid:, is:new, is:learn,
+// is:review, is:suspended, is:buried and added:n, combined with implicit
+// and, explicit and/or, -negation and parentheses.
+func Parse(query string) (*Query, error) {
+	expr, err := parseSearch(query)
+	if err != nil {
+		return nil, err
+	}
+	return &Query{expr: expr}, nil
+}
+
+// Match reports whether the target matches the query
+func (q *Query) Match(t *Target) bool {
+	return q.expr.match(t)
+}
+
+// Target is a note and one of its cards being matched against a query
+type Target struct {
+	NoteID        int64
+	Fields        []string
+	FieldNames    []string
+	Tags          []string
+	ModelName     string
+	TemplateNames []string // Template names by ord
+	Card          *Card    // nil for a note without cards
+	DeckName      string   // Deck of the card
+	Now           time.Time
+}
+
+// Card is the part of a card queries match against
+type Card struct {
+	ID    int64
+	Ord   int
+	Type  int
+	Queue int
+}
+
+type searchNode interface {
+	match(t *Target) bool
+}
+
+type andNode []searchNode
+
+func (n andNode) match(t *Target) bool {
+	for _, child := range n {
+		if !child.match(t) {
+			return false
+		}
+	}
+	return true
+}
+
+type orNode []searchNode
+
+func (n orNode) match(t *Target) bool {
+	for _, child := range n {
+		if child.match(t) {
+			return true
+		}
+	}
+	return false
+}
+
+type notNode struct {
+	node searchNode
+}
+
+func (n notNode) match(t *Target) bool {
+	return !n.node.match(t)
+}
+
+type matchFunc func(t *Target) bool
+
+func (f matchFunc) match(t *Target) bool {
+	return f(t)
+}
+
+type searchTokenKind int
+
+const (
+	tokenTerm searchTokenKind = iota
+	tokenOpen
+	tokenClose
+	tokenNot
+)
+
+type searchToken struct {
+	kind   searchTokenKind
+	text   string
+	quoted bool
+}
+
+// isKeyword reports whether the token is the unquoted operator word
+func (t searchToken) isKeyword(word string) bool {
+	return t.kind == tokenTerm && !t.quoted && strings.EqualFold(t.text, word)
+}
+
+// parseSearch parses an Anki search query into an expression tree
+func parseSearch(query string) (searchNode, error) {
+	tokens, err := tokenizeSearch(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return andNode{}, nil
+	}
+
+	p := &searchParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid search: unexpected ')'")
+	}
+	return node, nil
+}
+
+func tokenizeSearch(query string) ([]searchToken, error) {
+	var tokens []searchToken
+	runes := []rune(query)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, searchToken{kind: tokenOpen})
+			i++
+		case r == ')':
+			tokens = append(tokens, searchToken{kind: tokenClose})
+			i++
+		case r == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) && runes[i+1] != ')':
+			tokens = append(tokens, searchToken{kind: tokenNot})
+			i++
+		default:
+			token, next, err := readSearchTerm(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token)
+			i = next
+		}
+	}
+
+	return tokens, nil
+}
+
+// readSearchTerm reads a single term starting at runes[start].
+// Quotes group text containing spaces and are removed; backslash escapes
+// are kept so wildcard characters can still be escaped later.
+func readSearchTerm(runes []rune, start int) (searchToken, int, error) {
+	var b strings.Builder
+	token := searchToken{kind: tokenTerm}
+	inQuotes := false
+
+	i := start
+	for ; i < len(runes); i++ {
+		r := runes[i]
+		if r == '\\' && i+1 < len(runes) {
+			i++
+			if runes[i] != '"' {
+				b.WriteRune('\\')
+			}
+			b.WriteRune(runes[i])
+			continue
+		}
+		if r == '"' {
+			inQuotes = !inQuotes
+			token.quoted = true
+			continue
+		}
+		if !inQuotes && (unicode.IsSpace(r) || r == '(' || r == ')') {
+			break
+		}
+		b.WriteRune(r)
+	}
+
+	if inQuotes {
+		return searchToken{}, 0, fmt.Errorf("invalid search: unterminated quote")
+	}
+
+	token.text = b.String()
+	return token, i, nil
+}
+
+type searchParser struct {
+	tokens []searchToken
+	pos    int
+}
+
+// parseOr parses terms joined by "or", which binds looser than "and"
+func (p *searchParser) parseOr() (searchNode, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := orNode{first}
+	for p.pos < len(p.tokens) && p.tokens[p.pos].isKeyword("or") {
+		p.pos++
+		next, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, next)
+	}
+
+	if len(nodes) == 1 {
+		return first, nil
+	}
+	return nodes, nil
+}
+
+// parseAnd parses a sequence of terms joined implicitly or by "and"
+func (p *searchParser) parseAnd() (searchNode, error) {
+	var nodes andNode
+	for p.pos < len(p.tokens) {
+		token := p.tokens[p.pos]
+		if token.kind == tokenClose || token.isKeyword("or") {
+			break
+		}
+		if token.isKeyword("and") {
+			p.pos++
+			continue
+		}
+
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("invalid search: expected a search term")
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *searchParser) parseUnary() (searchNode, error) {
+	token := p.tokens[p.pos]
+	p.pos++
+
+	switch token.kind {
+	case tokenNot:
+		if p.pos >= len(p.tokens) {
+			return nil, fmt.Errorf("invalid search: expected a term after '-'")
+		}
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node: node}, nil
+	case tokenOpen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenClose {
+			return nil, fmt.Errorf("invalid search: missing ')'")
+		}
+		p.pos++
+		return node, nil
+	case tokenClose:
+		return nil, fmt.Errorf("invalid search: unexpected ')'")
+	default:
+		return compileSearchTerm(token.text)
+	}
+}
+
+// compileSearchTerm turns a single term such as "tag:verb" into a matcher
+func compileSearchTerm(term string) (searchNode, error) {
+	key, value, qualified := splitSearchTerm(term)
+	if !qualified {
+		return compileTextSearch(term)
+	}
+
+	switch strings.ToLower(key) {
+	case "tag":
+		return compileTagSearch(value)
+	case "deck":
+		return compileDeckSearch(value)
+	case "note":
+		re, err := wildcardRegexp(value, true, "")
+		if err != nil {
+			return nil, err
+		}
+		return matchFunc(func(t *Target) bool {
+			return re.MatchString(t.ModelName)
+		}), nil
+	case "card":
+		return compileCardSearch(value)
+	case "is":
+		return compileStateSearch(value)
+	case "added":
+		return compileAddedSearch(value)
+	case "nid":
+		ids, err := parseSearchIDs(value)
+		if err != nil {
+			return nil, err
+		}
+		return matchFunc(func(t *Target) bool {
+			return ids[t.NoteID]
+		}), nil
+	case "cid":
+		ids, err := parseSearchIDs(value)
+		if err != nil {
+			return nil, err
+		}
+		return matchFunc(func(t *Target) bool {
+			return t.Card != nil && ids[t.Card.ID]
+		}), nil
+	default:
+		return compileFieldSearch(key, value)
+	}
+}
+
+// splitSearchTerm splits "key:value" at the first unescaped colon
+func splitSearchTerm(term string) (string, string, bool) {
+	for i := 0; i < len(term); i++ {
+		switch term[i] {
+		case '\\':
+			i++
+		case ':':
+			if i == 0 {
+				return "", term, false
+			}
+			return term[:i], term[i+1:], true
+		}
+	}
+	return "", term, false
+}
+
+func compileTextSearch(text string) (searchNode, error) {
+	re, err := wildcardRegexp(text, false, "")
+	if err != nil {
+		return nil, err
+	}
+	return matchFunc(func(t *Target) bool {
+		for _, field := range t.Fields {
+			if re.MatchString(field) {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+func compileFieldSearch(name, value string) (searchNode, error) {
+	nameRe, err := wildcardRegexp(name, true, "")
+	if err != nil {
+		return nil, err
+	}
+	valueRe, err := wildcardRegexp(value, true, "")
+	if err != nil {
+		return nil, err
+	}
+	return matchFunc(func(t *Target) bool {
+		for i, fieldName := range t.FieldNames {
+			if i < len(t.Fields) && nameRe.MatchString(fieldName) && valueRe.MatchString(t.Fields[i]) {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+func compileTagSearch(value string) (searchNode, error) {
+	if strings.EqualFold(value, "none") {
+		return matchFunc(func(t *Target) bool {
+			return len(t.Tags) == 0
+		}), nil
+	}
+
+	// tag:a also matches the child tags a::b
+	re, err := wildcardRegexp(value, true, "(::.*)?")
+	if err != nil {
+		return nil, err
+	}
+	return matchFunc(func(t *Target) bool {
+		for _, tag := range t.Tags {
+			if re.MatchString(tag) {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+func compileDeckSearch(value string) (searchNode, error) {
+	// deck:a also matches the subdecks a::b
+	re, err := wildcardRegexp(value, true, "(::.*)?")
+	if err != nil {
+		return nil, err
+	}
+	return matchFunc(func(t *Target) bool {
+		return t.Card != nil && re.MatchString(t.DeckName)
+	}), nil
+}
+
+func compileCardSearch(value string) (searchNode, error) {
+	if n, err := strconv.Atoi(value); err == nil {
+		return matchFunc(func(t *Target) bool {
+			return t.Card != nil && t.Card.Ord == n-1
+		}), nil
+	}
+
+	re, err := wildcardRegexp(value, true, "")
+	if err != nil {
+		return nil, err
+	}
+	return matchFunc(func(t *Target) bool {
+		return t.Card != nil && t.Card.Ord >= 0 && t.Card.Ord < len(t.TemplateNames) &&
+			re.MatchString(t.TemplateNames[t.Card.Ord])
+	}), nil
+}
+
+func compileStateSearch(value string) (searchNode, error) {
+	var match func(c *Card) bool
+	switch strings.ToLower(value) {
+	case "new":
+		match = func(c *Card) bool { return c.Type == 0 }
+	case "learn":
+		match = func(c *Card) bool { return c.Queue == 1 || c.Queue == 3 }
+	case "review":
+		match = func(c *Card) bool { return c.Type == 2 || c.Type == 3 }
+	case "suspended":
+		match = func(c *Card) bool { return c.Queue == -1 }
+	case "buried":
+		match = func(c *Card) bool { return c.Queue == -2 || c.Queue == -3 }
+	default:
+		return nil, fmt.Errorf("invalid search: unsupported is:%s", value)
+	}
+
+	return matchFunc(func(t *Target) bool {
+		return t.Card != nil && match(t.Card)
+	}), nil
+}
+
+// compileAddedSearch matches cards created in the last n days.
+// Card IDs are creation timestamps in milliseconds; days start at local midnight.
+func compileAddedSearch(value string) (searchNode, error) {
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 1 {
+		return nil, fmt.Errorf("invalid search: added:%s", value)
+	}
+
+	return matchFunc(func(t *Target) bool {
+		y, m, d := t.Now.Date()
+		cutoff := time.Date(y, m, d, 0, 0, 0, 0, t.Now.Location()).AddDate(0, 0, -(days - 1))
+		created := t.NoteID
+		if t.Card != nil {
+			created = t.Card.ID
+		}
+		return created >= cutoff.UnixMilli()
+	}), nil
+}
+
+func parseSearchIDs(value string) (map[int64]bool, error) {
+	ids := make(map[int64]bool)
+	for _, part := range strings.Split(value, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid search: bad id %q", part)
+		}
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// wildcardRegexp converts an Anki search pattern into a case-insensitive
+// regular expression: * matches any text, _ a single character, and a
+// backslash makes the next character literal. Anchored patterns must match
+// the whole string, followed by the optional suffix expression.
+func wildcardRegexp(pattern string, anchored bool, suffix string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("(?is)")
+	if anchored {
+		b.WriteString("^")
+	}
+
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(runes[i])))
+			} else {
+				b.WriteString(`\\`)
+			}
+		case '*':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	if anchored {
+		b.WriteString(suffix)
+		b.WriteString("$")
+	}
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid search: %w", err)
+	}
+	return re, nil
+}
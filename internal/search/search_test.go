@@ -0,0 +1,44 @@
+package search
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuery_Match(t *testing.T) {
+	target := &Target{
+		NoteID:        1,
+		Fields:        []string{"perro", "dog"},
+		FieldNames:    []string{"Word", "Meaning"},
+		Tags:          []string{"animals::pets"},
+		ModelName:     "Vocab",
+		TemplateNames: []string{"Recognition", "Recall"},
+		Card:          &Card{ID: 2, Ord: 1, Queue: -1},
+		DeckName:      "Spanish",
+		Now:           time.Now(),
+	}
+
+	tests := map[string]bool{
+		"":                            true,
+		"word:perro tag:animals":      true,
+		"note:vocab card:recall":      true,
+		"deck:Spanish is:suspended":   true,
+		"card:Recognition":            false,
+		"meaning:cat or deck:French":  false,
+		"-(is:new cat)":               true,
+		`"deck:Spanish::Verbs" perro`: false,
+	}
+	for query, want := range tests {
+		q, err := Parse(query)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", query, err)
+		}
+		if got := q.Match(target); got != want {
+			t.Errorf("Match(%q) = %v, want %v", query, got, want)
+		}
+	}
+
+	if _, err := Parse("(dog"); err == nil {
+		t.Error("expected an error for an invalid query")
+	}
+}
@@ -0,0 +1,37 @@
+// Package text implements Anki's handling of field text and card templates:
+// stripping HTML, deciding which cards a note gets and rendering them. It is
+// shared by the anki package and the ankitest emulator.
+package text
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// The expressions below follow Anki's own text handling (rslib/src/text.rs),
+// so sort fields and checksums match what Anki computes for the same note.
+var (
+	htmlRegexp = regexp.MustCompile(`(?si)(<!--.*?-->)|(<style.*?>.*?</style>)|(<script.*?>.*?</script>)|(<.*?>)`)
+
+	htmlMediaTagRegexp = regexp.MustCompile(`(?si)<\b(?:img|audio|video|object)\b(?:[^>"']|"[^"]*?"|'[^']*?')*?\b(?:src|data)\b=(?:"([^"]+?)"|'([^']+?)'|([^ >]+))(?:[^>"']|"[^"]*?"|'[^']*?')*?>`)
+)
+
+// StripHTML removes HTML tags, comments, styles and scripts and decodes entities
+func StripHTML(s string) string {
+	return DecodeEntities(htmlRegexp.ReplaceAllString(s, ""))
+}
+
+// StripHTMLMedia strips HTML like StripHTML but keeps the filenames of media
+// tags. Anki compares first fields this way to find duplicates.
+func StripHTMLMedia(s string) string {
+	return StripHTML(htmlMediaTagRegexp.ReplaceAllString(s, " ${1}${2}${3} "))
+}
+
+// DecodeEntities decodes HTML entities, reading &nbsp; as a plain space
+func DecodeEntities(s string) string {
+	if !strings.Contains(s, "&") {
+		return s
+	}
+	return html.UnescapeString(strings.ReplaceAll(s, "&nbsp;", " "))
+}
@@ -0,0 +1,27 @@
+package text
+
+import "testing"
+
+func TestStripHTMLMedia(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"hello", "hello"},
+		{"<b>hello</b>", "hello"},
+		{"hello&nbsp;world", "hello world"},
+		{"a &lt; b", "a < b"},
+		{`<img src="cat.jpg">`, " cat.jpg "},
+		{`<img class="x" src='cat.jpg' alt="a > b">`, " cat.jpg "},
+		{"<img src=cat.jpg>", " cat.jpg "},
+		{`<audio controls src="a.mp3"></audio>`, " a.mp3 "},
+		{"Paris [sound:paris.mp3]", "Paris [sound:paris.mp3]"},
+		{"<!-- note -->text<style>.a{}</style><script>x()</script>", "text"},
+		{"<div>line<br>break</div>", "linebreak"},
+	}
+
+	for _, tt := range tests {
+		if got := StripHTMLMedia(tt.in); got != tt.want {
+			t.Errorf("StripHTMLMedia(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
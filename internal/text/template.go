@@ -0,0 +1,180 @@
+package text
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Card templates reference fields as {{Field}}, with filters such as
+// {{type:Field}}, and show sections only for non-empty fields, {{#Field}}
+// ... {{/Field}}, or for empty ones, {{^Field}} ... {{/Field}}
+var (
+	fieldRefRegexp = regexp.MustCompile(`{{\s*([#^/]?)\s*([^}]+?)\s*}}`)
+	sectionRegexp  = regexp.MustCompile(`{{\s*([#^])\s*([^}]+?)\s*}}`)
+	clozeRegexp    = regexp.MustCompile(`(?s){{c(\d+)::(.*?)(?:::(.*?))?}}`)
+)
+
+// Model is the part of a note type that decides the cards of its notes
+type Model struct {
+	Fields    []string
+	Templates []Template
+	IsCloze   bool
+}
+
+// Template is a card template of a note type
+type Template struct {
+	Front string
+	Back  string
+}
+
+// CardOrds returns the ords of the cards Anki generates for a note with the
+// field values: one per cloze number for cloze note types, otherwise the
+// templates whose front references a non-empty field. Anki refuses to add a
+// note without cards.
+func (m Model) CardOrds(values []string) []int {
+	var ords []int
+	if m.IsCloze {
+		seen := make(map[int]bool)
+		for _, value := range values {
+			for _, match := range clozeRegexp.FindAllStringSubmatch(value, -1) {
+				n, _ := strconv.Atoi(match[1])
+				if n > 0 && !seen[n-1] {
+					seen[n-1] = true
+					ords = append(ords, n-1)
+				}
+			}
+		}
+		sort.Ints(ords)
+		return ords
+	}
+
+	for ord, t := range m.Templates {
+		for _, i := range TemplateFields(t.Front, m.Fields) {
+			if i < len(values) && strings.TrimSpace(values[i]) != "" {
+				ords = append(ords, ord)
+				break
+			}
+		}
+	}
+	return ords
+}
+
+// RenderCard returns the question and answer HTML of the card with the given
+// ord for a note with the field values. Cloze note types render every card
+// from their first template, showing only the card's own deletion as [...].
+func (m Model) RenderCard(values []string, ord int) (question, answer string) {
+	t := ord
+	if m.IsCloze {
+		t = 0
+	}
+	if t < 0 || t >= len(m.Templates) {
+		return "", ""
+	}
+
+	fields := make(map[string]string, len(m.Fields))
+	for i, name := range m.Fields {
+		if i < len(values) {
+			fields[name] = values[i]
+		}
+	}
+	question = renderTemplate(m.Templates[t].Front, fields, ord, true, "")
+	answer = renderTemplate(m.Templates[t].Back, fields, ord, false, question)
+	return question, answer
+}
+
+// renderTemplate fills a card template with field values. It handles field
+// references with filters, conditional sections, {{FrontSide}} and cloze
+// deletions of the card with the given ord.
+func renderTemplate(tmpl string, fields map[string]string, ord int, question bool, frontSide string) string {
+	for {
+		loc := sectionRegexp.FindStringSubmatchIndex(tmpl)
+		if loc == nil {
+			break
+		}
+		kind, name := tmpl[loc[2]:loc[3]], tmpl[loc[4]:loc[5]]
+		closing := "{{/" + name + "}}"
+		end := strings.Index(tmpl[loc[1]:], closing)
+		if end < 0 {
+			break
+		}
+
+		inner := tmpl[loc[1] : loc[1]+end]
+		if show := strings.TrimSpace(fields[name]) != ""; show == (kind == "^") {
+			inner = ""
+		}
+		tmpl = tmpl[:loc[0]] + inner + tmpl[loc[1]+end+len(closing):]
+	}
+
+	return fieldRefRegexp.ReplaceAllStringFunc(tmpl, func(ref string) string {
+		m := fieldRefRegexp.FindStringSubmatch(ref)
+		if m[1] != "" {
+			return ""
+		}
+		name := fieldRefName(m[2])
+		if name == "FrontSide" {
+			return frontSide
+		}
+		if strings.HasPrefix(m[2], "cloze:") {
+			return renderCloze(fields[name], ord, question)
+		}
+		return fields[name]
+	})
+}
+
+// renderCloze hides the deletions of the card on the question and shows
+// them on the answer
+func renderCloze(text string, ord int, question bool) string {
+	return clozeRegexp.ReplaceAllStringFunc(text, func(deletion string) string {
+		m := clozeRegexp.FindStringSubmatch(deletion)
+		n, _ := strconv.Atoi(m[1])
+		switch {
+		case n != ord+1:
+			return m[2]
+		case !question:
+			return `<span class="cloze">` + m[2] + `</span>`
+		case m[3] != "":
+			return `<span class="cloze">[` + m[3] + `]</span>`
+		default:
+			return `<span class="cloze">[...]</span>`
+		}
+	})
+}
+
+// TemplateFields returns the positions of the fields a template references
+func TemplateFields(template string, names []string) []int {
+	fields := []int{}
+	for _, match := range fieldRefRegexp.FindAllStringSubmatch(template, -1) {
+		if i := indexOf(names, fieldRefName(match[2])); i >= 0 && !contains(fields, i) {
+			fields = append(fields, i)
+		}
+	}
+	return fields
+}
+
+// fieldRefName returns the field of a reference without its filters
+func fieldRefName(ref string) string {
+	if i := strings.LastIndex(ref, ":"); i >= 0 {
+		ref = ref[i+1:]
+	}
+	return strings.TrimSpace(ref)
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func contains(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
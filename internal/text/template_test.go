@@ -0,0 +1,35 @@
+package text
+
+import "testing"
+
+func TestModel_RenderCard(t *testing.T) {
+	model := Model{
+		Fields: []string{"Word", "Meaning", "Example"},
+		Templates: []Template{
+			{Front: "{{Word}}{{#Example}} ({{Example}}){{/Example}}", Back: "{{FrontSide}}<hr>{{Meaning}}"},
+			{Front: "{{^Example}}none{{/Example}}{{Example}}", Back: "{{Word}}"},
+		},
+	}
+	values := []string{"perro", "dog", ""}
+	if ords := model.CardOrds(values); len(ords) != 1 || ords[0] != 0 {
+		t.Errorf("expected only the first card without an example, got %v", ords)
+	}
+	question, answer := model.RenderCard(values, 0)
+	if question != "perro" || answer != "perro<hr>dog" {
+		t.Errorf("unexpected card: %q / %q", question, answer)
+	}
+
+	cloze := Model{
+		Fields:    []string{"Text"},
+		IsCloze:   true,
+		Templates: []Template{{Front: "{{cloze:Text}}", Back: "{{cloze:Text}}"}},
+	}
+	values = []string{"{{c1::Madrid}} is in {{c2::Spain::country}}"}
+	if ords := cloze.CardOrds(values); len(ords) != 2 {
+		t.Errorf("expected a card per cloze number, got %v", ords)
+	}
+	question, answer = cloze.RenderCard(values, 1)
+	if question != `Madrid is in <span class="cloze">[country]</span>` || answer != `Madrid is in <span class="cloze">Spain</span>` {
+		t.Errorf("unexpected cloze card: %q / %q", question, answer)
+	}
+}
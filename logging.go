@@ -0,0 +1,41 @@
+package anki
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardHandler drops all records, so logging is silent unless a logger is set
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+var discardLogger = slog.New(discardHandler{})
+
+// logger returns the client's logger, or a silent one if none is set
+func (ac *AnkiConnect) logger() *slog.Logger {
+	if ac.Logger == nil {
+		return discardLogger
+	}
+	return ac.Logger
+}
+
+// SetLogger sets the logger for the deck's warnings and events. Operations
+// with AnkiConnect fall back to the client's logger. Nil makes it silent.
+func (d *Deck) SetLogger(logger *slog.Logger) {
+	d.logger = logger
+}
+
+// log returns the deck's logger, falling back to the client's
+func (d *Deck) log(client *AnkiConnect) *slog.Logger {
+	if d.logger != nil {
+		return d.logger
+	}
+	if client != nil {
+		return client.logger()
+	}
+	return discardLogger
+}
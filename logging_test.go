@@ -0,0 +1,111 @@
+package anki
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// logMessages decodes the messages of JSON log records
+func logMessages(t *testing.T, buf *bytes.Buffer) []string {
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record struct {
+			Msg string `json:"msg"`
+		}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, record.Msg)
+	}
+	return messages
+}
+
+func TestDeck_PushToAnkiWithMedia_Logging(t *testing.T) {
+	server := httptest.NewServer(withMulti(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ankiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+
+		var resp ankiResponse
+		switch req.Action {
+		case "version":
+			resp = ankiResponse{Result: float64(6), Error: ""}
+		case "modelNames":
+			resp = ankiResponse{Result: []interface{}{"Test Deck"}, Error: ""}
+		case "createDeck", "updateModelTemplates", "updateModelStyling":
+			resp = ankiResponse{Result: nil, Error: ""}
+		case "modelFieldNames":
+			resp = ankiResponse{Result: []interface{}{"Front", "Back"}, Error: ""}
+		case "modelTemplates":
+			resp = ankiResponse{Result: map[string]interface{}{
+				"Card 1": map[string]interface{}{"Front": "{{Front}}", "Back": "{{FrontSide}}<hr id=answer>{{Back}}"},
+			}, Error: ""}
+		case "modelStyling":
+			resp = ankiResponse{Result: map[string]interface{}{"css": ".card {}"}, Error: ""}
+		case "addNote":
+			resp = ankiResponse{Result: nil, Error: "cannot create note because it is a duplicate"}
+		default:
+			t.Errorf("unexpected action: %s", req.Action)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(err)
+		}
+	})))
+	defer server.Close()
+
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+	if err := deck.AddCard("Question", "[sound:missing.mp3]"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	ac := NewAnkiConnectWithURL(server.URL, WithLogger(logger))
+	if err := deck.PushToAnkiWithMedia(ac, true); err != nil {
+		t.Fatalf("PushToAnkiWithMedia failed: %v", err)
+	}
+
+	counts := make(map[string]int)
+	for _, msg := range logMessages(t, &buf) {
+		counts[msg]++
+	}
+	if counts["AnkiConnect request"] == 0 {
+		t.Error("expected request events")
+	}
+	if counts["skipped duplicate note"] != 1 {
+		t.Errorf("expected a skipped duplicate event, got %v", counts)
+	}
+	if counts["failed to sync media file"] != 1 {
+		t.Errorf("expected a media warning, got %v", counts)
+	}
+
+	// The deck's logger takes precedence over the client's
+	var deckBuf bytes.Buffer
+	buf.Reset()
+	deck.SetLogger(slog.New(slog.NewJSONHandler(&deckBuf, nil)))
+	if err := deck.PushToAnkiWithMedia(ac, true); err != nil {
+		t.Fatalf("PushToAnkiWithMedia failed: %v", err)
+	}
+	msgs := logMessages(t, &deckBuf)
+	if len(msgs) != 2 || msgs[0] != "failed to sync media file" || msgs[1] != "skipped duplicate note" {
+		t.Errorf("expected the media warning and the duplicate on the deck's logger, got %v", msgs)
+	}
+	if len(logMessages(t, &buf)) == 0 {
+		t.Error("expected request events on the client's logger")
+	}
+}
@@ -0,0 +1,175 @@
+package anki
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/ezynda3/go-anki-deck/internal/text"
+)
+
+// The expressions below find the same references as Anki's media check
+// (rslib/src/text.rs and latex.rs), plus <source> tags and CSS url().
+var (
+	soundRegexp = regexp.MustCompile(`\[sound:(.+?)\]`)
+
+	mediaTagRegexp = regexp.MustCompile(`(?si)<\b(?:img|audio|video|source|object)\b(?:[^>"']|"[^"]*?"|'[^']*?')*?\b(?:src|data)\s*=\s*(?:"([^"]+?)"|'([^']+?)'|([^ >]+))(?:[^>"']|"[^"]*?"|'[^']*?')*?>`)
+
+	cssURLRegexp = regexp.MustCompile(`(?i)\burl\(\s*(?:"([^"]+?)"|'([^']+?)'|([^)"'\s]+))\s*\)`)
+
+	latexRegexp = regexp.MustCompile(`(?s)\[latex\](.+?)\[/latex\]|\[\$\](.+?)\[/\$\]|\[\$\$\](.+?)\[/\$\$\]`)
+
+	latexNewlineRegexp = regexp.MustCompile(`(?i)<br( /)?>|<div>`)
+)
+
+// MediaReference is a media file referenced by a note field
+type MediaReference struct {
+	Filename  string
+	NoteID    int64
+	Field     int  // Index of the field containing the reference
+	Generated bool // Image Anki renders for LaTeX when it is missing
+}
+
+// MissingMediaError is returned by ValidateMedia for media references
+// without a file in the deck
+type MissingMediaError struct {
+	Missing []MediaReference
+}
+
+func (e *MissingMediaError) Error() string {
+	first := e.Missing[0]
+	if len(e.Missing) == 1 {
+		return fmt.Sprintf("media file %s referenced by note %d is not in the deck", first.Filename, first.NoteID)
+	}
+	return fmt.Sprintf("%d media references have no file in the deck, first: %s in note %d",
+		len(e.Missing), first.Filename, first.NoteID)
+}
+
+// mediaReferences returns the media files referenced by a field: sound tags,
+// the src of img, audio, video, source and object tags, CSS url() and images
+// generated for LaTeX. Each file is listed once; remote URLs are skipped.
+func mediaReferences(field string) []MediaReference {
+	var refs []MediaReference
+	seen := make(map[string]bool)
+	add := func(filename string, generated bool) {
+		filename = strings.TrimSpace(filename)
+		if filename == "" || isRemoteMedia(filename) || seen[filename] {
+			return
+		}
+		seen[filename] = true
+		refs = append(refs, MediaReference{Filename: filename, Generated: generated})
+	}
+
+	for _, m := range soundRegexp.FindAllStringSubmatch(field, -1) {
+		add(text.DecodeEntities(m[1]), false)
+	}
+	for _, m := range mediaTagRegexp.FindAllStringSubmatch(field, -1) {
+		add(decodeMediaFilename(m[1]+m[2]+m[3]), false)
+	}
+	for _, m := range cssURLRegexp.FindAllStringSubmatch(field, -1) {
+		add(decodeMediaFilename(m[1]+m[2]+m[3]), false)
+	}
+	for _, m := range latexRegexp.FindAllStringSubmatch(field, -1) {
+		var latex string
+		switch {
+		case m[1] != "":
+			latex = stripHTMLForLatex(m[1])
+		case m[2] != "":
+			latex = "$" + stripHTMLForLatex(m[2]) + "$"
+		default:
+			latex = "$$" + stripHTMLForLatex(m[3]) + "$$"
+		}
+		add(latexFilename(latex), true)
+	}
+	return refs
+}
+
+// decodeMediaFilename decodes entities and percent-encoding in a filename
+// taken from an HTML attribute
+func decodeMediaFilename(s string) string {
+	s = text.DecodeEntities(s)
+	if decoded, err := url.PathUnescape(s); err == nil {
+		return decoded
+	}
+	return s
+}
+
+// isRemoteMedia reports whether a reference points outside the media folder
+func isRemoteMedia(filename string) bool {
+	lower := strings.ToLower(filename)
+	for _, prefix := range []string{"http://", "https://", "ftp://", "file://", "data:", "//"} {
+		if strings.HasPrefix(lower, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripHTMLForLatex turns line breaks into newlines and strips other HTML,
+// as Anki does before rendering LaTeX
+func stripHTMLForLatex(s string) string {
+	return text.StripHTML(latexNewlineRegexp.ReplaceAllString(s, "\n"))
+}
+
+// latexFilename returns the name of the image Anki generates for LaTeX
+func latexFilename(latex string) string {
+	sum := sha1.Sum([]byte(latex))
+	return "latex-" + hex.EncodeToString(sum[:]) + ".png"
+}
+
+// MediaReferences returns the media files referenced by the deck's notes
+func (d *Deck) MediaReferences() ([]MediaReference, error) {
+	notes, err := d.Notes()
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []MediaReference
+	for _, note := range notes {
+		for i, field := range note.Fields {
+			for _, ref := range mediaReferences(field) {
+				ref.NoteID = note.ID
+				ref.Field = i
+				refs = append(refs, ref)
+			}
+		}
+	}
+	return refs, nil
+}
+
+// ValidateMedia checks that every media file referenced by a note is in the
+// deck, so the exported package plays and shows all media. It returns a
+// *MissingMediaError listing the references without a file.
+func (d *Deck) ValidateMedia() error {
+	missing, err := d.missingMedia()
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return &MissingMediaError{Missing: missing}
+	}
+	return nil
+}
+
+// missingMedia returns the media references without a file in the deck.
+// LaTeX images are left out as Anki generates them.
+func (d *Deck) missingMedia() ([]MediaReference, error) {
+	refs, err := d.MediaReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []MediaReference
+	for _, ref := range refs {
+		if ref.Generated {
+			continue
+		}
+		if _, ok := d.findMedia(ref.Filename); !ok {
+			missing = append(missing, ref)
+		}
+	}
+	return missing, nil
+}
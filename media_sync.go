@@ -0,0 +1,357 @@
+package anki
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MediaSyncOptions controls how media files are compared and uploaded
+type MediaSyncOptions struct {
+	// Hashes caches the content hashes of files in Anki by filename, so
+	// unchanged files don't have to be downloaded for comparison. It is
+	// updated in place; SyncState.Media can be used to keep it across runs.
+	Hashes map[string]string
+
+	// ByPath lets Anki read files kept on disk from their path instead of
+	// sending their content. Anki must run on the same machine.
+	ByPath bool
+
+	// BaseURL lets Anki download files from BaseURL followed by the
+	// filename instead of sending their content, e.g. when the deck's media
+	// is also served over HTTP. It takes precedence over ByPath.
+	BaseURL string
+}
+
+// MediaSyncReport describes the outcome of uploading media files to Anki
+type MediaSyncReport struct {
+	Uploaded  []string      // Files stored in Anki
+	Unchanged []string      // Files already in Anki with the same content
+	Failed    []*MediaError // Files that could not be compared or uploaded
+}
+
+// MediaError is the error of a single media file
+type MediaError struct {
+	Filename string
+	Err      error
+}
+
+func (e *MediaError) Error() string {
+	return fmt.Sprintf("media file %s: %v", e.Filename, e.Err)
+}
+
+func (e *MediaError) Unwrap() error {
+	return e.Err
+}
+
+// SyncMedia uploads the deck's media files that are missing in Anki or
+// whose content differs. Files that fail are listed in the report rather
+// than failing the sync.
+func (d *Deck) SyncMedia(client *AnkiConnect, opts *MediaSyncOptions) (*MediaSyncReport, error) {
+	return d.SyncMediaContext(context.Background(), client, opts)
+}
+
+// SyncMediaContext is like SyncMedia but uses ctx for cancellation and deadlines.
+func (d *Deck) SyncMediaContext(ctx context.Context, client *AnkiConnect, opts *MediaSyncOptions) (*MediaSyncReport, error) {
+	if opts == nil {
+		opts = &MediaSyncOptions{}
+	}
+
+	upload, report, err := d.planMedia(ctx, client, opts)
+	if err != nil {
+		return nil, err
+	}
+	onUpload := func(done int, bytes int64) {
+		d.progress(Progress{Phase: PhaseMedia, Done: done, Total: len(upload), Bytes: bytes})
+	}
+	if err := client.uploadMedia(ctx, upload, opts, report, onUpload); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// planMedia compares the deck's media files with the files in Anki and
+// returns the ones to upload. The report lists the unchanged files and those
+// that failed, including files referenced by notes but missing in the deck.
+func (d *Deck) planMedia(ctx context.Context, client *AnkiConnect, opts *MediaSyncOptions) ([]Media, *MediaSyncReport, error) {
+	report := &MediaSyncReport{}
+	missing, err := d.missingMedia()
+	if err != nil {
+		return nil, nil, err
+	}
+	reported := make(map[string]bool)
+	for _, ref := range missing {
+		if !reported[ref.Filename] {
+			reported[ref.Filename] = true
+			report.Failed = append(report.Failed, &MediaError{
+				Filename: ref.Filename,
+				Err:      fmt.Errorf("referenced by note %d but not in the deck", ref.NoteID),
+			})
+		}
+	}
+
+	if len(d.media) == 0 {
+		return nil, report, nil
+	}
+
+	names, err := client.GetMediaFilesNamesContext(ctx, "*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list media files: %w", err)
+	}
+	remote := make(map[string]bool, len(names))
+	for _, name := range names {
+		remote[name] = true
+	}
+
+	var upload []Media
+	for _, m := range d.media {
+		if !remote[m.Filename] {
+			upload = append(upload, m)
+			continue
+		}
+
+		hash, err := mediaHash(m)
+		if err != nil {
+			report.Failed = append(report.Failed, &MediaError{Filename: m.Filename, Err: err})
+			continue
+		}
+		if opts.Hashes != nil && opts.Hashes[m.Filename] == hash {
+			report.Unchanged = append(report.Unchanged, m.Filename)
+			continue
+		}
+
+		data, err := client.RetrieveMediaFileContext(ctx, m.Filename)
+		var apiErr *APIError
+		switch {
+		case errors.Is(err, ErrMediaNotFound):
+			upload = append(upload, m)
+			continue
+		case errors.As(err, &apiErr):
+			report.Failed = append(report.Failed, &MediaError{Filename: m.Filename, Err: err})
+			continue
+		case err != nil:
+			return nil, nil, fmt.Errorf("failed to retrieve media file %s: %w", m.Filename, err)
+		}
+
+		if hashBytes(data) != hash {
+			upload = append(upload, m)
+			continue
+		}
+		report.Unchanged = append(report.Unchanged, m.Filename)
+		if opts.Hashes != nil {
+			opts.Hashes[m.Filename] = hash
+		}
+	}
+	return upload, report, nil
+}
+
+// defaultMediaBatchBytes is the file content sent per multi request when
+// uploading media
+const defaultMediaBatchBytes = 8 << 20
+
+// uploadMedia stores media files in Anki in batches and adds the outcome of
+// each file to the report. Files are read and encoded one batch at a time,
+// and a batch holds at most MediaBatchBytes of content; larger files are sent
+// on their own. onBatch, if not nil, is called with the number of files and
+// bytes sent so far after each batch.
+func (ac *AnkiConnect) uploadMedia(ctx context.Context, media []Media, opts *MediaSyncOptions, report *MediaSyncReport, onBatch func(done int, bytes int64)) error {
+	size := ac.BatchSize
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	limit := ac.MediaBatchBytes
+	if limit <= 0 {
+		limit = defaultMediaBatchBytes
+	}
+
+	var batch []Media
+	var batchBytes, sent int64
+	done := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, err := ac.uploadMediaBatch(ctx, batch, opts, report)
+		if err != nil {
+			return fmt.Errorf("failed to store media files: %w", err)
+		}
+		done += len(batch)
+		sent += n
+		batch, batchBytes = nil, 0
+		if onBatch != nil {
+			onBatch(done, sent)
+		}
+		return nil
+	}
+
+	for _, m := range media {
+		n, err := m.uploadSize(opts)
+		if err != nil {
+			report.Failed = append(report.Failed, &MediaError{Filename: m.Filename, Err: err})
+			done++
+			continue
+		}
+		if len(batch) >= size || (len(batch) > 0 && batchBytes+n > limit) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		batch = append(batch, m)
+		batchBytes += n
+	}
+	return flush()
+}
+
+// uploadMediaBatch reads, encodes and stores one batch of media files and
+// returns the number of bytes sent
+func (ac *AnkiConnect) uploadMediaBatch(ctx context.Context, batch []Media, opts *MediaSyncOptions, report *MediaSyncReport) (int64, error) {
+	var uploads []Media
+	var hashes []string
+	var actions []MultiAction
+	var sent int64
+	for _, m := range batch {
+		params := map[string]interface{}{"filename": m.Filename}
+		hash := ""
+		switch {
+		case opts.BaseURL != "":
+			params["url"] = mediaURL(opts.BaseURL, m.Filename)
+		case m.byPath(opts.ByPath):
+			path, err := filepath.Abs(m.Path)
+			if err != nil {
+				report.Failed = append(report.Failed, &MediaError{Filename: m.Filename, Err: err})
+				continue
+			}
+			params["path"] = path
+		default:
+			data, err := m.content()
+			if err != nil {
+				report.Failed = append(report.Failed, &MediaError{Filename: m.Filename, Err: err})
+				continue
+			}
+			params["data"] = base64.StdEncoding.EncodeToString(data)
+			hash = hashBytes(data)
+			sent += int64(len(data))
+		}
+		uploads = append(uploads, m)
+		hashes = append(hashes, hash)
+		actions = append(actions, MultiAction{Action: "storeMediaFile", Params: params})
+	}
+	if len(actions) == 0 {
+		return 0, nil
+	}
+
+	results, err := ac.MultiContext(ctx, actions)
+	if err != nil {
+		return 0, err
+	}
+	for i, result := range results {
+		m := uploads[i]
+		if result.Err != nil {
+			report.Failed = append(report.Failed, &MediaError{Filename: m.Filename, Err: result.Err})
+			continue
+		}
+		report.Uploaded = append(report.Uploaded, m.Filename)
+		if opts.Hashes == nil {
+			continue
+		}
+		if hashes[i] == "" {
+			hash, err := mediaHash(m)
+			if err != nil {
+				continue
+			}
+			hashes[i] = hash
+		}
+		opts.Hashes[m.Filename] = hashes[i]
+	}
+	return sent, nil
+}
+
+// byPath reports whether the file is stored in Anki from its path rather
+// than its content
+func (m Media) byPath(allowed bool) bool {
+	return allowed && m.Data == nil && m.Path != ""
+}
+
+// mediaURL returns the URL Anki downloads a file from
+func mediaURL(baseURL, filename string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/" + url.PathEscape(filename)
+}
+
+// uploadSize returns the number of bytes uploading the file sends
+func (m Media) uploadSize(opts *MediaSyncOptions) (int64, error) {
+	switch {
+	case opts.BaseURL != "" || m.byPath(opts.ByPath):
+		return 0, nil
+	case m.Data != nil || m.Path == "":
+		return int64(len(m.Data)), nil
+	}
+	info, err := os.Stat(m.Path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// pullMedia downloads the referenced media files that are not in the deck
+// and adds the outcome of each file to the report. LaTeX images Anki has not
+// generated yet are skipped.
+func (d *Deck) pullMedia(ctx context.Context, client *AnkiConnect, refs []MediaReference, report *PullReport) error {
+	var missing []MediaReference
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		if _, ok := d.findMedia(ref.Filename); !ok && !seen[ref.Filename] {
+			seen[ref.Filename] = true
+			missing = append(missing, ref)
+		}
+	}
+
+	var received int64
+	for i, ref := range missing {
+		d.progress(Progress{Phase: PhaseMedia, Done: i, Total: len(missing), Bytes: received})
+		data, err := client.RetrieveMediaFileContext(ctx, ref.Filename)
+		var apiErr *APIError
+		switch {
+		case errors.Is(err, ErrMediaNotFound) && ref.Generated:
+			continue
+		case errors.Is(err, ErrMediaNotFound), errors.As(err, &apiErr):
+			report.MediaFailed = append(report.MediaFailed, &MediaError{Filename: ref.Filename, Err: err})
+			continue
+		case err != nil:
+			return fmt.Errorf("failed to retrieve media file %s: %w", ref.Filename, err)
+		}
+		if err := d.AddMediaFile(ref.Filename, data); err != nil {
+			report.MediaFailed = append(report.MediaFailed, &MediaError{Filename: ref.Filename, Err: err})
+			continue
+		}
+		report.Media = append(report.Media, ref.Filename)
+		received += int64(len(data))
+	}
+	if len(missing) > 0 {
+		d.progress(Progress{Phase: PhaseMedia, Done: len(missing), Total: len(missing), Bytes: received})
+	}
+	return nil
+}
+
+// mediaHash returns the content hash of a media file
+func mediaHash(m Media) (string, error) {
+	data, err := m.content()
+	if err != nil {
+		return "", err
+	}
+	return hashBytes(data), nil
+}
+
+func hashBytes(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
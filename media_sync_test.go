@@ -0,0 +1,173 @@
+package anki
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestDeck_SyncMedia(t *testing.T) {
+	remote := map[string]string{
+		"same.mp3":    "same",
+		"changed.mp3": "old",
+		"cached.mp3":  "cached",
+		"missing.mp4": "missing",
+	}
+	var retrieved []string
+	stored := make(map[string]map[string]interface{})
+	server := httptest.NewServer(withMulti(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ankiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+
+		var resp ankiResponse
+		switch req.Action {
+		case "getMediaFilesNames":
+			var names []interface{}
+			for name := range remote {
+				names = append(names, name)
+			}
+			resp = ankiResponse{Result: names, Error: ""}
+		case "retrieveMediaFile":
+			filename := req.Params.(map[string]interface{})["filename"].(string)
+			retrieved = append(retrieved, filename)
+			resp = ankiResponse{Result: base64.StdEncoding.EncodeToString([]byte(remote[filename])), Error: ""}
+		case "storeMediaFile":
+			params := req.Params.(map[string]interface{})
+			filename := params["filename"].(string)
+			if filename == "rejected.mp3" {
+				resp = ankiResponse{Result: nil, Error: "invalid file"}
+				break
+			}
+			stored[filename] = params
+			resp = ankiResponse{Result: filename, Error: ""}
+		default:
+			t.Errorf("unexpected action: %s", req.Action)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(err)
+		}
+	})))
+	defer server.Close()
+
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	deck.AddMedia("new.mp3", []byte("new"))
+	deck.AddMedia("same.mp3", []byte("same"))
+	deck.AddMedia("changed.mp3", []byte("new content"))
+	deck.AddMedia("cached.mp3", []byte("cached"))
+	deck.AddMedia("rejected.mp3", []byte("rejected"))
+	deck.media = append(deck.media,
+		Media{Filename: "missing.mp4", Path: filepath.Join(t.TempDir(), "missing.mp4")},
+		Media{Filename: "video.mp4", Path: "testdata/video.mp4"},
+	)
+
+	hashes := map[string]string{"cached.mp3": hashBytes([]byte("cached"))}
+	ac := NewAnkiConnectWithURL(server.URL)
+	report, err := deck.SyncMedia(ac, &MediaSyncOptions{Hashes: hashes, ByPath: true})
+	if err != nil {
+		t.Fatalf("SyncMedia failed: %v", err)
+	}
+
+	sort.Strings(retrieved)
+	if !equalStrings(retrieved, []string{"changed.mp3", "same.mp3"}) {
+		t.Errorf("expected only uncached files to be retrieved, got %v", retrieved)
+	}
+	if !equalStrings(report.Uploaded, []string{"new.mp3", "changed.mp3", "video.mp4"}) {
+		t.Errorf("unexpected uploaded files %v", report.Uploaded)
+	}
+	if !equalStrings(report.Unchanged, []string{"same.mp3", "cached.mp3"}) {
+		t.Errorf("unexpected unchanged files %v", report.Unchanged)
+	}
+	var failed []string
+	for _, e := range report.Failed {
+		failed = append(failed, e.Filename)
+	}
+	sort.Strings(failed)
+	if !equalStrings(failed, []string{"missing.mp4", "rejected.mp3"}) {
+		t.Errorf("unexpected failed files %v", report.Failed)
+	}
+
+	if path, _ := stored["video.mp4"]["path"].(string); !filepath.IsAbs(path) || stored["video.mp4"]["data"] != nil {
+		t.Errorf("expected video.mp4 to be stored by path, got %v", stored["video.mp4"])
+	}
+	if stored["new.mp3"]["data"] != base64.StdEncoding.EncodeToString([]byte("new")) {
+		t.Errorf("expected new.mp3 to be stored with its content, got %v", stored["new.mp3"])
+	}
+	if hashes["same.mp3"] != hashBytes([]byte("same")) || hashes["new.mp3"] != hashBytes([]byte("new")) {
+		t.Errorf("expected hashes of synced files to be cached, got %v", hashes)
+	}
+}
+
+func TestAnkiConnect_UploadMediaBatchBytes(t *testing.T) {
+	var batches []int
+	files := withMulti(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ankiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		filename := req.Params.(map[string]interface{})["filename"].(string)
+		if err := json.NewEncoder(w).Encode(ankiResponse{Result: filename}); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var req struct {
+			Params struct {
+				Actions []json.RawMessage `json:"actions"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Fatal(err)
+		}
+		batches = append(batches, len(req.Params.Actions))
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		files.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	ac.MediaBatchBytes = 10
+	media := []Media{
+		{Filename: "a.mp3", Data: []byte("aaaa")},
+		{Filename: "b.mp3", Data: []byte("bbbb")},
+		{Filename: "large.mp4", Data: []byte("larger than the limit")},
+		{Filename: "c.mp3", Data: []byte("cccc")},
+	}
+	report := &MediaSyncReport{}
+	var done []int
+	err := ac.uploadMedia(context.Background(), media, &MediaSyncOptions{}, report, func(n int, _ int64) {
+		done = append(done, n)
+	})
+	if err != nil {
+		t.Fatalf("uploadMedia failed: %v", err)
+	}
+
+	if len(batches) != 3 || batches[0] != 2 || batches[1] != 1 || batches[2] != 1 {
+		t.Errorf("expected batches of 2, 1 and 1 files, got %v", batches)
+	}
+	if len(done) != 3 || done[2] != len(media) {
+		t.Errorf("unexpected progress %v", done)
+	}
+	if len(report.Uploaded) != len(media) {
+		t.Errorf("expected all files to be uploaded, got %v", report.Uploaded)
+	}
+}
@@ -0,0 +1,71 @@
+package anki
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMediaReferences(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"no media", nil},
+		{"[sound:a.mp3] and [sound:b.mp3]", []string{"a.mp3", "b.mp3"}},
+		{`<img src="a.jpg"><img src='b.jpg'><img src=c.jpg>`, []string{"a.jpg", "b.jpg", "c.jpg"}},
+		{`<img alt="x > y" class='pic' src="cat.jpg" width=10>`, []string{"cat.jpg"}},
+		{`<video controls><source src="clip.mp4" type="video/mp4"></video>`, []string{"clip.mp4"}},
+		{`<audio src="a.mp3"></audio><video src="v.webm"></video>`, []string{"a.mp3", "v.webm"}},
+		{`<object data="doc.svg"></object>`, []string{"doc.svg"}},
+		{`<div style="background: url('bg.png')">x</div>`, []string{"bg.png"}},
+		{`<span style="background-image:url(dots.gif)"></span>`, []string{"dots.gif"}},
+		{`<img src="my%20cat.jpg"> <img src="a&amp;b.jpg">`, []string{"my cat.jpg", "a&b.jpg"}},
+		{`<img src="https://example.com/x.jpg"><img src="data:image/png;base64,AA==">`, nil},
+		{"[sound:a.mp3] [sound:a.mp3] <img src=a.mp3>", []string{"a.mp3"}},
+		{"[latex]x^2[/latex]", []string{latexFilename("x^2")}},
+		{"[$]x<br>y[/$] [$$]z[/$$]", []string{latexFilename("$x\ny$"), latexFilename("$$z$$")}},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, ref := range mediaReferences(tt.in) {
+			got = append(got, ref.Filename)
+		}
+		if !equalStrings(got, tt.want) {
+			t.Errorf("mediaReferences(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDeck_ValidateMedia(t *testing.T) {
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	deck.AddMedia("cat.jpg", []byte("cat"))
+	if err := deck.AddCard(`<img src="cat.jpg">`, "[sound:meow.mp3] [latex]x^2[/latex]"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+
+	err = deck.ValidateMedia()
+	var missingErr *MissingMediaError
+	if !errors.As(err, &missingErr) {
+		t.Fatalf("expected *MissingMediaError, got %v", err)
+	}
+	if len(missingErr.Missing) != 1 || missingErr.Missing[0].Filename != "meow.mp3" || missingErr.Missing[0].Field != 1 {
+		t.Errorf("expected only meow.mp3 in the back to be missing, got %+v", missingErr.Missing)
+	}
+	if _, err := deck.Save(); !errors.As(err, &missingErr) {
+		t.Errorf("expected Save to validate media, got %v", err)
+	}
+
+	deck.AddMedia("meow.mp3", []byte("meow"))
+	if err := deck.ValidateMedia(); err != nil {
+		t.Errorf("expected all media to be present, got %v", err)
+	}
+	if _, err := deck.Save(); err != nil {
+		t.Errorf("Save failed: %v", err)
+	}
+}
@@ -0,0 +1,432 @@
+package anki
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ezynda3/go-anki-deck/internal/text"
+)
+
+// noteModel is the subset of a note type definition read by the package
+type noteModel struct {
+	ID        int64           `json:"id"`
+	Name      string          `json:"name"`
+	Type      int             `json:"type"` // 0 = standard, 1 = cloze
+	Sortf     int             `json:"sortf"`
+	CSS       string          `json:"css"`
+	Fields    []modelField    `json:"flds"`
+	Templates []modelTemplate `json:"tmpls"`
+	Mod       int64           `json:"mod"` // Time of the last change in seconds
+}
+
+// modelField describes a single field of a note type
+type modelField struct {
+	Name string `json:"name"`
+	Ord  int    `json:"ord"`
+}
+
+// modelTemplate describes a card template of a note type
+type modelTemplate struct {
+	Name string `json:"name"`
+	Ord  int    `json:"ord"`
+	Qfmt string `json:"qfmt"`
+	Afmt string `json:"afmt"`
+}
+
+// fieldNames returns the model's field names in field order
+func (m noteModel) fieldNames() []string {
+	names := make([]string, len(m.Fields))
+	for _, f := range m.Fields {
+		if f.Ord >= 0 && f.Ord < len(names) {
+			names[f.Ord] = f.Name
+		}
+	}
+	return names
+}
+
+// changedSince reports whether the model was changed after the sync at ls, in
+// milliseconds. Changes are stored in seconds, so one made in the same second
+// as the sync counts as later.
+func (m noteModel) changedSince(ls int64) bool {
+	return m.Mod >= ls/1000
+}
+
+// cardTemplates returns the model's card templates in AnkiConnect's format
+func (m noteModel) cardTemplates() []CardTemplate {
+	templates := make([]CardTemplate, len(m.Templates))
+	for i, t := range m.Templates {
+		templates[i] = CardTemplate{Name: t.Name, Front: t.Qfmt, Back: t.Afmt}
+	}
+	return templates
+}
+
+// deckModel returns the note type of the deck's cards
+func (d *Deck) deckModel() (noteModel, error) {
+	models, err := d.loadModels()
+	if err != nil {
+		return noteModel{}, err
+	}
+	model, ok := models[d.topModelID]
+	if !ok {
+		return noteModel{}, fmt.Errorf("model %d not found", d.topModelID)
+	}
+	return model, nil
+}
+
+// loadModels reads the note types stored in the collection
+func (d *Deck) loadModels() (map[int64]noteModel, error) {
+	var modelsJSON string
+	if err := d.db.QueryRow("SELECT models FROM col WHERE id = 1").Scan(&modelsJSON); err != nil {
+		return nil, fmt.Errorf("failed to query models: %w", err)
+	}
+
+	var models map[string]noteModel
+	if err := json.Unmarshal([]byte(modelsJSON), &models); err != nil {
+		return nil, fmt.Errorf("failed to parse models: %w", err)
+	}
+
+	byID := make(map[int64]noteModel, len(models))
+	for _, m := range models {
+		byID[m.ID] = m
+	}
+	return byID, nil
+}
+
+// loadDeckNames reads the names of the decks stored in the collection
+func (d *Deck) loadDeckNames() (map[int64]string, error) {
+	var decksJSON string
+	if err := d.db.QueryRow("SELECT decks FROM col WHERE id = 1").Scan(&decksJSON); err != nil {
+		return nil, fmt.Errorf("failed to query decks: %w", err)
+	}
+
+	var decks map[string]struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(decksJSON), &decks); err != nil {
+		return nil, fmt.Errorf("failed to parse decks: %w", err)
+	}
+
+	names := make(map[int64]string, len(decks))
+	for _, deck := range decks {
+		names[deck.ID] = deck.Name
+	}
+	return names, nil
+}
+
+// toNoteModel returns the note type in AnkiConnect's format
+func (m noteModel) toNoteModel() NoteModel {
+	return NoteModel{
+		Name:      m.Name,
+		Fields:    m.fieldNames(),
+		CSS:       m.CSS,
+		IsCloze:   m.Type == 1,
+		Templates: m.cardTemplates(),
+	}
+}
+
+// templateNames returns the names of the model's templates by ord
+func (m noteModel) templateNames() []string {
+	names := make([]string, len(m.Templates))
+	for _, t := range m.Templates {
+		if t.Ord >= 0 && t.Ord < len(names) {
+			names[t.Ord] = t.Name
+		}
+	}
+	return names
+}
+
+// textModel returns the fields and templates that decide the cards of the
+// model's notes
+func (m noteModel) textModel() text.Model {
+	templates := make([]text.Template, len(m.Templates))
+	for i, t := range m.cardTemplates() {
+		templates[i] = text.Template{Front: t.Front, Back: t.Back}
+	}
+	return text.Model{Fields: m.fieldNames(), Templates: templates, IsCloze: m.Type == 1}
+}
+
+// cardOrds returns the cards a note gets for the field values, see
+// text.Model.CardOrds. A note always gets the first card.
+func (m noteModel) cardOrds(values []string) []int {
+	ords := m.textModel().CardOrds(values)
+	if len(ords) == 0 {
+		return []int{0}
+	}
+	return ords
+}
+
+// importModel creates or updates the local note type named like a note type
+// in Anki so it has the same fields, templates and styling. Notes of an
+// existing note type keep the values of the fields that remain, and of fields
+// renamed without changing the number of fields. It returns an error instead
+// of dropping a removed field that notes still have values in.
+func (d *Deck) importModel(remote NoteModel) (noteModel, error) {
+	var modelsJSON string
+	if err := d.db.QueryRow("SELECT models FROM col WHERE id = 1").Scan(&modelsJSON); err != nil {
+		return noteModel{}, fmt.Errorf("failed to query models: %w", err)
+	}
+	var models map[string]map[string]interface{}
+	if err := json.Unmarshal([]byte(modelsJSON), &models); err != nil {
+		return noteModel{}, fmt.Errorf("failed to parse models: %w", err)
+	}
+
+	var key string
+	for k, m := range models {
+		if m["name"] == remote.Name {
+			key = k
+			break
+		}
+	}
+
+	var oldFields []string
+	var mapping []int
+	model := models[key]
+	if model == nil {
+		// New note types start as a copy of the deck's own
+		base, err := json.Marshal(models[strconv.FormatInt(d.topModelID, 10)])
+		if err != nil {
+			return noteModel{}, err
+		}
+		if err := json.Unmarshal(base, &model); err != nil || model == nil {
+			return noteModel{}, fmt.Errorf("model %d not found", d.topModelID)
+		}
+		id := time.Now().UnixMilli()
+		for models[strconv.FormatInt(id, 10)] != nil {
+			id++
+		}
+		key = strconv.FormatInt(id, 10)
+		model["id"] = id
+		model["name"] = remote.Name
+		// Only the deck's own note type belongs to its deck, which is how
+		// a working deck finds it when reopened
+		model["did"] = 1
+	} else {
+		for _, f := range jsonObjects(model["flds"]) {
+			name, _ := f["name"].(string)
+			oldFields = append(oldFields, name)
+		}
+		if !equalStrings(oldFields, remote.Fields) {
+			var dropped []int
+			mapping, dropped = fieldMapping(oldFields, remote.Fields)
+			mid, _ := strconv.ParseInt(key, 10, 64)
+			if err := d.checkDroppedFields(mid, remote.Name, oldFields, dropped); err != nil {
+				return noteModel{}, err
+			}
+		}
+	}
+
+	model["flds"] = mergeNamed(model["flds"], remote.Fields, func(entry map[string]interface{}, i int) {
+		entry["name"] = remote.Fields[i]
+	})
+	model["tmpls"] = mergeNamed(model["tmpls"], templateNames(remote.Templates), func(entry map[string]interface{}, i int) {
+		entry["name"] = remote.Templates[i].Name
+		entry["qfmt"] = remote.Templates[i].Front
+		entry["afmt"] = remote.Templates[i].Back
+	})
+	if sortf, _ := model["sortf"].(float64); int(sortf) >= len(remote.Fields) {
+		model["sortf"] = 0
+	}
+	model["css"] = remote.CSS
+	model["mod"] = time.Now().Unix()
+	if remote.IsCloze {
+		model["type"] = 1
+		delete(model, "req")
+	} else {
+		model["type"] = 0
+		model["req"] = templateRequirements(remote)
+	}
+	models[key] = model
+
+	updatedJSON, err := json.Marshal(models)
+	if err != nil {
+		return noteModel{}, err
+	}
+	if _, err := d.db.Exec("UPDATE col SET models = ? WHERE id = 1", string(updatedJSON)); err != nil {
+		return noteModel{}, fmt.Errorf("failed to update models: %w", err)
+	}
+	d.sortFields, d.fieldCounts = nil, nil
+
+	id, _ := strconv.ParseInt(key, 10, 64)
+	if mapping != nil {
+		if err := d.remapNoteFields(id, mapping); err != nil {
+			return noteModel{}, err
+		}
+	}
+
+	byID, err := d.loadModels()
+	if err != nil {
+		return noteModel{}, err
+	}
+	return byID[id], nil
+}
+
+// jsonObjects returns the objects of a decoded JSON array
+func jsonObjects(v interface{}) []map[string]interface{} {
+	list, _ := v.([]interface{})
+	objects := make([]map[string]interface{}, 0, len(list))
+	for _, item := range list {
+		if obj, ok := item.(map[string]interface{}); ok {
+			objects = append(objects, obj)
+		}
+	}
+	return objects
+}
+
+// mergeNamed builds the field or template list of a note type for the given
+// names in order. Entries keep the settings of the existing entry with the
+// same name, or of the first one, and set is called to fill in each entry.
+func mergeNamed(existing interface{}, names []string, set func(entry map[string]interface{}, i int)) []map[string]interface{} {
+	old := jsonObjects(existing)
+	merged := make([]map[string]interface{}, len(names))
+	for i, name := range names {
+		entry := make(map[string]interface{})
+		var base map[string]interface{}
+		for _, o := range old {
+			if o["name"] == name {
+				base = o
+				break
+			}
+		}
+		if base == nil && len(old) > 0 {
+			base = old[0]
+		}
+		for k, v := range base {
+			entry[k] = v
+		}
+		entry["ord"] = i
+		set(entry, i)
+		merged[i] = entry
+	}
+	return merged
+}
+
+func templateNames(templates []CardTemplate) []string {
+	names := make([]string, len(templates))
+	for i, t := range templates {
+		names[i] = t.Name
+	}
+	return names
+}
+
+// templateRequirements returns the req list older Anki versions use to decide
+// which cards to generate: any field referenced by the front of a template
+func templateRequirements(model NoteModel) [][]interface{} {
+	req := make([][]interface{}, len(model.Templates))
+	for ord, t := range model.Templates {
+		req[ord] = []interface{}{ord, "any", text.TemplateFields(t.Front, model.Fields)}
+	}
+	return req
+}
+
+// fieldMapping returns for each of a note type's new fields the index of the
+// old field whose values it takes, or -1, and the old fields that no new field
+// takes. Fields are matched by name; when the number of fields stayed the
+// same, the remaining fields were renamed and are matched by position.
+func fieldMapping(oldNames, newNames []string) (mapping []int, dropped []int) {
+	mapping = make([]int, len(newNames))
+	taken := make([]bool, len(oldNames))
+	for i, name := range newNames {
+		mapping[i] = indexOf(oldNames, name)
+		if mapping[i] >= 0 {
+			taken[mapping[i]] = true
+		}
+	}
+	if len(oldNames) == len(newNames) {
+		j := 0
+		for i := range mapping {
+			if mapping[i] >= 0 {
+				continue
+			}
+			for taken[j] {
+				j++
+			}
+			mapping[i] = j
+			taken[j] = true
+		}
+	}
+	for j, ok := range taken {
+		if !ok {
+			dropped = append(dropped, j)
+		}
+	}
+	return mapping, dropped
+}
+
+// mapValues returns the values in the positions given by a fieldMapping
+func mapValues(values []string, mapping []int) []string {
+	mapped := make([]string, len(mapping))
+	for i, j := range mapping {
+		if j >= 0 && j < len(values) {
+			mapped[i] = values[j]
+		}
+	}
+	return mapped
+}
+
+// checkDroppedFields returns an error if a note of the note type has a value
+// in one of the fields at the dropped positions, which would otherwise be lost
+func (d *Deck) checkDroppedFields(mid int64, modelName string, names []string, dropped []int) error {
+	if len(dropped) == 0 {
+		return nil
+	}
+	flds, err := d.modelNoteFields(mid)
+	if err != nil {
+		return err
+	}
+	for _, f := range flds {
+		values := strings.Split(f, separator)
+		for _, j := range dropped {
+			if j < len(values) && strings.TrimSpace(values[j]) != "" {
+				return fmt.Errorf("note type %q: field %q was removed or renamed in Anki but notes still have values in it", modelName, names[j])
+			}
+		}
+	}
+	return nil
+}
+
+// modelNoteFields returns the joined field values of a note type's notes by note ID
+func (d *Deck) modelNoteFields(mid int64) (map[int64]string, error) {
+	rows, err := d.db.Query("SELECT id, flds FROM notes WHERE mid = ?", mid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notes: %w", err)
+	}
+	defer rows.Close()
+	flds := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var f string
+		if err := rows.Scan(&id, &f); err != nil {
+			return nil, fmt.Errorf("failed to scan note: %w", err)
+		}
+		flds[id] = f
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read notes: %w", err)
+	}
+	return flds, nil
+}
+
+// remapNoteFields moves the values of a note type's notes to the positions
+// given by a fieldMapping after the field list changed. Like a note type
+// change in Anki, the notes keep their modification time.
+func (d *Deck) remapNoteFields(mid int64, mapping []int) error {
+	flds, err := d.modelNoteFields(mid)
+	if err != nil {
+		return err
+	}
+
+	for id, f := range flds {
+		values := mapValues(strings.Split(f, separator), mapping)
+		sfld, csum := d.sortFieldAndChecksum(mid, values)
+		_, err := d.db.Exec("UPDATE notes SET flds = ?, sfld = ?, csum = ? WHERE id = ?",
+			strings.Join(values, separator), sfld, csum, id)
+		if err != nil {
+			return fmt.Errorf("failed to update note: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package anki
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDeck_ImportModel(t *testing.T) {
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	if err := deck.AddCard("gato", "cat"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+
+	// Anki renamed Back and added a field to the deck's note type
+	model, err := deck.importModel(NoteModel{
+		Name:      "Test Deck",
+		Fields:    []string{"Front", "Notes", "Back"},
+		CSS:       ".card {}",
+		Templates: []CardTemplate{{Name: "Card 1", Front: "{{Front}}", Back: "{{Back}}"}},
+	})
+	if err != nil {
+		t.Fatalf("importModel failed: %v", err)
+	}
+	if model.ID != deck.topModelID || model.CSS != ".card {}" {
+		t.Errorf("expected the deck's note type to be updated, got %+v", model)
+	}
+	notes, err := deck.Notes()
+	if err != nil {
+		t.Fatalf("Notes failed: %v", err)
+	}
+	if got := notes[0].Fields; len(got) != 3 || got[0] != "gato" || got[1] != "" || got[2] != "cat" {
+		t.Errorf("expected the values to follow their fields, got %q", got)
+	}
+
+	cloze, err := deck.importModel(NoteModel{
+		Name:      "Cloze",
+		Fields:    []string{"Text", "Back Extra"},
+		IsCloze:   true,
+		Templates: []CardTemplate{{Name: "Cloze", Front: "{{cloze:Text}}", Back: "{{cloze:Text}}"}},
+	})
+	if err != nil {
+		t.Fatalf("importModel failed: %v", err)
+	}
+	if cloze.ID == deck.topModelID || cloze.Type != 1 {
+		t.Errorf("expected a new cloze note type, got %+v", cloze)
+	}
+	if ords := cloze.cardOrds([]string{"{{c2::a}} {{c1::b}} {{c2::c}}", ""}); len(ords) != 2 || ords[0] != 0 || ords[1] != 1 {
+		t.Errorf("expected a card per cloze number, got %v", ords)
+	}
+	if ords := model.cardOrds([]string{"", "notes", ""}); len(ords) != 1 || ords[0] != 0 {
+		t.Errorf("expected the first card for a note without a front, got %v", ords)
+	}
+}
+
+func TestDeck_ImportModelRenamedFields(t *testing.T) {
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	if err := deck.AddCard("gato", "cat"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+
+	// Anki renamed both fields
+	if _, err := deck.importModel(NoteModel{
+		Name:      "Test Deck",
+		Fields:    []string{"Spanish", "English"},
+		Templates: []CardTemplate{{Name: "Card 1", Front: "{{Spanish}}", Back: "{{English}}"}},
+	}); err != nil {
+		t.Fatalf("importModel failed: %v", err)
+	}
+	notes, err := deck.Notes()
+	if err != nil {
+		t.Fatalf("Notes failed: %v", err)
+	}
+	if got := notes[0].Fields; len(got) != 2 || got[0] != "gato" || got[1] != "cat" {
+		t.Errorf("expected renamed fields to keep their values, got %q", got)
+	}
+
+	// English was renamed and a field added: English can't be matched
+	_, err = deck.importModel(NoteModel{
+		Name:      "Test Deck",
+		Fields:    []string{"Spanish", "Meaning", "Notes"},
+		Templates: []CardTemplate{{Name: "Card 1", Front: "{{Spanish}}", Back: "{{Meaning}}"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), `"English"`) {
+		t.Fatalf("expected an error for the dropped field, got %v", err)
+	}
+	notes, err = deck.Notes()
+	if err != nil {
+		t.Fatalf("Notes failed: %v", err)
+	}
+	if got := notes[0].Fields; len(got) != 2 || got[1] != "cat" {
+		t.Errorf("expected the note to be left alone, got %q", got)
+	}
+	models, err := deck.loadModels()
+	if err != nil {
+		t.Fatalf("loadModels failed: %v", err)
+	}
+	if names := models[deck.topModelID].fieldNames(); len(names) != 2 || names[1] != "English" {
+		t.Errorf("expected the note type to be left alone, got %v", names)
+	}
+
+	// Dropping a field without values is fine
+	if err := deck.AddCard("perro", ""); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+	if _, err := deck.db.Exec("UPDATE notes SET flds = ? ", "x"+separator+""); err != nil {
+		t.Fatalf("Failed to clear fields: %v", err)
+	}
+	if _, err := deck.importModel(NoteModel{
+		Name:      "Test Deck",
+		Fields:    []string{"Spanish", "Meaning", "Notes"},
+		Templates: []CardTemplate{{Name: "Card 1", Front: "{{Spanish}}", Back: "{{Meaning}}"}},
+	}); err != nil {
+		t.Errorf("expected an empty field to be dropped, got %v", err)
+	}
+}
@@ -0,0 +1,446 @@
+package anki
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ezynda3/go-anki-deck/internal/text"
+)
+
+// ErrNoteNotFound is returned when a note does not exist in the deck
+var ErrNoteNotFound = errors.New("note not found")
+
+// Note represents a note stored in the deck together with its cards
+type Note struct {
+	ID      int64
+	GUID    string
+	ModelID int64
+	Mod     int64
+	Fields  []string
+	Tags    []string
+	Cards   []Card
+}
+
+// Card represents a card generated from a note
+type Card struct {
+	ID     int64
+	NoteID int64
+	DeckID int64
+	Ord    int
+	Type   int
+	Queue  int
+	Due    int64
+	Ivl    int
+	Factor int
+	Reps   int
+	Lapses int
+}
+
+// Notes returns all notes in the deck ordered by ID
+func (d *Deck) Notes() ([]Note, error) {
+	rows, err := d.db.Query("SELECT id, guid, mid, mod, tags, flds FROM notes ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notes: %w", err)
+	}
+
+	var notes []Note
+	index := make(map[int64]int)
+	for rows.Next() {
+		note, err := scanNote(rows)
+		if err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("failed to scan note: %w", err)
+		}
+		index[note.ID] = len(notes)
+		notes = append(notes, note)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read notes: %w", err)
+	}
+
+	cards, err := d.queryCards("SELECT id, nid, did, ord, type, queue, due, ivl, factor, reps, lapses FROM cards ORDER BY nid, ord")
+	if err != nil {
+		return nil, err
+	}
+	for _, card := range cards {
+		if i, ok := index[card.NoteID]; ok {
+			notes[i].Cards = append(notes[i].Cards, card)
+		}
+	}
+
+	return notes, nil
+}
+
+// deckNotes returns the notes that have cards in the deck
+func (d *Deck) deckNotes() ([]Note, error) {
+	notes, err := d.Notes()
+	if err != nil {
+		return nil, err
+	}
+
+	var inDeck []Note
+	for _, note := range notes {
+		for _, card := range note.Cards {
+			if card.DeckID == d.topDeckID {
+				inDeck = append(inDeck, note)
+				break
+			}
+		}
+	}
+	return inDeck, nil
+}
+
+// GetNote returns the note with the given ID
+func (d *Deck) GetNote(id int64) (*Note, error) {
+	row := d.db.QueryRow("SELECT id, guid, mid, mod, tags, flds FROM notes WHERE id = ?", id)
+	note, err := scanNote(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoteNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query note: %w", err)
+	}
+
+	note.Cards, err = d.queryCards("SELECT id, nid, did, ord, type, queue, due, ivl, factor, reps, lapses FROM cards WHERE nid = ? ORDER BY ord", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &note, nil
+}
+
+// UpdateNote stores the fields and tags of an existing note.
+// The sort field, checksum and modification time are updated to match.
+func (d *Deck) UpdateNote(note *Note) error {
+	var mid int64
+	var flds string
+	err := d.db.QueryRow("SELECT mid, flds FROM notes WHERE id = ?", note.ID).Scan(&mid, &flds)
+	if err == sql.ErrNoRows {
+		return ErrNoteNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query note: %w", err)
+	}
+
+	if fieldCount := len(strings.Split(flds, separator)); len(note.Fields) != fieldCount {
+		return fmt.Errorf("note %d has %d fields, got %d", note.ID, fieldCount, len(note.Fields))
+	}
+
+	sfld, csum := d.sortFieldAndChecksum(mid, note.Fields)
+	mod := d.getID("notes", "mod", time.Now().UnixMilli())
+
+	_, err = d.db.Exec(`
+		UPDATE notes
+		SET mod = ?, usn = -1, tags = ?, flds = ?, sfld = ?, csum = ?
+		WHERE id = ?`,
+		mod,
+		formatTags(note.Tags),
+		strings.Join(note.Fields, separator),
+		sfld,
+		csum,
+		note.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update note: %w", err)
+	}
+
+	note.Mod = mod
+	return nil
+}
+
+// DeleteNote removes a note and its cards from the deck.
+// The deletions are recorded in the graves table as Anki does.
+func (d *Deck) DeleteNote(id int64) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var exists int
+	err = tx.QueryRow("SELECT COUNT(*) FROM notes WHERE id = ?", id).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to query note: %w", err)
+	}
+	if exists == 0 {
+		return ErrNoteNotFound
+	}
+
+	rows, err := tx.Query("SELECT id FROM cards WHERE nid = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to query cards: %w", err)
+	}
+	var cardIDs []int64
+	for rows.Next() {
+		var cardID int64
+		if err := rows.Scan(&cardID); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("failed to scan card: %w", err)
+		}
+		cardIDs = append(cardIDs, cardID)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read cards: %w", err)
+	}
+
+	// Grave types: 0 = card, 1 = note
+	for _, cardID := range cardIDs {
+		if _, err := tx.Exec("INSERT INTO graves VALUES (-1, ?, 0)", cardID); err != nil {
+			return fmt.Errorf("failed to record deleted card: %w", err)
+		}
+	}
+	if _, err := tx.Exec("INSERT INTO graves VALUES (-1, ?, 1)", id); err != nil {
+		return fmt.Errorf("failed to record deleted note: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM cards WHERE nid = ?", id); err != nil {
+		return fmt.Errorf("failed to delete cards: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM notes WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete note: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM remote_notes WHERE nid = ?", id); err != nil {
+		return fmt.Errorf("failed to delete remote note ID: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanNote(row rowScanner) (Note, error) {
+	var note Note
+	var tags, flds string
+	if err := row.Scan(&note.ID, &note.GUID, &note.ModelID, &note.Mod, &tags, &flds); err != nil {
+		return Note{}, err
+	}
+	note.Tags = parseTags(tags)
+	note.Fields = strings.Split(flds, separator)
+	return note, nil
+}
+
+func (d *Deck) queryCards(query string, args ...interface{}) ([]Card, error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query cards: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var cards []Card
+	for rows.Next() {
+		var c Card
+		err := rows.Scan(&c.ID, &c.NoteID, &c.DeckID, &c.Ord, &c.Type, &c.Queue, &c.Due, &c.Ivl, &c.Factor, &c.Reps, &c.Lapses)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan card: %w", err)
+		}
+		cards = append(cards, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read cards: %w", err)
+	}
+
+	return cards, nil
+}
+
+// formatTags converts tags to Anki's space separated storage format
+func formatTags(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	formatted := make([]string, len(tags))
+	for i, tag := range tags {
+		formatted[i] = strings.ReplaceAll(tag, " ", "_")
+	}
+	return " " + strings.Join(formatted, " ") + " "
+}
+
+// parseTags splits Anki's tag storage format into individual tags
+func parseTags(tags string) []string {
+	return strings.Fields(tags)
+}
+
+// modifiedSinceSync returns the IDs of notes changed locally since the last sync.
+// As in Anki, changed notes have an update sequence number of -1.
+func (d *Deck) modifiedSinceSync() (map[int64]bool, error) {
+	rows, err := d.db.Query("SELECT id FROM notes WHERE usn = -1")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query modified notes: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	modified := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan note: %w", err)
+		}
+		modified[id] = true
+	}
+	return modified, rows.Err()
+}
+
+// markSynced marks notes as unchanged since the last sync
+func (d *Deck) markSynced(ids ...int64) error {
+	for _, id := range ids {
+		if _, err := d.db.Exec("UPDATE notes SET usn = 0 WHERE id = ?", id); err != nil {
+			return fmt.Errorf("failed to mark note %d as synced: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// createRemoteNotes creates the table recording the Anki note each local
+// note was last synced with. Anki ignores it and it is left out of exports.
+func (d *Deck) createRemoteNotes() error {
+	_, err := d.db.Exec(`
+		CREATE TABLE IF NOT EXISTS remote_notes (
+			nid integer primary key,
+			rid integer not null
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create remote notes table: %w", err)
+	}
+	return nil
+}
+
+// remoteNoteIDs returns the Anki note ID each local note was last synced
+// with, keyed by local note ID
+func (d *Deck) remoteNoteIDs() (map[int64]int64, error) {
+	rows, err := d.db.Query("SELECT nid, rid FROM remote_notes")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query remote note IDs: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	ids := make(map[int64]int64)
+	for rows.Next() {
+		var id, remoteID int64
+		if err := rows.Scan(&id, &remoteID); err != nil {
+			return nil, fmt.Errorf("failed to scan remote note ID: %w", err)
+		}
+		ids[id] = remoteID
+	}
+	return ids, rows.Err()
+}
+
+// setRemoteNoteIDs records the Anki note ID of each local note. Notes with a
+// zero Anki ID, which were not added, are skipped.
+func (d *Deck) setRemoteNoteIDs(ids, remoteIDs []int64) error {
+	for i, id := range ids {
+		if i >= len(remoteIDs) || remoteIDs[i] == 0 {
+			continue
+		}
+		if _, err := d.db.Exec("INSERT OR REPLACE INTO remote_notes (nid, rid) VALUES (?, ?)", id, remoteIDs[i]); err != nil {
+			return fmt.Errorf("failed to record remote note ID of note %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// addedNotes returns the local notes that were given an Anki note ID
+func addedNotes(ids, remoteIDs []int64) []int64 {
+	var added []int64
+	for i, id := range ids {
+		if i < len(remoteIDs) && remoteIDs[i] != 0 {
+			added = append(added, id)
+		}
+	}
+	return added
+}
+
+// markDeckSynced marks the notes of the deck as synced, except the given
+// ones, and records the sync time
+func (d *Deck) markDeckSynced(except ...int64) error {
+	query := `
+		UPDATE notes SET usn = 0 
+		WHERE usn = -1 AND id IN (SELECT nid FROM cards WHERE did = ?)`
+	args := []interface{}{d.topDeckID}
+	if len(except) > 0 {
+		query += " AND id NOT IN (?" + strings.Repeat(", ?", len(except)-1) + ")"
+		for _, id := range except {
+			args = append(args, id)
+		}
+	}
+	if _, err := d.db.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to mark notes as synced: %w", err)
+	}
+	return d.setLastSync(time.Now().UnixMilli())
+}
+
+// lastSync returns the time of the last sync in milliseconds, or 0 if never synced
+func (d *Deck) lastSync() (int64, error) {
+	var ls int64
+	if err := d.db.QueryRow("SELECT ls FROM col WHERE id = 1").Scan(&ls); err != nil {
+		return 0, fmt.Errorf("failed to query last sync time: %w", err)
+	}
+	return ls, nil
+}
+
+func (d *Deck) setLastSync(ms int64) error {
+	if _, err := d.db.Exec("UPDATE col SET ls = ? WHERE id = 1", ms); err != nil {
+		return fmt.Errorf("failed to update last sync time: %w", err)
+	}
+	return nil
+}
+
+// matchKey normalizes a field value for matching notes across collections
+func matchKey(field string) string {
+	return strings.TrimSpace(text.StripHTMLMedia(field))
+}
+
+// fieldMap pairs field names with their values
+func fieldMap(names, values []string) map[string]string {
+	fields := make(map[string]string, len(names))
+	for i, name := range names {
+		if i < len(values) {
+			fields[name] = values[i]
+		}
+	}
+	return fields
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// equalTags compares tag lists ignoring order and case, as Anki does
+func equalTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int)
+	for _, tag := range a {
+		counts[strings.ToLower(tag)]++
+	}
+	for _, tag := range b {
+		key := strings.ToLower(tag)
+		if counts[key] == 0 {
+			return false
+		}
+		counts[key]--
+	}
+	return true
+}
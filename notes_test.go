@@ -0,0 +1,181 @@
+package anki
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNotes(t *testing.T) {
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	if err := deck.AddCardWithOptions("Front 1", "Back 1", &CardOptions{Tags: []string{"one"}}); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+	if err := deck.AddCard("Front 2", "Back 2"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+
+	notes, err := deck.Notes()
+	if err != nil {
+		t.Fatalf("Notes failed: %v", err)
+	}
+	if len(notes) != 2 {
+		t.Fatalf("Expected 2 notes, got %d", len(notes))
+	}
+
+	first := notes[0]
+	if first.Fields[0] != "Front 1" || first.Fields[1] != "Back 1" {
+		t.Errorf("Unexpected fields: %v", first.Fields)
+	}
+	if len(first.Tags) != 1 || first.Tags[0] != "one" {
+		t.Errorf("Expected tags [one], got %v", first.Tags)
+	}
+	if len(first.Cards) != 1 {
+		t.Fatalf("Expected 1 card, got %d", len(first.Cards))
+	}
+	if first.Cards[0].DeckID != deck.topDeckID {
+		t.Errorf("Expected card in deck %d, got %d", deck.topDeckID, first.Cards[0].DeckID)
+	}
+	if first.GUID == "" {
+		t.Error("Expected note GUID to be set")
+	}
+}
+
+func TestGetNote(t *testing.T) {
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	if err := deck.AddCard("Front", "Back"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+	notes, err := deck.Notes()
+	if err != nil {
+		t.Fatalf("Notes failed: %v", err)
+	}
+
+	note, err := deck.GetNote(notes[0].ID)
+	if err != nil {
+		t.Fatalf("GetNote failed: %v", err)
+	}
+	if note.GUID != notes[0].GUID {
+		t.Errorf("Expected GUID %s, got %s", notes[0].GUID, note.GUID)
+	}
+	if len(note.Cards) != 1 {
+		t.Errorf("Expected 1 card, got %d", len(note.Cards))
+	}
+
+	if _, err := deck.GetNote(12345); !errors.Is(err, ErrNoteNotFound) {
+		t.Errorf("Expected ErrNoteNotFound, got %v", err)
+	}
+}
+
+func TestUpdateNote(t *testing.T) {
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	if err := deck.AddCard("Front", "Back"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+	notes, err := deck.Notes()
+	if err != nil {
+		t.Fatalf("Notes failed: %v", err)
+	}
+
+	note := notes[0]
+	note.Fields = []string{"New Front", "New Back"}
+	note.Tags = []string{"edited", "two words"}
+	if err := deck.UpdateNote(&note); err != nil {
+		t.Fatalf("UpdateNote failed: %v", err)
+	}
+
+	var flds, sfld, tags string
+	var csum int64
+	err = deck.db.QueryRow("SELECT flds, sfld, csum, tags FROM notes WHERE id = ?", note.ID).Scan(&flds, &sfld, &csum, &tags)
+	if err != nil {
+		t.Fatalf("Failed to query note: %v", err)
+	}
+	if flds != "New Front"+separator+"New Back" {
+		t.Errorf("Unexpected flds %q", flds)
+	}
+	wantSfld, wantCsum := deck.sortFieldAndChecksum(note.ModelID, note.Fields)
+	if sfld != wantSfld || csum != wantCsum {
+		t.Errorf("Expected sfld %q and csum %d, got %q and %d", wantSfld, wantCsum, sfld, csum)
+	}
+	if tags != " edited two_words " {
+		t.Errorf("Unexpected tags %q", tags)
+	}
+
+	note.Fields = []string{"Only one field"}
+	if err := deck.UpdateNote(&note); err == nil {
+		t.Error("Expected error when updating with the wrong number of fields")
+	}
+
+	missing := Note{ID: 12345, Fields: []string{"a", "b"}}
+	if err := deck.UpdateNote(&missing); !errors.Is(err, ErrNoteNotFound) {
+		t.Errorf("Expected ErrNoteNotFound, got %v", err)
+	}
+}
+
+func TestDeleteNote(t *testing.T) {
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	if err := deck.AddCard("Front 1", "Back 1"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+	if err := deck.AddCard("Front 2", "Back 2"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+	notes, err := deck.Notes()
+	if err != nil {
+		t.Fatalf("Notes failed: %v", err)
+	}
+
+	if err := deck.DeleteNote(notes[0].ID); err != nil {
+		t.Fatalf("DeleteNote failed: %v", err)
+	}
+
+	remaining, err := deck.Notes()
+	if err != nil {
+		t.Fatalf("Notes failed: %v", err)
+	}
+	if len(remaining) != 1 || remaining[0].ID != notes[1].ID {
+		t.Errorf("Expected only note %d to remain, got %v", notes[1].ID, remaining)
+	}
+
+	var cards int
+	if err := deck.db.QueryRow("SELECT COUNT(*) FROM cards WHERE nid = ?", notes[0].ID).Scan(&cards); err != nil {
+		t.Fatalf("Failed to query cards: %v", err)
+	}
+	if cards != 0 {
+		t.Errorf("Expected cards of deleted note to be removed, got %d", cards)
+	}
+
+	var noteGraves, cardGraves int
+	if err := deck.db.QueryRow("SELECT COUNT(*) FROM graves WHERE oid = ? AND type = 1", notes[0].ID).Scan(&noteGraves); err != nil {
+		t.Fatalf("Failed to query graves: %v", err)
+	}
+	if err := deck.db.QueryRow("SELECT COUNT(*) FROM graves WHERE oid = ? AND type = 0", notes[0].Cards[0].ID).Scan(&cardGraves); err != nil {
+		t.Fatalf("Failed to query graves: %v", err)
+	}
+	if noteGraves != 1 || cardGraves != 1 {
+		t.Errorf("Expected note and card graves, got %d and %d", noteGraves, cardGraves)
+	}
+
+	if err := deck.DeleteNote(notes[0].ID); !errors.Is(err, ErrNoteNotFound) {
+		t.Errorf("Expected ErrNoteNotFound, got %v", err)
+	}
+}
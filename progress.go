@@ -0,0 +1,35 @@
+package anki
+
+// ProgressPhase names the part of an operation a progress update is about
+type ProgressPhase string
+
+const (
+	PhaseDatabase ProgressPhase = "database" // Notes written to an exported collection
+	PhaseMedia    ProgressPhase = "media"    // Media files exported or uploaded to Anki
+	PhaseNotes    ProgressPhase = "notes"    // Notes pushed to or pulled from Anki
+)
+
+// Progress describes how far a long operation has got
+type Progress struct {
+	Phase ProgressPhase
+	Done  int   // Items of the phase processed so far
+	Total int   // Items in the phase
+	Bytes int64 // Media bytes written or uploaded so far in the phase
+}
+
+// ProgressFunc receives progress updates. It is called on the goroutine
+// running the operation, so it should return quickly.
+type ProgressFunc func(Progress)
+
+// SetProgress sets a function called as Save, pushes, syncs and pulls make
+// progress. Nil disables progress updates.
+func (d *Deck) SetProgress(fn ProgressFunc) {
+	d.onProgress = fn
+}
+
+// progress reports an update if a progress function is set
+func (d *Deck) progress(p Progress) {
+	if d.onProgress != nil {
+		d.onProgress(p)
+	}
+}
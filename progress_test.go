@@ -0,0 +1,125 @@
+package anki
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDeck_Save_Progress(t *testing.T) {
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	for _, q := range []string{"one", "two", "three"} {
+		if err := deck.AddCard(q, "answer"); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+	}
+	deck.AddMedia("a.mp3", []byte("12345"))
+	deck.AddMedia("b.jpg", []byte("123"))
+
+	var updates []Progress
+	deck.SetProgress(func(p Progress) {
+		updates = append(updates, p)
+	})
+	if _, err := deck.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	last := make(map[ProgressPhase]Progress)
+	for _, p := range updates {
+		if p.Done > p.Total {
+			t.Errorf("progress beyond total: %+v", p)
+		}
+		last[p.Phase] = p
+	}
+	if p := last[PhaseDatabase]; p.Done != 3 || p.Total != 3 {
+		t.Errorf("expected 3 of 3 notes exported, got %+v", p)
+	}
+	if p := last[PhaseMedia]; p.Done != 2 || p.Total != 2 || p.Bytes != 8 {
+		t.Errorf("expected 2 of 2 media files and 8 bytes, got %+v", p)
+	}
+
+	// Progress updates stop once the function is removed
+	updates = nil
+	deck.SetProgress(nil)
+	if _, err := deck.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if len(updates) != 0 {
+		t.Errorf("expected no updates, got %+v", updates)
+	}
+}
+
+func TestDeck_PushToAnki_Progress(t *testing.T) {
+	server := httptest.NewServer(withMulti(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ankiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+
+		var resp ankiResponse
+		switch req.Action {
+		case "version":
+			resp = ankiResponse{Result: float64(6), Error: ""}
+		case "modelNames":
+			resp = ankiResponse{Result: []interface{}{"Test Deck"}, Error: ""}
+		case "createDeck", "updateModelTemplates", "updateModelStyling":
+			resp = ankiResponse{Result: nil, Error: ""}
+		case "modelFieldNames":
+			resp = ankiResponse{Result: []interface{}{"Front", "Back"}, Error: ""}
+		case "modelTemplates":
+			resp = ankiResponse{Result: map[string]interface{}{
+				"Card 1": map[string]interface{}{"Front": "{{Front}}", "Back": "{{FrontSide}}<hr id=answer>{{Back}}"},
+			}, Error: ""}
+		case "modelStyling":
+			resp = ankiResponse{Result: map[string]interface{}{"css": ".card {}"}, Error: ""}
+		case "addNote":
+			resp = ankiResponse{Result: float64(1), Error: ""}
+		default:
+			t.Errorf("unexpected action: %s", req.Action)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(err)
+		}
+	})))
+	defer server.Close()
+
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+	for _, q := range []string{"one", "two", "three", "four", "five"} {
+		if err := deck.AddCard(q, "answer"); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+	}
+
+	var updates []Progress
+	deck.SetProgress(func(p Progress) {
+		updates = append(updates, p)
+	})
+	ac := NewAnkiConnectWithURL(server.URL)
+	ac.BatchSize = 2
+	if err := deck.PushToAnki(ac); err != nil {
+		t.Fatalf("PushToAnki failed: %v", err)
+	}
+
+	var done []int
+	for _, p := range updates {
+		if p.Phase != PhaseNotes || p.Total != 5 {
+			t.Errorf("unexpected update: %+v", p)
+		}
+		done = append(done, p.Done)
+	}
+	if len(done) != 3 || done[0] != 2 || done[1] != 4 || done[2] != 5 {
+		t.Errorf("expected an update per batch, got %v", done)
+	}
+}
@@ -0,0 +1,239 @@
+package anki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrAnkiUnavailable is returned while the circuit breaker considers Anki down
+var ErrAnkiUnavailable = errors.New("AnkiConnect unavailable")
+
+// RetryPolicy controls how idempotent actions are retried after connection
+// errors, e.g. when Anki drops requests while its UI is busy
+type RetryPolicy struct {
+	MaxAttempts    int           // Attempts including the first, 1 or less disables retries
+	InitialBackoff time.Duration // Wait before the first retry
+	MaxBackoff     time.Duration // Upper bound of the wait between retries, 0 for none
+	Multiplier     float64       // Growth of the wait after each retry
+}
+
+// DefaultRetryPolicy returns the retry policy used by NewAnkiConnect
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+	}
+}
+
+// backoff returns the wait after the given failed attempt, starting at 1
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	wait := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		wait *= multiplier
+	}
+	if p.MaxBackoff > 0 && wait > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+	return time.Duration(wait)
+}
+
+// idempotentActions are the actions that can be sent again without changing
+// the outcome if the first request was processed after all
+var idempotentActions = map[string]bool{
+	"version":                  true,
+	"apiReflect":               true,
+	"deckNames":                true,
+	"deckNamesAndIds":          true,
+	"getDecks":                 true,
+	"createDeck":               true,
+	"changeDeck":               true,
+	"deleteDecks":              true,
+	"getDeckConfig":            true,
+	"saveDeckConfig":           true,
+	"setDeckConfigId":          true,
+	"findNotes":                true,
+	"notesInfo":                true,
+	"canAddNotes":              true,
+	"updateNoteFields":         true,
+	"updateNote":               true,
+	"deleteNotes":              true,
+	"addTags":                  true,
+	"removeTags":               true,
+	"getTags":                  true,
+	"findCards":                true,
+	"cardsInfo":                true,
+	"suspend":                  true,
+	"unsuspend":                true,
+	"getEaseFactors":           true,
+	"setEaseFactors":           true,
+	"modelNames":               true,
+	"modelNamesAndIds":         true,
+	"modelFieldNames":          true,
+	"modelTemplates":           true,
+	"modelStyling":             true,
+	"updateModelTemplates":     true,
+	"updateModelStyling":       true,
+	"storeMediaFile":           true,
+	"retrieveMediaFile":        true,
+	"getMediaFilesNames":       true,
+	"getMediaDirPath":          true,
+	"deleteMediaFile":          true,
+	"getNumCardsReviewedToday": true,
+	"getNumCardsReviewedByDay": true,
+	"getCollectionStatsHTML":   true,
+	"cardReviews":              true,
+	"getLatestReviewID":        true,
+}
+
+// retryable reports whether a request can safely be sent again. A multi
+// request is retryable if all of its actions are.
+func retryable(action string, params interface{}) bool {
+	if action != "multi" {
+		return idempotentActions[action]
+	}
+
+	p, ok := params.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	requests, ok := p["actions"].([]ankiRequest)
+	if !ok {
+		return false
+	}
+	for _, req := range requests {
+		if !idempotentActions[req.Action] {
+			return false
+		}
+	}
+	return true
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CircuitBreaker fails requests fast after repeated connection errors instead
+// of waiting for each of them to time out. Once Cooldown has passed, the next
+// request first checks with Ping whether Anki is back.
+type CircuitBreaker struct {
+	Threshold int           // Consecutive connection errors that open the circuit
+	Cooldown  time.Duration // Time the circuit stays open before Anki is probed
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker creates a circuit breaker that opens after threshold
+// consecutive connection errors
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+	}
+}
+
+// Open reports whether requests are currently failed fast
+func (b *CircuitBreaker) Open() bool {
+	open, _ := b.state()
+	return open
+}
+
+// state reports whether the circuit is open and, if not, whether Anki has to
+// be probed before the next request
+func (b *CircuitBreaker) state() (open, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.Threshold <= 0 || b.failures < b.Threshold {
+		return false, false
+	}
+	if time.Since(b.openedAt) < b.Cooldown {
+		return true, false
+	}
+	return false, true
+}
+
+// record updates the breaker with the outcome of a request and reports
+// whether the circuit opened
+func (b *CircuitBreaker) record(failed bool) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		b.failures = 0
+		return false
+	}
+	b.failures++
+	if b.Threshold <= 0 || b.failures < b.Threshold {
+		return false
+	}
+	b.openedAt = time.Now()
+	return true
+}
+
+// checkBreaker returns an error if the circuit breaker is open or Anki did
+// not answer the probe of a half-open circuit
+func (ac *AnkiConnect) checkBreaker(ctx context.Context, action string) error {
+	if ac.Breaker == nil {
+		return nil
+	}
+
+	open, probe := ac.Breaker.state()
+	if open {
+		return &TransportError{Action: action, Err: ErrAnkiUnavailable}
+	}
+	if probe && action != "version" {
+		if err := ac.probe(ctx); err != nil {
+			return &TransportError{Action: action, Err: fmt.Errorf("%w: %v", ErrAnkiUnavailable, err)}
+		}
+	}
+	return nil
+}
+
+// probe sends a single version request, bypassing retries and the breaker
+func (ac *AnkiConnect) probe(ctx context.Context) error {
+	jsonData, err := ac.marshalRequest("version", nil)
+	if err != nil {
+		return err
+	}
+	_, err = ac.post(ctx, "version", jsonData)
+	return err
+}
+
+// WaitForAnki pings AnkiConnect every interval until it answers or ctx is
+// done. A successful ping closes the circuit breaker.
+func (ac *AnkiConnect) WaitForAnki(ctx context.Context, interval time.Duration) error {
+	for {
+		err := ac.probe(ctx)
+		if err == nil {
+			return nil
+		}
+		var transportErr *TransportError
+		if !errors.As(err, &transportErr) {
+			return err
+		}
+		if err := sleep(ctx, interval); err != nil {
+			return fmt.Errorf("AnkiConnect did not become available: %w", err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package anki
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyAnkiServer drops the connection of the first drops requests and then
+// answers every action with a version of 6
+func flakyAnkiServer(t *testing.T, drops int32, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(requests, 1) <= drops {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = conn.Close()
+			return
+		}
+		if err := json.NewEncoder(w).Encode(ankiResponse{Result: float64(6)}); err != nil {
+			t.Fatal(err)
+		}
+	}))
+}
+
+func testRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}
+}
+
+func TestAnkiConnect_Retry(t *testing.T) {
+	var requests int32
+	server := flakyAnkiServer(t, 2, &requests)
+	defer server.Close()
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	ac.Retry = testRetryPolicy()
+	if err := ac.Ping(); err != nil {
+		t.Fatalf("expected Ping to succeed after retries, got %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Errorf("expected 3 requests, got %d", n)
+	}
+
+	// Adding a note is not idempotent and must not be retried
+	atomic.StoreInt32(&requests, 0)
+	_, err := ac.AddNote(AnkiNote{DeckName: "Default", ModelName: "Basic"})
+	var transportErr *TransportError
+	if !errors.As(err, &transportErr) {
+		t.Errorf("expected *TransportError, got %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("expected addNote to be sent once, got %d", n)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond, Multiplier: 2}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond}
+	for i, w := range want {
+		if got := p.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestAnkiConnect_CircuitBreaker(t *testing.T) {
+	var requests int32
+	server := flakyAnkiServer(t, 2, &requests)
+	defer server.Close()
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	ac.Retry = nil
+	ac.Breaker = NewCircuitBreaker(2, 50*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		if err := ac.Ping(); err == nil {
+			t.Fatal("expected Ping to fail")
+		}
+	}
+	if !ac.Breaker.Open() {
+		t.Fatal("expected breaker to open after 2 failures")
+	}
+
+	if _, err := ac.GetDeckNames(); !errors.Is(err, ErrAnkiUnavailable) {
+		t.Errorf("expected ErrAnkiUnavailable, got %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("expected open breaker to fail fast, got %d requests", n)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := ac.GetDeckNames(); err == nil {
+		// deckNames gets the version result, which is not a list
+		t.Error("expected decode error")
+	} else if errors.Is(err, ErrAnkiUnavailable) {
+		t.Errorf("expected breaker to close after the probe, got %v", err)
+	}
+	if ac.Breaker.Open() {
+		t.Error("expected breaker to be closed")
+	}
+}
+
+func TestAnkiConnect_WaitForAnki(t *testing.T) {
+	var requests int32
+	server := flakyAnkiServer(t, 3, &requests)
+	defer server.Close()
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ac.WaitForAnki(ctx, time.Millisecond); err != nil {
+		t.Fatalf("WaitForAnki failed: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 4 {
+		t.Errorf("expected 4 pings, got %d", n)
+	}
+
+	server.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := ac.WaitForAnki(ctx, time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package anki
+
+import (
+	"time"
+
+	"github.com/ezynda3/go-anki-deck/internal/search"
+)
+
+// FindNotes returns the IDs of the notes matching an Anki search query.
+// It accepts the same syntax as AnkiConnect's findNotes, see SearchNotes.
+func (d *Deck) FindNotes(query string) ([]int64, error) {
+	notes, err := d.SearchNotes(query)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(notes))
+	for i, note := range notes {
+		ids[i] = note.ID
+	}
+	return ids, nil
+}
+
+// SearchNotes returns the notes matching an Anki search query.
+//
+// Supported syntax: plain text with * and _ wildcards, "quoted phrases",
+// field:value, tag:, deck:, note:, card:, nid:, cid:, is:new, is:learn,
+// is:review, is:suspended, is:buried and added:n, combined with implicit
+// and, explicit and/or, -negation and parentheses.
+func (d *Deck) SearchNotes(query string) ([]Note, error) {
+	q, err := search.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := d.Notes()
+	if err != nil {
+		return nil, err
+	}
+	models, err := d.loadModels()
+	if err != nil {
+		return nil, err
+	}
+	deckNames, err := d.loadDeckNames()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var matches []Note
+	for _, note := range notes {
+		model := models[note.ModelID]
+		target := &search.Target{
+			NoteID:        note.ID,
+			Fields:        note.Fields,
+			FieldNames:    model.fieldNames(),
+			Tags:          note.Tags,
+			ModelName:     model.Name,
+			TemplateNames: model.templateNames(),
+			Now:           now,
+		}
+		if matchNoteCards(q, target, note.Cards, deckNames) {
+			matches = append(matches, note)
+		}
+	}
+
+	return matches, nil
+}
+
+// matchNoteCards reports whether any card of the note matches the query.
+// Like Anki, card properties are evaluated per card, so "deck:a is:new"
+// requires a single card to satisfy both conditions.
+func matchNoteCards(q *search.Query, target *search.Target, cards []Card, deckNames map[int64]string) bool {
+	if len(cards) == 0 {
+		return q.Match(target)
+	}
+	for _, card := range cards {
+		target.Card = &search.Card{ID: card.ID, Ord: card.Ord, Type: card.Type, Queue: card.Queue}
+		target.DeckName = deckNames[card.DeckID]
+		if q.Match(target) {
+			return true
+		}
+	}
+	return false
+}
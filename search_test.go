@@ -0,0 +1,135 @@
+package anki
+
+import (
+	"fmt"
+	"testing"
+)
+
+func newSearchTestDeck(t *testing.T) (*Deck, map[string]int64) {
+	t.Helper()
+
+	deck, err := NewDeck("Languages::Spanish")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+
+	cards := []struct {
+		front, back string
+		tags        []string
+	}{
+		{"perro", "dog", []string{"animal", "noun"}},
+		{"gato", "cat", []string{"animal", "noun"}},
+		{"correr", "to run", []string{"verb", "verb::regular"}},
+		{"ser", "to be", []string{"verb::irregular"}},
+		{"hola", "hello (greeting)", nil},
+	}
+
+	ids := make(map[string]int64)
+	for _, c := range cards {
+		if err := deck.AddCardWithOptions(c.front, c.back, &CardOptions{Tags: c.tags}); err != nil {
+			t.Fatalf("Failed to add card: %v", err)
+		}
+	}
+
+	notes, err := deck.Notes()
+	if err != nil {
+		t.Fatalf("Notes failed: %v", err)
+	}
+	for _, note := range notes {
+		ids[note.Fields[0]] = note.ID
+	}
+
+	// Suspend the card of "gato"
+	if _, err := deck.db.Exec("UPDATE cards SET queue = -1 WHERE nid = ?", ids["gato"]); err != nil {
+		t.Fatalf("Failed to suspend card: %v", err)
+	}
+
+	return deck, ids
+}
+
+func TestFindNotes(t *testing.T) {
+	deck, ids := newSearchTestDeck(t)
+	defer deck.Close()
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"perro", "gato", "correr", "ser", "hola"}},
+		{"dog", []string{"perro"}},
+		{"DOG", []string{"perro"}},
+		{"to", []string{"gato", "correr", "ser"}},
+		{`"to run"`, []string{"correr"}},
+		{"e*o", []string{"perro", "hola"}},
+		{"g_to", []string{"gato"}},
+		{"front:gato", []string{"gato"}},
+		{"front:gat", nil},
+		{"back:to*", []string{"correr", "ser"}},
+		{`back:"to be"`, []string{"ser"}},
+		{"tag:animal", []string{"perro", "gato"}},
+		{"tag:verb", []string{"correr", "ser"}},
+		{"tag:verb::irregular", []string{"ser"}},
+		{"tag:none", []string{"hola"}},
+		{"tag:ani*", []string{"perro", "gato"}},
+		{"-tag:animal", []string{"correr", "ser", "hola"}},
+		{"tag:animal -cat", []string{"perro"}},
+		{"dog or cat", []string{"perro", "gato"}},
+		{"tag:noun and -is:suspended", []string{"perro"}},
+		{"(dog or cat) is:suspended", []string{"gato"}},
+		{"-(tag:animal or tag:verb)", []string{"hola"}},
+		{"tag:verb or tag:animal -is:suspended", []string{"perro", "correr", "ser"}},
+		{"deck:Languages", []string{"perro", "gato", "correr", "ser", "hola"}},
+		{`deck:"Languages::Spanish"`, []string{"perro", "gato", "correr", "ser", "hola"}},
+		{"deck:Spanish", nil},
+		{"deck:Lang*", []string{"perro", "gato", "correr", "ser", "hola"}},
+		{"is:new -is:suspended hello", []string{"hola"}},
+		{"added:1 dog", []string{"perro"}},
+		{`"hello (greeting)"`, []string{"hola"}},
+		{fmt.Sprintf("nid:%d,%d", ids["ser"], ids["hola"]), []string{"ser", "hola"}},
+		{"card:1 gato", []string{"gato"}},
+		{`"card:Card 1" gato`, []string{"gato"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := deck.FindNotes(tt.query)
+			if err != nil {
+				t.Fatalf("FindNotes(%q) failed: %v", tt.query, err)
+			}
+
+			want := make(map[int64]bool)
+			for _, front := range tt.want {
+				want[ids[front]] = true
+			}
+			if len(got) != len(want) {
+				t.Fatalf("FindNotes(%q) returned %d notes, want %d (%v)", tt.query, len(got), len(want), tt.want)
+			}
+			for _, id := range got {
+				if !want[id] {
+					t.Errorf("FindNotes(%q) returned unexpected note %d", tt.query, id)
+				}
+			}
+		})
+	}
+}
+
+func TestFindNotes_InvalidQueries(t *testing.T) {
+	deck, _ := newSearchTestDeck(t)
+	defer deck.Close()
+
+	queries := []string{
+		"(dog",
+		"dog)",
+		`"unterminated`,
+		"dog or",
+		"is:unknown",
+		"added:zero",
+		"nid:abc",
+	}
+
+	for _, query := range queries {
+		if _, err := deck.FindNotes(query); err == nil {
+			t.Errorf("FindNotes(%q) expected an error", query)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package anki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SyncPlan describes the changes a sync would make in Anki.
+// It is created by PlanSync and executed by ApplySync.
+type SyncPlan struct {
+	Add    []PlannedNote // Local notes to add to Anki
+	Update []NoteUpdate  // Notes in Anki whose fields differ from the local note
+	Retag  []TagUpdate   // Notes in Anki whose tags differ from the local note
+	Delete []DeletedNote // Notes in Anki that are missing locally
+	Media  []string      // Media files missing in Anki or with different content
+	Models []string      // Note types missing in Anki or whose templates or styling differ
+
+	synced      []int64 // Matched local notes that are in line with Anki once the plan is applied
+	syncMedia   bool
+	mediaOpts   *MediaSyncOptions
+	mediaReport *MediaSyncReport // Unchanged and failed media found while planning
+}
+
+// PlannedNote is a local note to be added to Anki
+type PlannedNote struct {
+	NoteID int64  // Local note ID
+	Model  string // Note type of the note
+	Fields map[string]string
+	Tags   []string
+}
+
+// NoteUpdate describes the field changes planned for a note in Anki
+type NoteUpdate struct {
+	NoteID       int64 // Local note ID
+	RemoteNoteID int64
+	Changes      []FieldChange
+}
+
+// FieldChange holds the value of a field before and after the sync
+type FieldChange struct {
+	Field  string
+	Before string
+	After  string
+}
+
+// TagUpdate lists the tags to add to and remove from a note in Anki
+type TagUpdate struct {
+	NoteID       int64 // Local note ID
+	RemoteNoteID int64
+	Add          []string
+	Remove       []string // Only tags with a managed prefix are removed
+}
+
+// Empty reports whether the plan makes no changes
+func (p *SyncPlan) Empty() bool {
+	return len(p.Add) == 0 && len(p.Update) == 0 && len(p.Retag) == 0 &&
+		len(p.Delete) == 0 && len(p.Media) == 0 && len(p.Models) == 0
+}
+
+// PlanSync works out the changes SyncToAnki would make without changing
+// anything in Anki. With DeleteMissing, ErrTooManyDeletes is returned if more
+// than MaxDeletes notes would be deleted.
+func (d *Deck) PlanSync(client *AnkiConnect, opts *SyncOptions) (*SyncPlan, error) {
+	return d.PlanSyncContext(context.Background(), client, opts)
+}
+
+// PlanSyncContext is like PlanSync but uses ctx for cancellation and deadlines.
+func (d *Deck) PlanSyncContext(ctx context.Context, client *AnkiConnect, opts *SyncOptions) (*SyncPlan, error) {
+	// Use default options if none provided
+	syncOpts := opts
+	if syncOpts == nil {
+		syncOpts = &SyncOptions{
+			UpdateExisting: true,
+			DeleteMissing:  false,
+			SyncMedia:      false,
+		}
+	}
+
+	// Check connection
+	if err := client.PingContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to AnkiConnect: %w", err)
+	}
+
+	// Find existing notes in the deck
+	existingNotes, err := client.FindNotesContext(ctx, deckQuery(d.name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find existing notes: %w", err)
+	}
+
+	var notesInfo []NoteInfo
+	if len(existingNotes) > 0 {
+		notesInfo, err = client.NotesInfoContext(ctx, existingNotes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get notes info: %w", err)
+		}
+	}
+
+	models, err := d.loadModels()
+	if err != nil {
+		return nil, err
+	}
+	locals, err := d.deckNotes()
+	if err != nil {
+		return nil, err
+	}
+	used := d.usedModels(locals, models)
+	remoteIDs, err := d.remoteNoteIDs()
+	if err != nil {
+		return nil, err
+	}
+	matches, unmatched, err := matchModelNotes(locals, remoteIDs, notesInfo, used, syncOpts.KeyField)
+	if err != nil {
+		return nil, err
+	}
+
+	remote := make(map[int64]NoteInfo, len(notesInfo))
+	for _, noteInfo := range notesInfo {
+		remote[noteInfo.NoteID] = noteInfo
+	}
+
+	plan := &SyncPlan{syncMedia: syncOpts.SyncMedia}
+	ls, err := d.lastSync()
+	if err != nil {
+		return nil, err
+	}
+	plan.Models, err = client.changedModels(ctx, used, ls)
+	if err != nil {
+		return nil, err
+	}
+	for _, local := range locals {
+		model, err := pushModel(models, local)
+		if err != nil {
+			return nil, err
+		}
+		fieldNames := model.fieldNames()
+		remoteID, ok := matches[local.ID]
+		if !ok {
+			plan.Add = append(plan.Add, PlannedNote{
+				NoteID: local.ID,
+				Model:  model.Name,
+				Fields: fieldMap(fieldNames, local.Fields),
+				Tags:   local.Tags,
+			})
+			continue
+		}
+		if !syncOpts.UpdateExisting {
+			continue
+		}
+		plan.synced = append(plan.synced, local.ID)
+
+		noteInfo := remote[remoteID]
+		remoteFields := noteInfo.fieldValues()
+		var changes []FieldChange
+		for i, name := range fieldNames {
+			if i < len(local.Fields) && remoteFields[name] != local.Fields[i] {
+				changes = append(changes, FieldChange{
+					Field:  name,
+					Before: remoteFields[name],
+					After:  local.Fields[i],
+				})
+			}
+		}
+		if len(changes) > 0 {
+			plan.Update = append(plan.Update, NoteUpdate{
+				NoteID:       local.ID,
+				RemoteNoteID: remoteID,
+				Changes:      changes,
+			})
+		}
+
+		remoteTags := noteInfo.Tags
+		var remove []string
+		for _, tag := range missingTags(remoteTags, local.Tags) {
+			if managedTag(tag, syncOpts.ManagedTagPrefixes) {
+				remove = append(remove, tag)
+			}
+		}
+		if add := missingTags(local.Tags, remoteTags); len(add) > 0 || len(remove) > 0 {
+			plan.Retag = append(plan.Retag, TagUpdate{
+				NoteID:       local.ID,
+				RemoteNoteID: remoteID,
+				Add:          add,
+				Remove:       remove,
+			})
+		}
+	}
+
+	if syncOpts.DeleteMissing {
+		for _, noteInfo := range unmatched {
+			// Notes deleted since findNotes come back empty
+			if noteInfo.NoteID == 0 {
+				continue
+			}
+			plan.Delete = append(plan.Delete, DeletedNote{
+				NoteID: noteInfo.NoteID,
+				Fields: noteInfo.fieldValues(),
+			})
+		}
+		limit := syncOpts.MaxDeletes
+		if limit == 0 {
+			limit = DefaultMaxDeletes
+		}
+		if limit > 0 && len(plan.Delete) > limit {
+			return nil, fmt.Errorf("%w: %d notes would be deleted, limit is %d",
+				ErrTooManyDeletes, len(plan.Delete), limit)
+		}
+	}
+
+	if syncOpts.SyncMedia {
+		plan.mediaOpts = syncOpts.MediaOptions
+		if plan.mediaOpts == nil {
+			plan.mediaOpts = &MediaSyncOptions{}
+		}
+		upload, report, err := d.planMedia(ctx, client, plan.mediaOpts)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range upload {
+			plan.Media = append(plan.Media, m.Filename)
+		}
+		plan.mediaReport = report
+	}
+
+	return plan, nil
+}
+
+// ApplySync makes the changes described by a plan from PlanSync
+func (d *Deck) ApplySync(client *AnkiConnect, plan *SyncPlan) (*SyncReport, error) {
+	return d.ApplySyncContext(context.Background(), client, plan)
+}
+
+// ApplySyncContext is like ApplySync but uses ctx for cancellation and deadlines.
+func (d *Deck) ApplySyncContext(ctx context.Context, client *AnkiConnect, plan *SyncPlan) (*SyncReport, error) {
+	// Create deck if needed
+	if err := client.CreateDeckContext(ctx, d.name); err != nil {
+		if !errors.Is(err, ErrDeckExists) {
+			return nil, fmt.Errorf("failed to create deck: %w", err)
+		}
+	}
+
+	// Only the note types in the plan are created or updated
+	models, err := d.loadModels()
+	if err != nil {
+		return nil, err
+	}
+	var changed []noteModel
+	for _, model := range models {
+		if indexOf(plan.Models, model.Name) >= 0 {
+			changed = append(changed, model)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].ID < changed[j].ID })
+	if len(changed) > 0 {
+		if err := d.pushModels(ctx, client, changed); err != nil {
+			return nil, err
+		}
+	}
+
+	// Upload media first so new notes can refer to it
+	report := &SyncReport{}
+	if plan.syncMedia {
+		report.Media = &MediaSyncReport{}
+		if plan.mediaReport != nil {
+			*report.Media = *plan.mediaReport
+		}
+		mediaOpts := plan.mediaOpts
+		if mediaOpts == nil {
+			mediaOpts = &MediaSyncOptions{}
+		}
+
+		var media []Media
+		for _, filename := range plan.Media {
+			m, ok := d.findMedia(filename)
+			if !ok {
+				report.Media.Failed = append(report.Media.Failed, &MediaError{Filename: filename, Err: errors.New("not in the deck")})
+				continue
+			}
+			media = append(media, m)
+		}
+		onUpload := func(done int, bytes int64) {
+			d.progress(Progress{Phase: PhaseMedia, Done: done, Total: len(media), Bytes: bytes})
+		}
+		if err := client.uploadMedia(ctx, media, mediaOpts, report.Media, onUpload); err != nil {
+			return nil, err
+		}
+		for _, failed := range report.Media.Failed {
+			d.log(client).WarnContext(ctx, "failed to sync media file",
+				"file", failed.Filename, "error", failed.Err)
+		}
+	}
+
+	addIDs := make([]int64, len(plan.Add))
+	notes := make([]AnkiNote, len(plan.Add))
+	for i, planned := range plan.Add {
+		modelName := planned.Model
+		if modelName == "" {
+			modelName = models[d.topModelID].Name
+		}
+		note := AnkiNote{
+			DeckName:  d.name,
+			ModelName: modelName,
+			Fields:    planned.Fields,
+			Tags:      planned.Tags,
+			Options: map[string]interface{}{
+				"allowDuplicate": false,
+			},
+		}
+
+		addIDs[i] = planned.NoteID
+		notes[i] = note
+	}
+
+	var localIDs []int64
+	var actions []MultiAction
+	for _, update := range plan.Update {
+		fields := make(map[string]string, len(update.Changes))
+		for _, change := range update.Changes {
+			fields[change.Field] = change.After
+		}
+		localIDs = append(localIDs, update.NoteID)
+		actions = append(actions, MultiAction{
+			Action: "updateNoteFields",
+			Params: map[string]interface{}{
+				"note": map[string]interface{}{"id": update.RemoteNoteID, "fields": fields},
+			},
+		})
+	}
+	for _, retag := range plan.Retag {
+		if len(retag.Add) > 0 {
+			localIDs = append(localIDs, retag.NoteID)
+			actions = append(actions, MultiAction{
+				Action: "addTags",
+				Params: map[string]interface{}{"notes": []int64{retag.RemoteNoteID}, "tags": tagsParam(retag.Add)},
+			})
+		}
+		if len(retag.Remove) > 0 {
+			localIDs = append(localIDs, retag.NoteID)
+			actions = append(actions, MultiAction{
+				Action: "removeTags",
+				Params: map[string]interface{}{"notes": []int64{retag.RemoteNoteID}, "tags": tagsParam(retag.Remove)},
+			})
+		}
+	}
+
+	// Progress counts added notes, update actions and deleted notes
+	total := len(notes) + len(actions) + len(plan.Delete)
+	remoteIDs, err := d.addNotesBatched(ctx, client, addIDs, notes, func(done int) {
+		d.progress(Progress{Phase: PhaseNotes, Done: done, Total: total})
+	})
+	if err := d.setRemoteNoteIDs(addIDs, remoteIDs); err != nil {
+		return nil, err
+	}
+	if err := d.markSynced(addedNotes(addIDs, remoteIDs)...); err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = client.runNoteActions(ctx, localIDs, actions, func(done int) {
+		d.progress(Progress{Phase: PhaseNotes, Done: len(notes) + done, Total: total})
+	})
+	var batchErr *BatchError
+	if err != nil && !errors.As(err, &batchErr) {
+		return nil, fmt.Errorf("failed to update notes: %w", err)
+	}
+
+	// Notes that were skipped or failed to update keep their local changes
+	failed := make(map[int64]bool)
+	if batchErr != nil {
+		for _, noteErr := range batchErr.Errors {
+			failed[noteErr.NoteID] = true
+		}
+	}
+	for _, id := range plan.synced {
+		if !failed[id] {
+			if err := d.markSynced(id); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if batchErr != nil {
+		return nil, fmt.Errorf("failed to update notes: %w", batchErr)
+	}
+
+	if len(plan.Delete) > 0 {
+		ids := make([]int64, len(plan.Delete))
+		for i, note := range plan.Delete {
+			ids[i] = note.NoteID
+		}
+		if err := client.DeleteNotesContext(ctx, ids); err != nil {
+			return nil, fmt.Errorf("failed to delete missing notes: %w", err)
+		}
+		d.progress(Progress{Phase: PhaseNotes, Done: total, Total: total})
+	}
+
+	if err := d.setLastSync(time.Now().UnixMilli()); err != nil {
+		return nil, err
+	}
+
+	report.Deleted = plan.Delete
+	return report, nil
+}
+
+// findMedia returns the deck's media file with the given name
+func (d *Deck) findMedia(filename string) (Media, bool) {
+	for _, media := range d.media {
+		if media.Filename == filename {
+			return media, true
+		}
+	}
+	return Media{}, false
+}
+
+// managedTag reports whether a tag starts with one of the prefixes, ignoring case
+func managedTag(tag string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(strings.ToLower(tag), strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// missingTags returns the tags in local that remote lacks, ignoring case as Anki does
+func missingTags(local, remote []string) []string {
+	have := make(map[string]bool, len(remote))
+	for _, tag := range remote {
+		have[strings.ToLower(tag)] = true
+	}
+	var missing []string
+	for _, tag := range local {
+		if !have[strings.ToLower(tag)] {
+			missing = append(missing, tag)
+		}
+	}
+	return missing
+}
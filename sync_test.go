@@ -0,0 +1,226 @@
+package anki
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDeck_PlanSync(t *testing.T) {
+	var actions []string
+	server := httptest.NewServer(withMulti(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ankiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		actions = append(actions, req.Action)
+
+		var resp ankiResponse
+		switch req.Action {
+		case "version":
+			resp = ankiResponse{Result: float64(6), Error: ""}
+		case "findNotes":
+			resp = ankiResponse{Result: []interface{}{float64(1), float64(2)}, Error: ""}
+		case "notesInfo":
+			resp = ankiResponse{
+				Result: []interface{}{
+					map[string]interface{}{
+						"noteId":    float64(1),
+						"modelName": "Test Deck",
+						"tags":      []interface{}{"A"},
+						"fields": map[string]interface{}{
+							"Front": map[string]interface{}{"value": "Q1"},
+							"Back":  map[string]interface{}{"value": "Old"},
+						},
+					},
+					map[string]interface{}{
+						"noteId":    float64(2),
+						"modelName": "Test Deck",
+						"fields": map[string]interface{}{
+							"Front": map[string]interface{}{"value": "Stale"},
+							"Back":  map[string]interface{}{"value": "Gone"},
+						},
+					},
+				},
+				Error: "",
+			}
+		case "addNote":
+			resp = ankiResponse{Result: float64(3), Error: ""}
+		case "modelNames":
+			resp = ankiResponse{Result: []interface{}{"Basic", "Test Deck"}, Error: ""}
+		case "updateModelTemplates", "updateModelStyling":
+			resp = ankiResponse{Result: nil, Error: ""}
+		case "modelFieldNames":
+			resp = ankiResponse{Result: []interface{}{"Front", "Back"}, Error: ""}
+		case "modelTemplates":
+			resp = ankiResponse{Result: map[string]interface{}{
+				"Card 1": map[string]interface{}{"Front": "{{Front}}", "Back": "{{FrontSide}}<hr id=answer>{{Back}}"},
+			}, Error: ""}
+		case "modelStyling":
+			resp = ankiResponse{Result: map[string]interface{}{"css": ".card {}"}, Error: ""}
+		case "getMediaFilesNames":
+			resp = ankiResponse{Result: []interface{}{}, Error: ""}
+		case "createDeck", "storeMediaFile", "updateNoteFields", "addTags", "deleteNotes":
+			resp = ankiResponse{Result: nil, Error: ""}
+		default:
+			t.Errorf("unexpected action: %s", req.Action)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(err)
+		}
+	})))
+	defer server.Close()
+
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	if err := deck.AddCardWithOptions("Q1", "A1", &CardOptions{Tags: []string{"a", "b"}}); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+	if err := deck.AddCard("Q3", "A3"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+	deck.AddMedia("image.jpg", []byte("image"))
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	plan, err := deck.PlanSync(ac, &SyncOptions{UpdateExisting: true, DeleteMissing: true, SyncMedia: true})
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+
+	readOnly := map[string]bool{
+		"version": true, "findNotes": true, "notesInfo": true, "getMediaFilesNames": true,
+		"modelNames": true, "modelFieldNames": true, "modelTemplates": true, "modelStyling": true,
+	}
+	for _, action := range actions {
+		if !readOnly[action] {
+			t.Errorf("PlanSync should not change Anki, called %s", action)
+		}
+	}
+
+	if len(plan.Add) != 1 || plan.Add[0].Fields["Front"] != "Q3" {
+		t.Errorf("expected Q3 to be added, got %+v", plan.Add)
+	}
+	if len(plan.Update) != 1 || plan.Update[0].RemoteNoteID != 1 {
+		t.Fatalf("expected note 1 to be updated, got %+v", plan.Update)
+	}
+	want := FieldChange{Field: "Back", Before: "Old", After: "A1"}
+	if changes := plan.Update[0].Changes; len(changes) != 1 || changes[0] != want {
+		t.Errorf("expected change %+v, got %+v", want, changes)
+	}
+	if len(plan.Retag) != 1 || len(plan.Retag[0].Add) != 1 || plan.Retag[0].Add[0] != "b" {
+		t.Errorf("expected tag b to be added to note 1, got %+v", plan.Retag)
+	}
+	if len(plan.Delete) != 1 || plan.Delete[0].NoteID != 2 {
+		t.Errorf("expected note 2 to be deleted, got %+v", plan.Delete)
+	}
+	if len(plan.Media) != 1 || plan.Media[0] != "image.jpg" {
+		t.Errorf("expected image.jpg to be uploaded, got %v", plan.Media)
+	}
+	if len(plan.Models) != 1 || plan.Models[0] != "Test Deck" {
+		t.Errorf("expected the note type to be updated, got %v", plan.Models)
+	}
+
+	actions = nil
+	report, err := deck.ApplySync(ac, plan)
+	if err != nil {
+		t.Fatalf("ApplySync failed: %v", err)
+	}
+	wantActions := []string{"createDeck", "modelNames", "modelFieldNames", "modelTemplates", "modelStyling", "updateModelTemplates", "updateModelStyling", "storeMediaFile", "addNote", "updateNoteFields", "addTags", "deleteNotes"}
+	if !equalStrings(actions, wantActions) {
+		t.Errorf("expected actions %v, got %v", wantActions, actions)
+	}
+	if len(report.Deleted) != 1 {
+		t.Errorf("expected 1 deleted note in report, got %d", len(report.Deleted))
+	}
+	if report.Media == nil || !equalStrings(report.Media.Uploaded, []string{"image.jpg"}) {
+		t.Errorf("expected image.jpg in media report, got %+v", report.Media)
+	}
+}
+
+func TestDeck_SyncToAnki_Tags(t *testing.T) {
+	tagChanges := make(map[string]interface{})
+	server := httptest.NewServer(withMulti(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ankiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+
+		var resp ankiResponse
+		switch req.Action {
+		case "version":
+			resp = ankiResponse{Result: float64(6), Error: ""}
+		case "findNotes":
+			resp = ankiResponse{Result: []interface{}{float64(1)}, Error: ""}
+		case "notesInfo":
+			resp = ankiResponse{
+				Result: []interface{}{
+					map[string]interface{}{
+						"noteId":    float64(1),
+						"modelName": "Test Deck",
+						"tags":      []interface{}{"source::old", "personal", "keep"},
+						"fields": map[string]interface{}{
+							"Front": map[string]interface{}{"value": "Q1"},
+							"Back":  map[string]interface{}{"value": "A1"},
+						},
+					},
+				},
+				Error: "",
+			}
+		case "addTags", "removeTags":
+			tagChanges[req.Action] = req.Params.(map[string]interface{})["tags"]
+			resp = ankiResponse{Result: nil, Error: ""}
+		case "modelNames":
+			resp = ankiResponse{Result: []interface{}{"Basic", "Test Deck"}, Error: ""}
+		case "updateModelTemplates", "updateModelStyling":
+			resp = ankiResponse{Result: nil, Error: ""}
+		case "modelFieldNames":
+			resp = ankiResponse{Result: []interface{}{"Front", "Back"}, Error: ""}
+		case "modelTemplates":
+			resp = ankiResponse{Result: map[string]interface{}{
+				"Card 1": map[string]interface{}{"Front": "{{Front}}", "Back": "{{FrontSide}}<hr id=answer>{{Back}}"},
+			}, Error: ""}
+		case "modelStyling":
+			resp = ankiResponse{Result: map[string]interface{}{"css": ".card {}"}, Error: ""}
+		case "createDeck":
+			resp = ankiResponse{Result: nil, Error: ""}
+		default:
+			t.Errorf("unexpected action: %s", req.Action)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(err)
+		}
+	})))
+	defer server.Close()
+
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	if err := deck.AddCardWithOptions("Q1", "A1", &CardOptions{Tags: []string{"source::new", "keep"}}); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+
+	ac := NewAnkiConnectWithURL(server.URL)
+	err = deck.SyncToAnki(ac, &SyncOptions{UpdateExisting: true, ManagedTagPrefixes: []string{"source::"}})
+	if err != nil {
+		t.Fatalf("SyncToAnki failed: %v", err)
+	}
+
+	if tagChanges["addTags"] != "source::new" {
+		t.Errorf("expected source::new to be added, got %v", tagChanges["addTags"])
+	}
+	if tagChanges["removeTags"] != "source::old" {
+		t.Errorf("expected only source::old to be removed, got %v", tagChanges["removeTags"])
+	}
+}
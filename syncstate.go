@@ -0,0 +1,539 @@
+package anki
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SyncState records what each note looked like at the last two-way sync, so
+// the next sync can tell local edits from edits made in Anki
+type SyncState struct {
+	LastSync time.Time            `json:"lastSync"`
+	Notes    map[string]NoteState `json:"notes"` // Keyed by local note GUID
+
+	// Media holds the content hashes of media files in Anki by filename,
+	// for MediaSyncOptions.Hashes
+	Media map[string]string `json:"media,omitempty"`
+}
+
+// NoteState is the sync state of a single note
+type NoteState struct {
+	RemoteNoteID int64  `json:"remoteNoteId"`
+	Hash         string `json:"hash"` // Hash of fields and tags at the last sync
+}
+
+// LoadSyncState reads sync state from a JSON file.
+// A missing file yields an empty state, as for a deck that was never synced.
+func LoadSyncState(path string) (*SyncState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &SyncState{Notes: make(map[string]NoteState), Media: make(map[string]string)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync state: %w", err)
+	}
+
+	var state SyncState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse sync state: %w", err)
+	}
+	if state.Notes == nil {
+		state.Notes = make(map[string]NoteState)
+	}
+	if state.Media == nil {
+		state.Media = make(map[string]string)
+	}
+	return &state, nil
+}
+
+// Save writes the sync state to a JSON file
+func (s *SyncState) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode sync state: %w", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// ConflictPolicy decides which side wins when a note changed both locally
+// and in Anki since the last sync
+type ConflictPolicy int
+
+const (
+	// LocalWins overwrites the note in Anki with the local note
+	LocalWins ConflictPolicy = iota
+	// RemoteWins overwrites the local note with the note in Anki
+	RemoteWins
+)
+
+// TwoWaySyncOptions configures TwoWaySync
+type TwoWaySyncOptions struct {
+	// KeyField names the field used to pair notes that have no sync state
+	// yet. It defaults to the first field of the note type.
+	KeyField string
+
+	ConflictPolicy ConflictPolicy
+
+	// ManagedTagPrefixes lists the tag prefixes owned by the local deck, as
+	// in SyncOptions. Other tags are only ever added to notes in Anki, and
+	// tags Anki keeps are added to the local note.
+	ManagedTagPrefixes []string
+
+	// OnConflict, when set, decides each conflict instead of ConflictPolicy
+	OnConflict func(conflict SyncConflict) ConflictPolicy
+}
+
+// SyncConflict describes a note changed on both sides since the last sync.
+// Local or Remote is nil when the note was deleted on that side.
+type SyncConflict struct {
+	NoteID       int64 // Local note ID, 0 if deleted locally
+	RemoteNoteID int64 // Anki note ID, 0 if deleted in Anki
+	Local        map[string]string
+	Remote       map[string]string
+	LocalTags    []string
+	RemoteTags   []string
+	Resolution   ConflictPolicy
+}
+
+// TwoWaySyncReport describes the changes made by TwoWaySync
+type TwoWaySyncReport struct {
+	Pushed        int // Notes in Anki updated from local notes
+	Pulled        int // Local notes updated from Anki
+	AddedRemote   int // Local notes added to Anki
+	AddedLocal    int // Notes from Anki added locally
+	DeletedRemote int // Notes deleted from Anki because they were deleted locally
+	DeletedLocal  int // Local notes deleted because they were deleted in Anki
+	Conflicts     []SyncConflict
+
+	// Skipped lists the local notes not added to Anki because they
+	// duplicate a note there
+	Skipped []int64
+}
+
+// TwoWaySync syncs the deck with Anki in both directions. The state from the
+// previous sync is the common ancestor of each note: a side whose content
+// still matches it is unchanged, so the other side's edits or deletions win.
+// Notes changed on both sides are resolved by the conflict policy. The state
+// is updated in place and should be saved after a successful sync.
+func (d *Deck) TwoWaySync(client *AnkiConnect, state *SyncState, opts *TwoWaySyncOptions) (*TwoWaySyncReport, error) {
+	return d.TwoWaySyncContext(context.Background(), client, state, opts)
+}
+
+// TwoWaySyncContext is like TwoWaySync but uses ctx for cancellation and deadlines.
+func (d *Deck) TwoWaySyncContext(ctx context.Context, client *AnkiConnect, state *SyncState, opts *TwoWaySyncOptions) (*TwoWaySyncReport, error) {
+	if opts == nil {
+		opts = &TwoWaySyncOptions{}
+	}
+	if state.Notes == nil {
+		state.Notes = make(map[string]NoteState)
+	}
+
+	// Check connection
+	if err := client.PingContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to AnkiConnect: %w", err)
+	}
+
+	// Create deck if needed
+	if err := client.CreateDeckContext(ctx, d.name); err != nil {
+		if !errors.Is(err, ErrDeckExists) {
+			return nil, fmt.Errorf("failed to create deck: %w", err)
+		}
+	}
+
+	locals, err := d.deckNotes()
+	if err != nil {
+		return nil, err
+	}
+	models, err := d.loadModels()
+	if err != nil {
+		return nil, err
+	}
+	used := d.usedModels(locals, models)
+	if err := checkKeyField(used, opts.KeyField); err != nil {
+		return nil, err
+	}
+	if err := d.pushModels(ctx, client, used); err != nil {
+		return nil, err
+	}
+
+	noteIDs, err := client.FindNotesContext(ctx, deckQuery(d.name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find notes: %w", err)
+	}
+	var notesInfo []NoteInfo
+	if len(noteIDs) > 0 {
+		notesInfo, err = client.NotesInfoContext(ctx, noteIDs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get notes info: %w", err)
+		}
+	}
+
+	// Notes only in Anki may be of note types the deck doesn't have yet
+	byName := make(map[string]noteModel, len(models))
+	for _, model := range models {
+		byName[model.Name] = model
+	}
+	for _, noteInfo := range notesInfo {
+		name := noteInfo.ModelName
+		if _, ok := byName[name]; ok || name == "" {
+			continue
+		}
+		remote, err := client.remoteModel(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		model, err := d.importModel(remote)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import model %s: %w", name, err)
+		}
+		byName[name] = model
+		models[model.ID] = model
+	}
+
+	remoteIDs, err := d.remoteNoteIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &twoWaySync{
+		deck:   d,
+		client: client,
+		opts:   opts,
+		models: models,
+		byName: byName,
+		next:   make(map[string]NoteState),
+		report: &TwoWaySyncReport{},
+	}
+
+	remote := make(map[int64]NoteInfo, len(notesInfo))
+	for _, noteInfo := range notesInfo {
+		remote[noteInfo.NoteID] = noteInfo
+	}
+	known := make(map[int64]string, len(state.Notes))
+	for guid, entry := range state.Notes {
+		known[entry.RemoteNoteID] = guid
+	}
+
+	// Pair notes through the sync state first
+	claimed := make(map[int64]bool)
+	var unpaired []Note
+	for _, local := range locals {
+		entry, ok := state.Notes[local.GUID]
+		if !ok {
+			unpaired = append(unpaired, local)
+			continue
+		}
+		if noteInfo, ok := remote[entry.RemoteNoteID]; ok {
+			claimed[entry.RemoteNoteID] = true
+			err = s.syncPair(ctx, local, noteInfo, entry.Hash)
+		} else {
+			err = s.remoteDeleted(ctx, local, entry)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Remaining notes are paired by recorded note ID or key field, as on a
+	// first sync
+	var candidates []NoteInfo
+	for _, noteInfo := range notesInfo {
+		id := noteInfo.NoteID
+		if _, ok := known[id]; !ok && !claimed[id] {
+			candidates = append(candidates, noteInfo)
+		}
+	}
+	matches, _, err := matchModelNotes(unpaired, remoteIDs, candidates, used, opts.KeyField)
+	if err != nil {
+		return nil, err
+	}
+	for _, local := range unpaired {
+		if remoteID, ok := matches[local.ID]; ok {
+			claimed[remoteID] = true
+			err = s.syncPair(ctx, local, remote[remoteID], "")
+		} else {
+			err = s.addRemote(ctx, local)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, noteInfo := range notesInfo {
+		id := noteInfo.NoteID
+		if claimed[id] {
+			continue
+		}
+		if guid, ok := known[id]; ok {
+			err = s.localDeleted(ctx, noteInfo, state.Notes[guid])
+		} else {
+			err = s.addLocal(ctx, noteInfo)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Skipped notes keep their local changes
+	if err := d.markDeckSynced(s.report.Skipped...); err != nil {
+		return nil, err
+	}
+
+	state.Notes = s.next
+	state.LastSync = time.Now()
+	return s.report, nil
+}
+
+// twoWaySync holds the working state of a single TwoWaySync call
+type twoWaySync struct {
+	deck   *Deck
+	client *AnkiConnect
+	opts   *TwoWaySyncOptions
+	models map[int64]noteModel
+	byName map[string]noteModel
+	next   map[string]NoteState
+	report *TwoWaySyncReport
+}
+
+// remoteModel returns the local note type of a note in Anki, defaulting to
+// the deck's own
+func (s *twoWaySync) remoteModel(noteInfo NoteInfo) noteModel {
+	if model, ok := s.byName[noteInfo.ModelName]; ok {
+		return model
+	}
+	return s.models[s.deck.topModelID]
+}
+
+// syncPair brings a local note and its note in Anki in line, given the hash
+// of their content at the last sync. The note in Anki is read and updated
+// through its own note type, which may differ from the local one when its
+// type was changed in Anki; fields are then paired as by fieldMapping.
+func (s *twoWaySync) syncPair(ctx context.Context, local Note, noteInfo NoteInfo, base string) error {
+	remoteID := noteInfo.NoteID
+	fieldNames := s.models[local.ModelID].fieldNames()
+	remoteNames := fieldNames
+	if noteInfo.ModelName != s.models[local.ModelID].Name {
+		remoteNames = s.remoteModel(noteInfo).fieldNames()
+	}
+	remoteValues := noteInfo.values(remoteNames)
+	toLocal, _ := fieldMapping(remoteNames, fieldNames)
+	values := mapValues(remoteValues, toLocal)
+	for i, j := range toLocal {
+		// Local fields without a counterpart in Anki keep their values
+		if j < 0 && i < len(local.Fields) {
+			values[i] = local.Fields[i]
+		}
+	}
+	tags := noteInfo.Tags
+	localHash := noteHash(local.Fields, local.Tags)
+	remoteHash := noteHash(values, tags)
+
+	var pull bool
+	switch {
+	case localHash == remoteHash:
+		s.next[local.GUID] = NoteState{RemoteNoteID: remoteID, Hash: localHash}
+		return nil
+	case localHash == base:
+		// Only changed in Anki
+		pull = true
+	case remoteHash == base:
+		// Only changed locally
+	default:
+		pull = s.resolve(SyncConflict{
+			NoteID:       local.ID,
+			RemoteNoteID: remoteID,
+			Local:        fieldMap(fieldNames, local.Fields),
+			Remote:       fieldMap(remoteNames, remoteValues),
+			LocalTags:    local.Tags,
+			RemoteTags:   tags,
+		}) == RemoteWins
+	}
+
+	if pull {
+		note := local
+		note.Fields = values
+		note.Tags = tags
+		if err := s.deck.UpdateNote(&note); err != nil {
+			return err
+		}
+		s.report.Pulled++
+		s.next[local.GUID] = NoteState{RemoteNoteID: remoteID, Hash: remoteHash}
+		return nil
+	}
+
+	// Fields of the note in Anki without a local counterpart keep their values
+	toRemote, _ := fieldMapping(fieldNames, remoteNames)
+	fields := make(map[string]string, len(remoteNames))
+	for i, j := range toRemote {
+		if j >= 0 && j < len(local.Fields) {
+			fields[remoteNames[i]] = local.Fields[j]
+		}
+	}
+	if err := s.client.UpdateNoteFieldsContext(ctx, remoteID, fields); err != nil {
+		return fmt.Errorf("failed to update note %d: %w", remoteID, err)
+	}
+	if add := missingTags(local.Tags, tags); len(add) > 0 {
+		if err := s.client.AddTagsContext(ctx, []int64{remoteID}, add); err != nil {
+			return fmt.Errorf("failed to tag note %d: %w", remoteID, err)
+		}
+	}
+	var remove, kept []string
+	for _, tag := range missingTags(tags, local.Tags) {
+		if managedTag(tag, s.opts.ManagedTagPrefixes) {
+			remove = append(remove, tag)
+		} else {
+			kept = append(kept, tag)
+		}
+	}
+	if len(remove) > 0 {
+		if err := s.client.RemoveTagsContext(ctx, []int64{remoteID}, remove); err != nil {
+			return fmt.Errorf("failed to untag note %d: %w", remoteID, err)
+		}
+	}
+	// Tags Anki keeps are added locally so both sides match
+	if len(kept) > 0 {
+		local.Tags = append(local.Tags, kept...)
+		if err := s.deck.UpdateNote(&local); err != nil {
+			return err
+		}
+		localHash = noteHash(local.Fields, local.Tags)
+	}
+	s.report.Pushed++
+	s.next[local.GUID] = NoteState{RemoteNoteID: remoteID, Hash: localHash}
+	return nil
+}
+
+// remoteDeleted handles a local note whose note in Anki was deleted
+func (s *twoWaySync) remoteDeleted(ctx context.Context, local Note, entry NoteState) error {
+	if noteHash(local.Fields, local.Tags) != entry.Hash {
+		resolution := s.resolve(SyncConflict{
+			NoteID:    local.ID,
+			Local:     fieldMap(s.models[local.ModelID].fieldNames(), local.Fields),
+			LocalTags: local.Tags,
+		})
+		if resolution == LocalWins {
+			return s.addRemote(ctx, local)
+		}
+	}
+
+	if err := s.deck.DeleteNote(local.ID); err != nil {
+		return err
+	}
+	s.report.DeletedLocal++
+	return nil
+}
+
+// localDeleted handles a note in Anki whose local note was deleted
+func (s *twoWaySync) localDeleted(ctx context.Context, noteInfo NoteInfo, entry NoteState) error {
+	remoteID := noteInfo.NoteID
+	fieldNames := s.remoteModel(noteInfo).fieldNames()
+	values := noteInfo.values(fieldNames)
+	tags := noteInfo.Tags
+	if noteHash(values, tags) != entry.Hash {
+		resolution := s.resolve(SyncConflict{
+			RemoteNoteID: remoteID,
+			Remote:       fieldMap(fieldNames, values),
+			RemoteTags:   tags,
+		})
+		if resolution == RemoteWins {
+			return s.addLocal(ctx, noteInfo)
+		}
+	}
+
+	if err := s.client.DeleteNotesContext(ctx, []int64{remoteID}); err != nil {
+		return fmt.Errorf("failed to delete note %d: %w", remoteID, err)
+	}
+	s.report.DeletedRemote++
+	return nil
+}
+
+// addRemote adds a local note to Anki
+func (s *twoWaySync) addRemote(ctx context.Context, local Note) error {
+	model, err := pushModel(s.models, local)
+	if err != nil {
+		return err
+	}
+	remoteID, err := s.client.AddNoteContext(ctx, AnkiNote{
+		DeckName:  s.deck.name,
+		ModelName: model.Name,
+		Fields:    fieldMap(model.fieldNames(), local.Fields),
+		Tags:      local.Tags,
+		Options: map[string]interface{}{
+			"allowDuplicate": false,
+		},
+	})
+	if errors.Is(err, ErrDuplicate) {
+		s.deck.log(s.client).InfoContext(ctx, "skipped duplicate note", "note_id", local.ID)
+		s.report.Skipped = append(s.report.Skipped, local.ID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add note: %w", err)
+	}
+	if err := s.deck.setRemoteNoteIDs([]int64{local.ID}, []int64{remoteID}); err != nil {
+		return err
+	}
+	s.report.AddedRemote++
+	s.next[local.GUID] = NoteState{RemoteNoteID: remoteID, Hash: noteHash(local.Fields, local.Tags)}
+	return nil
+}
+
+// addLocal adds a note from Anki to the deck
+func (s *twoWaySync) addLocal(ctx context.Context, noteInfo NoteInfo) error {
+	model := s.remoteModel(noteInfo)
+	values := noteInfo.values(model.fieldNames())
+	tags := noteInfo.Tags
+	id, err := s.deck.insertModelNote(model.ID, model.cardOrds(values), values, tags)
+	if err != nil {
+		return fmt.Errorf("failed to add note: %w", err)
+	}
+	if err := s.deck.setRemoteNoteIDs([]int64{id}, []int64{noteInfo.NoteID}); err != nil {
+		return err
+	}
+	note, err := s.deck.GetNote(id)
+	if err != nil {
+		return err
+	}
+	s.report.AddedLocal++
+	s.next[note.GUID] = NoteState{RemoteNoteID: noteInfo.NoteID, Hash: noteHash(values, tags)}
+	return nil
+}
+
+// resolve decides a conflict and records it in the report
+func (s *twoWaySync) resolve(conflict SyncConflict) ConflictPolicy {
+	conflict.Resolution = s.opts.ConflictPolicy
+	if s.opts.OnConflict != nil {
+		conflict.Resolution = s.opts.OnConflict(conflict)
+	}
+	s.report.Conflicts = append(s.report.Conflicts, conflict)
+	return conflict.Resolution
+}
+
+// noteHash hashes the content of a note. Tags are compared ignoring order and
+// case, as Anki does.
+func noteHash(fields, tags []string) string {
+	normalized := make([]string, len(tags))
+	for i, tag := range tags {
+		normalized[i] = strings.ToLower(tag)
+	}
+	sort.Strings(normalized)
+	data := strings.Join(fields, separator) + "\x00" + strings.Join(normalized, " ")
+	return fmt.Sprintf("%x", sha1.Sum([]byte(data)))
+}
+
+// values returns the note's field values in the order of fieldNames
+func (n NoteInfo) values(fieldNames []string) []string {
+	values := make([]string, len(fieldNames))
+	for i, name := range fieldNames {
+		values[i] = n.Fields[name].Value
+	}
+	return values
+}
@@ -0,0 +1,266 @@
+package anki
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeAnkiNote is a note held by fakeAnki
+type fakeAnkiNote struct {
+	fields map[string]string
+	tags   []string
+}
+
+// fakeAnki serves the AnkiConnect actions used by TwoWaySync from memory
+func fakeAnki(t *testing.T, notes map[int64]*fakeAnkiNote) *httptest.Server {
+	nextID := int64(1000)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ankiRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		params, _ := req.Params.(map[string]interface{})
+		ids := func() []int64 {
+			var ids []int64
+			for _, id := range params["notes"].([]interface{}) {
+				ids = append(ids, int64(id.(float64)))
+			}
+			return ids
+		}
+
+		var resp ankiResponse
+		switch req.Action {
+		case "version":
+			resp.Result = float64(6)
+		case "createDeck":
+			resp.Result = float64(1)
+		case "modelNames":
+			resp.Result = []interface{}{"Test Deck"}
+		case "updateModelTemplates", "updateModelStyling":
+		case "modelFieldNames":
+			resp.Result = []interface{}{"Front", "Back"}
+		case "modelTemplates":
+			resp.Result = map[string]interface{}{
+				"Card 1": map[string]interface{}{"Front": "{{Front}}", "Back": "{{Back}}"},
+			}
+		case "modelStyling":
+			resp.Result = map[string]interface{}{"css": ".card {}"}
+		case "findNotes":
+			result := []interface{}{}
+			for id := range notes {
+				result = append(result, float64(id))
+			}
+			resp.Result = result
+		case "notesInfo":
+			var result []interface{}
+			for _, id := range ids() {
+				note := notes[id]
+				fields := make(map[string]interface{})
+				for name, value := range note.fields {
+					fields[name] = map[string]interface{}{"value": value}
+				}
+				var tags []interface{}
+				for _, tag := range note.tags {
+					tags = append(tags, tag)
+				}
+				result = append(result, map[string]interface{}{
+					"noteId":    float64(id),
+					"modelName": "Test Deck",
+					"fields":    fields,
+					"tags":      tags,
+				})
+			}
+			resp.Result = result
+		case "addNote":
+			note := params["note"].(map[string]interface{})
+			added := &fakeAnkiNote{fields: make(map[string]string)}
+			for name, value := range note["fields"].(map[string]interface{}) {
+				added.fields[name] = value.(string)
+			}
+			if tags, ok := note["tags"].([]interface{}); ok {
+				for _, tag := range tags {
+					added.tags = append(added.tags, tag.(string))
+				}
+			}
+			nextID++
+			notes[nextID] = added
+			resp.Result = float64(nextID)
+		case "updateNoteFields":
+			note := params["note"].(map[string]interface{})
+			id := int64(note["id"].(float64))
+			for name, value := range note["fields"].(map[string]interface{}) {
+				notes[id].fields[name] = value.(string)
+			}
+		case "addTags":
+			for _, id := range ids() {
+				notes[id].tags = append(notes[id].tags, strings.Fields(params["tags"].(string))...)
+			}
+		case "removeTags":
+			for _, id := range ids() {
+				var kept []string
+				for _, tag := range notes[id].tags {
+					if !strings.Contains(" "+params["tags"].(string)+" ", " "+tag+" ") {
+						kept = append(kept, tag)
+					}
+				}
+				notes[id].tags = kept
+			}
+		case "deleteNotes":
+			for _, id := range ids() {
+				delete(notes, id)
+			}
+		default:
+			t.Errorf("unexpected action: %s", req.Action)
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Fatal(err)
+		}
+	}))
+}
+
+func TestDeck_TwoWaySync(t *testing.T) {
+	remote := make(map[int64]*fakeAnkiNote)
+	server := fakeAnki(t, remote)
+	defer server.Close()
+	ac := NewAnkiConnectWithURL(server.URL)
+
+	deck, err := NewDeck("Test Deck")
+	if err != nil {
+		t.Fatalf("Failed to create deck: %v", err)
+	}
+	defer deck.Close()
+
+	if err := deck.AddCard("Q1", "A1"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+	if err := deck.AddCard("Q2", "A2"); err != nil {
+		t.Fatalf("Failed to add card: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "sync.json")
+	state, err := LoadSyncState(path)
+	if err != nil {
+		t.Fatalf("LoadSyncState failed: %v", err)
+	}
+
+	report, err := deck.TwoWaySync(ac, state, nil)
+	if err != nil {
+		t.Fatalf("TwoWaySync failed: %v", err)
+	}
+	if report.AddedRemote != 2 || len(remote) != 2 || len(state.Notes) != 2 {
+		t.Fatalf("expected 2 notes added to Anki, got report %+v and %d notes", report, len(remote))
+	}
+	if err := state.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	state, err = LoadSyncState(path)
+	if err != nil || len(state.Notes) != 2 {
+		t.Fatalf("expected saved state with 2 notes, got %v, %v", state, err)
+	}
+
+	findRemote := func(front string) (int64, *fakeAnkiNote) {
+		for id, note := range remote {
+			if note.fields["Front"] == front {
+				return id, note
+			}
+		}
+		t.Fatalf("note %s not found in Anki", front)
+		return 0, nil
+	}
+	findLocal := func(front string) Note {
+		notes, err := deck.Notes()
+		if err != nil {
+			t.Fatalf("Notes failed: %v", err)
+		}
+		for _, note := range notes {
+			if note.Fields[0] == front {
+				return note
+			}
+		}
+		t.Fatalf("note %s not found locally", front)
+		return Note{}
+	}
+
+	// Edits on one side are copied to the other, new notes in Anki are pulled
+	_, q1 := findRemote("Q1")
+	q1.fields["Back"] = "A1 remote"
+	q2 := findLocal("Q2")
+	q2.Fields[1] = "A2 local"
+	q2.Tags = []string{"edited"}
+	if err := deck.UpdateNote(&q2); err != nil {
+		t.Fatalf("UpdateNote failed: %v", err)
+	}
+	remote[1] = &fakeAnkiNote{fields: map[string]string{"Front": "Q3", "Back": "A3"}}
+
+	report, err = deck.TwoWaySync(ac, state, nil)
+	if err != nil {
+		t.Fatalf("TwoWaySync failed: %v", err)
+	}
+	if report.Pulled != 1 || report.Pushed != 1 || report.AddedLocal != 1 || len(report.Conflicts) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if back := findLocal("Q1").Fields[1]; back != "A1 remote" {
+		t.Errorf("expected local Q1 to be pulled, got %q", back)
+	}
+	if _, note := findRemote("Q2"); note.fields["Back"] != "A2 local" || len(note.tags) != 1 {
+		t.Errorf("expected Q2 to be pushed, got %+v", note)
+	}
+	findLocal("Q3")
+
+	// Conflicts go to the callback
+	_, q1 = findRemote("Q1")
+	q1.fields["Back"] = "A1 remote again"
+	local := findLocal("Q1")
+	local.Fields[1] = "A1 local again"
+	if err := deck.UpdateNote(&local); err != nil {
+		t.Fatalf("UpdateNote failed: %v", err)
+	}
+
+	var conflict SyncConflict
+	report, err = deck.TwoWaySync(ac, state, &TwoWaySyncOptions{
+		OnConflict: func(c SyncConflict) ConflictPolicy {
+			conflict = c
+			return RemoteWins
+		},
+	})
+	if err != nil {
+		t.Fatalf("TwoWaySync failed: %v", err)
+	}
+	if len(report.Conflicts) != 1 || report.Conflicts[0].Resolution != RemoteWins {
+		t.Fatalf("expected 1 conflict resolved for Anki, got %+v", report.Conflicts)
+	}
+	if conflict.Local["Back"] != "A1 local again" || conflict.Remote["Back"] != "A1 remote again" {
+		t.Errorf("unexpected conflict %+v", conflict)
+	}
+	if back := findLocal("Q1").Fields[1]; back != "A1 remote again" {
+		t.Errorf("expected Anki to win, got %q", back)
+	}
+
+	// Unchanged notes deleted on one side are deleted on the other
+	delete(remote, 1)
+	q2 = findLocal("Q2")
+	if err := deck.DeleteNote(q2.ID); err != nil {
+		t.Fatalf("DeleteNote failed: %v", err)
+	}
+
+	report, err = deck.TwoWaySync(ac, state, nil)
+	if err != nil {
+		t.Fatalf("TwoWaySync failed: %v", err)
+	}
+	if report.DeletedLocal != 1 || report.DeletedRemote != 1 {
+		t.Errorf("expected one deletion each way, got %+v", report)
+	}
+	notes, err := deck.Notes()
+	if err != nil {
+		t.Fatalf("Notes failed: %v", err)
+	}
+	if len(notes) != 1 || len(remote) != 1 || len(state.Notes) != 1 {
+		t.Errorf("expected only Q1 to remain, got %d local, %d remote, %d state", len(notes), len(remote), len(state.Notes))
+	}
+}